@@ -0,0 +1,79 @@
+package handlers
+
+import (
+    "net/http"
+    "strconv"
+    "strings"
+
+    "github.com/gin-gonic/gin"
+    "github.com/read-my-name/restful_todo_app/pkg/models"
+)
+
+// etag is the entity tag of a todo: its version, quoted.
+func etag(t models.Todo) string {
+    return `"` + strconv.Itoa(t.Version) + `"`
+}
+
+// writeTodo responds with a todo and its ETag.
+func writeTodo(c *gin.Context, status int, t models.Todo) {
+    c.Header("ETag", etag(t))
+    c.JSON(status, t)
+}
+
+// entityTags splits a comma separated If-Match or If-None-Match header
+// into its entity tags.
+func entityTags(header string) []string {
+    var tags []string
+    for _, tag := range strings.Split(header, ",") {
+        if tag = strings.TrimSpace(tag); tag != "" {
+            tags = append(tags, tag)
+        }
+    }
+    return tags
+}
+
+// parseIfMatch returns the versions named by an If-Match header, for
+// service.WriteOptions.IfMatch: nil without the header and empty for "*".
+// If-Match compares strongly, so weak and foreign tags become version 0,
+// which no todo has.
+func parseIfMatch(header string) []int {
+    tags := entityTags(header)
+    if len(tags) == 0 {
+        return nil
+    }
+    versions := []int{}
+    for _, tag := range tags {
+        if tag == "*" {
+            return []int{}
+        }
+        versions = append(versions, versionOf(tag))
+    }
+    return versions
+}
+
+// versionOf reads the version from a strong entity tag, or returns 0.
+func versionOf(tag string) int {
+    if len(tag) < 2 || tag[0] != '"' || tag[len(tag)-1] != '"' {
+        return 0
+    }
+    version, err := strconv.Atoi(tag[1 : len(tag)-1])
+    if err != nil || version < 1 {
+        return 0
+    }
+    return version
+}
+
+// notModified answers a GET with 304 when the If-None-Match header names
+// the current version of t. If-None-Match compares weakly, so W/ prefixes
+// are ignored.
+func notModified(c *gin.Context, t models.Todo) bool {
+    current := etag(t)
+    for _, tag := range entityTags(c.GetHeader("If-None-Match")) {
+        if tag == "*" || strings.TrimPrefix(tag, "W/") == current {
+            c.Header("ETag", current)
+            c.Status(http.StatusNotModified)
+            return true
+        }
+    }
+    return false
+}
@@ -0,0 +1,148 @@
+package handlers
+
+import (
+    "encoding/json"
+    "net/http"
+    "reflect"
+    "testing"
+
+    "github.com/read-my-name/restful_todo_app/internal/service"
+    "github.com/read-my-name/restful_todo_app/pkg/models"
+)
+
+const testUpdateJSON = `{"title":"Write the report","status":"in_progress","priority":"high","due_date":"2099-01-01T00:00:00Z"}`
+
+func TestParseIfMatch(t *testing.T) {
+    tests := []struct {
+        header string
+        want   []int
+    }{
+        {"", nil},
+        {" ", nil},
+        {"*", []int{}},
+        {`"3"`, []int{3}},
+        {`"3", "5"`, []int{3, 5}},
+        {`"3", *`, []int{}},
+        {`W/"3"`, []int{0}},
+        {`3`, []int{0}},
+        {`"abc"`, []int{0}},
+        {`"0"`, []int{0}},
+    }
+    for _, tt := range tests {
+        if got := parseIfMatch(tt.header); !reflect.DeepEqual(got, tt.want) {
+            t.Errorf("%q: got %#v, want %#v", tt.header, got, tt.want)
+        }
+    }
+}
+
+func TestETagHeader(t *testing.T) {
+    router := newTestRouter(t)
+    w := serve(router, "POST", "/todos?sync=true", testTodoJSON)
+    if w.Code != http.StatusCreated || w.Header().Get("ETag") != `"1"` {
+        t.Fatalf("POST: %d with ETag %q", w.Code, w.Header().Get("ETag"))
+    }
+    var todo models.Todo
+    if err := json.Unmarshal(w.Body.Bytes(), &todo); err != nil {
+        t.Fatal(err)
+    }
+    path := "/todos/" + todo.ID
+
+    steps := []struct {
+        method, path, body, etag string
+    }{
+        {"GET", path, "", `"1"`},
+        {"PUT", path, testUpdateJSON, `"2"`},
+        {"PATCH", path, `{"description":"for finance"}`, `"3"`},
+        {"POST", path + "/transitions", `{"action":"hold"}`, `"4"`},
+        {"GET", path, "", `"4"`},
+    }
+    for _, step := range steps {
+        w := serve(router, step.method, step.path, step.body)
+        if w.Code != http.StatusOK || w.Header().Get("ETag") != step.etag {
+            t.Fatalf("%s %s: %d with ETag %q, want %s: %s", step.method, step.path, w.Code, w.Header().Get("ETag"), step.etag, w.Body)
+        }
+    }
+}
+
+func TestIfNoneMatch(t *testing.T) {
+    router := newTestRouter(t)
+    todo := createTestTodo(t, router, testTodoJSON)
+    path := "/todos/" + todo.ID
+
+    for _, header := range []string{`"1"`, `W/"1"`, `*`, `"7", "1"`} {
+        w := serve(router, "GET", path, "", "If-None-Match", header)
+        if w.Code != http.StatusNotModified || w.Body.Len() != 0 || w.Header().Get("ETag") != `"1"` {
+            t.Errorf("%s: got %d with ETag %q and body %q, want 304", header, w.Code, w.Header().Get("ETag"), w.Body)
+        }
+    }
+
+    if w := serve(router, "PUT", path, testUpdateJSON); w.Code != http.StatusOK {
+        t.Fatalf("PUT: %d %s", w.Code, w.Body)
+    }
+    for _, header := range []string{`"1"`, `W/"1"`, `"2x"`} {
+        w := serve(router, "GET", path, "", "If-None-Match", header)
+        if w.Code != http.StatusOK || w.Header().Get("ETag") != `"2"` {
+            t.Errorf("%s after an update: got %d with ETag %q, want 200", header, w.Code, w.Header().Get("ETag"))
+        }
+    }
+}
+
+func TestIfMatch(t *testing.T) {
+    router := newTestRouter(t)
+    todo := createTestTodo(t, router, testTodoJSON)
+    path := "/todos/" + todo.ID
+
+    if w := serve(router, "PUT", path, testUpdateJSON, "If-Match", `"1"`); w.Code != http.StatusOK || w.Header().Get("ETag") != `"2"` {
+        t.Fatalf("PUT with the current version: %d %s", w.Code, w.Body)
+    }
+
+    // Version 1 is stale now; a weak tag never matches.
+    for _, req := range []struct{ method, path, body, header string }{
+        {"PUT", path, testUpdateJSON, `"1"`},
+        {"PATCH", path, `{"title":"Stale"}`, `"1"`},
+        {"PATCH", path, `{"title":"Stale"}`, `W/"2"`},
+        {"POST", path + "/transitions", `{"action":"complete"}`, `"1", "3"`},
+        {"DELETE", path, "", `"1"`},
+    } {
+        w := serve(router, req.method, req.path, req.body, "If-Match", req.header)
+        decodeProblem(t, w, http.StatusPreconditionFailed)
+    }
+    if w := serve(router, "GET", path, ""); w.Header().Get("ETag") != `"2"` {
+        t.Fatalf("a refused write changed the todo: %s", w.Body)
+    }
+
+    if w := serve(router, "PATCH", path, `{"title":"Any version"}`, "If-Match", "*"); w.Code != http.StatusOK || w.Header().Get("ETag") != `"3"` {
+        t.Fatalf("PATCH with If-Match *: %d %s", w.Code, w.Body)
+    }
+    if w := serve(router, "PATCH", path, `{"title":"Listed"}`, "If-Match", `"1", "3"`); w.Code != http.StatusOK {
+        t.Fatalf("PATCH listing the current version: %d %s", w.Code, w.Body)
+    }
+    if w := serve(router, "DELETE", path, "", "If-Match", `"4"`); w.Code != http.StatusOK {
+        t.Fatalf("DELETE with the current version: %d %s", w.Code, w.Body)
+    }
+    decodeProblem(t, serve(router, "PUT", path, testUpdateJSON, "If-Match", "*"), http.StatusNotFound)
+}
+
+func TestRequireIfMatch(t *testing.T) {
+    router := newTestRouter(t, service.WithRequireIfMatch(true))
+    // Creating a todo has no version to name.
+    todo := createTestTodo(t, router, testTodoJSON)
+    path := "/todos/" + todo.ID
+
+    for _, req := range []struct{ method, path, body string }{
+        {"PUT", path, testUpdateJSON},
+        {"PATCH", path, `{"title":"Unconditional"}`},
+        {"POST", path + "/transitions", `{"action":"start"}`},
+        {"DELETE", path, ""},
+    } {
+        decodeProblem(t, serve(router, req.method, req.path, req.body), http.StatusPreconditionRequired)
+    }
+
+    decodeProblem(t, serve(router, "PUT", path, testUpdateJSON, "If-Match", `"2"`), http.StatusPreconditionFailed)
+    if w := serve(router, "PUT", path, testUpdateJSON, "If-Match", `"1"`); w.Code != http.StatusOK {
+        t.Fatalf("PUT with If-Match: %d %s", w.Code, w.Body)
+    }
+    if w := serve(router, "DELETE", path, "", "If-Match", "*"); w.Code != http.StatusOK {
+        t.Fatalf("DELETE with If-Match *: %d %s", w.Code, w.Body)
+    }
+}
@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/read-my-name/restful_todo_app/internal/service"
+)
+
+// retryAfter is the Retry-After, in seconds, sent when the save queue is full.
+const retryAfter = 1
+
+// problem is an RFC 7807 problem details object, the body of every error
+// response.
+type problem struct {
+    Type     string `json:"type"`
+    Title    string `json:"title"`
+    Status   int    `json:"status"`
+    Detail   string `json:"detail,omitempty"`
+    Instance string `json:"instance,omitempty"`
+
+    // Fields lists the invalid values of a 422 response.
+    Fields []service.FieldError `json:"fields,omitempty"`
+}
+
+// errorStatuses maps service errors to response statuses. The first match
+// wins; anything else is a 500.
+var errorStatuses = []struct {
+    err    error
+    status int
+}{
+    {service.ErrNotFound, http.StatusNotFound},
+    {service.ErrValidation, http.StatusUnprocessableEntity},
+    {service.ErrPreconditionFailed, http.StatusPreconditionFailed},
+    {service.ErrPreconditionRequired, http.StatusPreconditionRequired},
+    {service.ErrConflict, http.StatusConflict},
+    {service.ErrBackpressure, http.StatusServiceUnavailable},
+    {service.ErrClosed, http.StatusServiceUnavailable},
+    {service.ErrInvalidFilter, http.StatusBadRequest},
+    {service.ErrInvalidPatch, http.StatusBadRequest},
+    {service.ErrUnknownAction, http.StatusBadRequest},
+}
+
+// writeError reports a service error as a problem with the status it maps
+// to. Validation problems list the invalid fields, and backpressure asks
+// the client to retry.
+func writeError(c *gin.Context, err error) {
+    status := http.StatusInternalServerError
+    for _, m := range errorStatuses {
+        if errors.Is(err, m.err) {
+            status = m.status
+            break
+        }
+    }
+
+    p := newProblem(c, status, err.Error())
+    var validationErr *service.ValidationError
+    if errors.As(err, &validationErr) {
+        p.Fields = validationErr.Fields
+    }
+    if errors.Is(err, service.ErrBackpressure) {
+        c.Header("Retry-After", strconv.Itoa(retryAfter))
+    }
+    if status == http.StatusInternalServerError {
+        slog.Error("Request failed", "method", c.Request.Method, "path", c.Request.URL.Path, "err", err)
+    }
+    writeProblem(c, p)
+}
+
+// badRequest reports a request the handler could not parse.
+func badRequest(c *gin.Context, err error) {
+    writeProblem(c, newProblem(c, http.StatusBadRequest, err.Error()))
+}
+
+func newProblem(c *gin.Context, status int, detail string) problem {
+    return problem{
+        Type:     "about:blank",
+        Title:    http.StatusText(status),
+        Status:   status,
+        Detail:   detail,
+        Instance: c.Request.URL.Path,
+    }
+}
+
+func writeProblem(c *gin.Context, p problem) {
+    body, err := json.Marshal(p)
+    if err != nil {
+        c.AbortWithStatus(http.StatusInternalServerError)
+        return
+    }
+    c.Data(p.Status, "application/problem+json", body)
+}
@@ -0,0 +1,64 @@
+package handlers
+
+import (
+    "errors"
+    "fmt"
+    "net/http"
+    "net/http/httptest"
+    "testing"
+
+    "github.com/gin-gonic/gin"
+    "github.com/read-my-name/restful_todo_app/internal/service"
+)
+
+func TestWriteError(t *testing.T) {
+    gin.SetMode(gin.TestMode)
+    tests := []struct {
+        err    error
+        status int
+    }{
+        {service.ErrNotFound, http.StatusNotFound},
+        {service.ErrValidation, http.StatusUnprocessableEntity},
+        {&service.ValidationError{Fields: []service.FieldError{{Field: "title", Code: service.CodeTooLong, Max: 100}}}, http.StatusUnprocessableEntity},
+        {service.ErrConflict, http.StatusConflict},
+        {service.ErrInvalidTransition, http.StatusConflict},
+        // A conflict too, but reported as its own status.
+        {service.ErrPreconditionFailed, http.StatusPreconditionFailed},
+        {service.ErrPreconditionRequired, http.StatusPreconditionRequired},
+        {service.ErrBackpressure, http.StatusServiceUnavailable},
+        {service.ErrClosed, http.StatusServiceUnavailable},
+        {service.ErrInvalidFilter, http.StatusBadRequest},
+        {service.ErrInvalidPatch, http.StatusBadRequest},
+        {service.ErrUnknownAction, http.StatusBadRequest},
+        {errors.New("disk on fire"), http.StatusInternalServerError},
+    }
+    for _, tt := range tests {
+        w := httptest.NewRecorder()
+        c, _ := gin.CreateTestContext(w)
+        c.Request = httptest.NewRequest("PUT", "/todos/a", nil)
+        err := fmt.Errorf("todo a: %w", tt.err)
+        writeError(c, err)
+
+        p := decodeProblem(t, w, tt.status)
+        if p.Detail != err.Error() || p.Instance != "/todos/a" {
+            t.Errorf("%v: got problem %+v", tt.err, p)
+        }
+        // Only a ValidationError has fields to list.
+        if _, fields := tt.err.(*service.ValidationError); (len(p.Fields) > 0) != fields {
+            t.Errorf("%v: got fields %+v", tt.err, p.Fields)
+        }
+        if retry := w.Header().Get("Retry-After"); (retry != "") != (tt.err == service.ErrBackpressure) {
+            t.Errorf("%v: got Retry-After %q", tt.err, retry)
+        }
+    }
+}
+
+func TestBadRequest(t *testing.T) {
+    w := httptest.NewRecorder()
+    c, _ := gin.CreateTestContext(w)
+    c.Request = httptest.NewRequest("GET", "/todos?status=bogus", nil)
+    badRequest(c, errors.New("unknown status bogus"))
+    if p := decodeProblem(t, w, http.StatusBadRequest); p.Detail != "unknown status bogus" || p.Instance != "/todos" {
+        t.Errorf("got problem %+v", p)
+    }
+}
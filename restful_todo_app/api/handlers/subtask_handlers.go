@@ -0,0 +1,94 @@
+package handlers
+
+import (
+    "net/http"
+
+    "github.com/gin-gonic/gin"
+    "github.com/read-my-name/restful_todo_app/pkg/models"
+)
+
+// AddSubtask creates a subtask on the todo and returns the updated todo.
+func (h *TodoHandler) AddSubtask(c *gin.Context) {
+    var subtask models.Subtask
+    if err := c.BindJSON(&subtask); err != nil {
+        badRequest(c, err)
+        return
+    }
+
+    opts, err := writeOptions(c)
+    if err != nil {
+        badRequest(c, err)
+        return
+    }
+
+    todo, err := h.service.AddSubtask(c.Request.Context(), c.Param("id"), subtask, opts)
+    if err != nil {
+        writeError(c, err)
+        return
+    }
+
+    writeTodo(c, http.StatusCreated, todo)
+}
+
+// PatchSubtask applies a JSON Merge Patch to one subtask.
+func (h *TodoHandler) PatchSubtask(c *gin.Context) {
+    patch, ok := readMergePatch(c)
+    if !ok {
+        return
+    }
+
+    opts, err := writeOptions(c)
+    if err != nil {
+        badRequest(c, err)
+        return
+    }
+
+    todo, err := h.service.PatchSubtask(c.Request.Context(), c.Param("id"), c.Param("subID"), patch, opts)
+    if err != nil {
+        writeError(c, err)
+        return
+    }
+
+    writeTodo(c, http.StatusOK, todo)
+}
+
+func (h *TodoHandler) DeleteSubtask(c *gin.Context) {
+    opts, err := writeOptions(c)
+    if err != nil {
+        badRequest(c, err)
+        return
+    }
+
+    todo, err := h.service.DeleteSubtask(c.Request.Context(), c.Param("id"), c.Param("subID"), opts)
+    if err != nil {
+        writeError(c, err)
+        return
+    }
+
+    writeTodo(c, http.StatusOK, todo)
+}
+
+// ReorderSubtasks takes {"ids": [...]} listing every subtask in its new order.
+func (h *TodoHandler) ReorderSubtasks(c *gin.Context) {
+    var body struct {
+        IDs []string `json:"ids"`
+    }
+    if err := c.BindJSON(&body); err != nil {
+        badRequest(c, err)
+        return
+    }
+
+    opts, err := writeOptions(c)
+    if err != nil {
+        badRequest(c, err)
+        return
+    }
+
+    todo, err := h.service.ReorderSubtasks(c.Request.Context(), c.Param("id"), body.IDs, opts)
+    if err != nil {
+        writeError(c, err)
+        return
+    }
+
+    writeTodo(c, http.StatusOK, todo)
+}
@@ -0,0 +1,90 @@
+package handlers
+
+import (
+    "encoding/json"
+    "net/http"
+    "net/http/httptest"
+    "reflect"
+    "strings"
+    "testing"
+
+    "github.com/gin-gonic/gin"
+    "github.com/read-my-name/restful_todo_app/pkg/models"
+)
+
+// addTestSubtasks adds subtasks with the titles to the todo and returns
+// their IDs.
+func addTestSubtasks(t *testing.T, router *gin.Engine, id string, titles ...string) []string {
+    t.Helper()
+    var ids []string
+    for _, title := range titles {
+        w := serve(router, "POST", "/todos/"+id+"/subtasks?sync=true", `{"title":"`+title+`"}`)
+        if w.Code != http.StatusCreated {
+            t.Fatalf("adding subtask %s: %d %s", title, w.Code, w.Body)
+        }
+        var todo models.Todo
+        if err := json.Unmarshal(w.Body.Bytes(), &todo); err != nil {
+            t.Fatal(err)
+        }
+        ids = append(ids, todo.Subtasks[len(todo.Subtasks)-1].ID)
+    }
+    return ids
+}
+
+func subtaskIDs(t *testing.T, w *httptest.ResponseRecorder) []string {
+    t.Helper()
+    var todo models.Todo
+    if err := json.Unmarshal(w.Body.Bytes(), &todo); err != nil {
+        t.Fatal(err)
+    }
+    ids := []string{}
+    for _, st := range todo.Subtasks {
+        ids = append(ids, st.ID)
+    }
+    return ids
+}
+
+func TestReorderSubtasks(t *testing.T) {
+    router := newTestRouter(t)
+    todo := createTestTodo(t, router, testTodoJSON)
+    ids := addTestSubtasks(t, router, todo.ID, "Draft", "Review", "Send")
+    path := "/todos/" + todo.ID + "/subtasks/order"
+    order := func(ids ...string) string {
+        return `{"ids":["` + strings.Join(ids, `","`) + `"]}`
+    }
+
+    want := []string{ids[2], ids[0], ids[1]}
+    w := serve(router, "PUT", path, order(want...))
+    if w.Code != http.StatusOK {
+        t.Fatalf("reorder: %d %s", w.Code, w.Body)
+    }
+    if got := subtaskIDs(t, w); !reflect.DeepEqual(got, want) {
+        t.Fatalf("got order %q, want %q", got, want)
+    }
+
+    // Deleted since the client read the todo.
+    if w := serve(router, "DELETE", "/todos/"+todo.ID+"/subtasks/"+ids[1], ""); w.Code != http.StatusOK {
+        t.Fatalf("delete: %d %s", w.Code, w.Body)
+    }
+    tests := []struct {
+        name   string
+        body   string
+        status int
+    }{
+        {"missing", order(ids[2]), http.StatusBadRequest},
+        {"repeated", order(ids[2], ids[2]), http.StatusBadRequest},
+        {"repeated with all listed", order(ids[2], ids[0], ids[2]), http.StatusBadRequest},
+        {"none", `{"ids":[]}`, http.StatusBadRequest},
+        {"stale", order(ids[2], ids[0], ids[1]), http.StatusNotFound},
+        {"stale instead of a listed one", order(ids[2], ids[1]), http.StatusNotFound},
+    }
+    for _, tt := range tests {
+        decodeProblem(t, serve(router, "PUT", path, tt.body), tt.status)
+    }
+    decodeProblem(t, serve(router, "PUT", "/todos/missing/subtasks/order", order(ids[0])), http.StatusNotFound)
+
+    w = serve(router, "GET", "/todos/"+todo.ID, "")
+    if got := subtaskIDs(t, w); !reflect.DeepEqual(got, []string{ids[2], ids[0]}) {
+        t.Fatalf("refused reorders changed the order to %q", got)
+    }
+}
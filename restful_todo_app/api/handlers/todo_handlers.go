@@ -2,7 +2,10 @@ package handlers
 
 import (
 	"fmt"
+	"log/slog"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -18,38 +21,225 @@ func NewTodoHandler(svc *service.TodoService) *TodoHandler {
     return &TodoHandler{service: svc}
 }
 
-func (h *TodoHandler) GetTodos(c *gin.Context) {
+// writeOptions reads the optional ?sync=true flag, which makes a write wait
+// until it has been persisted to storage, and the If-Match header, which
+// makes it conditional on the version of the todo.
+func writeOptions(c *gin.Context) (service.WriteOptions, error) {
+    opts := service.WriteOptions{IfMatch: parseIfMatch(c.GetHeader("If-Match"))}
+    if raw := c.Query("sync"); raw != "" {
+        sync, err := strconv.ParseBool(raw)
+        if err != nil {
+            return opts, fmt.Errorf("invalid sync value: %s", raw)
+        }
+        opts.Sync = sync
+    }
+    return opts, nil
+}
+
+func (h *TodoHandler) GetTodo(c *gin.Context) {
+    todo, err := h.service.GetTodo(c.Param("id"))
+    if err != nil {
+        writeError(c, err)
+        return
+    }
+    if notModified(c, todo) {
+        return
+    }
+
+    writeTodo(c, http.StatusOK, todo)
+}
+
+// queryList collects every value of a query parameter, accepting both
+// repeated keys (?label=a&label=b) and comma separated lists (?label=a,b).
+func queryList(c *gin.Context, key string) []string {
+    var values []string
+    for _, raw := range c.QueryArray(key) {
+        for _, v := range strings.Split(raw, ",") {
+            if v = strings.TrimSpace(v); v != "" {
+                values = append(values, v)
+            }
+        }
+    }
+    return values
+}
+
+// timezoneHeader lets clients pick the time zone for calendar periods
+// when they cannot pass ?tz=.
+const timezoneHeader = "X-Timezone"
+
+// parseLocation reads the IANA time zone from ?tz= or the X-Timezone
+// header, returning nil when neither is set.
+func parseLocation(c *gin.Context) (*time.Location, error) {
+    name := c.Query("tz")
+    if name == "" {
+        name = c.GetHeader(timezoneHeader)
+    }
+    if name == "" {
+        return nil, nil
+    }
+    loc, err := time.LoadLocation(name)
+    if err != nil {
+        return nil, fmt.Errorf("invalid time zone: %s", name)
+    }
+    return loc, nil
+}
+
+// parseDueBound accepts either an RFC 3339 timestamp or a plain date,
+// which is taken as midnight in loc.
+func parseDueBound(key, raw string, loc *time.Location) (time.Time, error) {
+    if t, err := time.Parse(time.RFC3339, raw); err == nil {
+        return t, nil
+    }
+    if loc == nil {
+        loc = time.Local
+    }
+    t, err := time.ParseInLocation("2006-01-02", raw, loc)
+    if err != nil {
+        return time.Time{}, fmt.Errorf("invalid %s: %s", key, raw)
+    }
+    return t, nil
+}
+
+// parseFilter builds a TodoFilter from the period, status, priority, label,
+// due_after, due_before and tz query parameters.
+func parseFilter(c *gin.Context) (models.TodoFilter, error) {
     filter := models.TodoFilter{
         Period: c.DefaultQuery("period", "all"),
+        Labels: queryList(c, "label"),
     }
-    
-    todos, err := h.service.GetTodos(filter)
+    for _, v := range queryList(c, "status") {
+        filter.Statuses = append(filter.Statuses, models.Status(v))
+    }
+    for _, v := range queryList(c, "priority") {
+        filter.Priorities = append(filter.Priorities, models.Priority(v))
+    }
+
+    loc, err := parseLocation(c)
+    if err != nil {
+        return filter, err
+    }
+    filter.Location = loc
+
+    if raw := c.Query("due_after"); raw != "" {
+        if filter.DueAfter, err = parseDueBound("due_after", raw, loc); err != nil {
+            return filter, err
+        }
+    }
+    if raw := c.Query("due_before"); raw != "" {
+        if filter.DueBefore, err = parseDueBound("due_before", raw, loc); err != nil {
+            return filter, err
+        }
+    }
+    return filter, nil
+}
+
+// parseListOptions reads the sort, limit, offset and cursor query parameters.
+func parseListOptions(c *gin.Context) (models.ListOptions, error) {
+    opts := models.ListOptions{
+        Sort:   c.Query("sort"),
+        Cursor: c.Query("cursor"),
+    }
+    if raw := c.Query("limit"); raw != "" {
+        limit, err := strconv.Atoi(raw)
+        if err != nil {
+            return opts, fmt.Errorf("invalid limit: %s", raw)
+        }
+        opts.Limit = limit
+    }
+    if raw := c.Query("offset"); raw != "" {
+        offset, err := strconv.Atoi(raw)
+        if err != nil {
+            return opts, fmt.Errorf("invalid offset: %s", raw)
+        }
+        opts.Offset = offset
+    }
+    return opts, nil
+}
+
+// pageLink returns the current request URL with the given query
+// parameters replaced; empty values remove the parameter.
+func pageLink(c *gin.Context, params map[string]string) string {
+    u := *c.Request.URL
+    query := u.Query()
+    for key, value := range params {
+        if value == "" {
+            query.Del(key)
+        } else {
+            query.Set(key, value)
+        }
+    }
+    u.RawQuery = query.Encode()
+    return u.RequestURI()
+}
+
+// listTodos writes one page of the todos matching filter, reporting
+// invalid filters or paging parameters as 400.
+func (h *TodoHandler) listTodos(c *gin.Context, filter models.TodoFilter) {
+    opts, err := parseListOptions(c)
     if err != nil {
-        c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+        badRequest(c, err)
         return
     }
-    
-    c.JSON(http.StatusOK, todos)
+
+    page, err := h.service.ListTodos(filter, opts)
+    if err != nil {
+        writeError(c, err)
+        return
+    }
+
+    limit := strconv.Itoa(page.Limit)
+    page.Links = map[string]string{
+        "self":  c.Request.URL.RequestURI(),
+        "first": pageLink(c, map[string]string{"limit": limit, "offset": "", "cursor": ""}),
+    }
+    if page.NextCursor != "" {
+        page.Links["next"] = pageLink(c, map[string]string{"limit": limit, "offset": "", "cursor": page.NextCursor})
+    }
+    if opts.Cursor == "" && page.Offset > 0 {
+        prev := page.Offset - page.Limit
+        if prev < 0 {
+            prev = 0
+        }
+        page.Links["prev"] = pageLink(c, map[string]string{"limit": limit, "offset": strconv.Itoa(prev), "cursor": ""})
+    }
+
+    c.JSON(http.StatusOK, page)
+}
+
+func (h *TodoHandler) GetTodos(c *gin.Context) {
+    filter, err := parseFilter(c)
+    if err != nil {
+        badRequest(c, err)
+        return
+    }
+
+    h.listTodos(c, filter)
 }
 
 func (h *TodoHandler) AddTodo(c *gin.Context) {
     var newTodo models.Todo
     if err := c.BindJSON(&newTodo); err != nil {
-        fmt.Printf("Error binding JSON: %v\n", err)
-        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        slog.Debug("Error binding JSON", "err", err)
+        badRequest(c, err)
+        return
+    }
+    
+    opts, err := writeOptions(c)
+    if err != nil {
+        badRequest(c, err)
         return
     }
     
-    newTodo.CreatedAt = time.Now()
-    fmt.Printf("Adding new todo: %+v\n", newTodo)
-    if err := h.service.AddTodo(newTodo); err != nil {
-        fmt.Printf("Error adding todo: %v\n", err)
-        c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+    slog.Debug("Adding new todo", "todo", newTodo)
+    created, err := h.service.AddTodo(c.Request.Context(), newTodo, opts)
+    if err != nil {
+        slog.Debug("Error adding todo", "err", err)
+        writeError(c, err)
         return
     }
     
-    fmt.Printf("Successfully added todo: %+v\n", newTodo)
-    c.JSON(http.StatusCreated, newTodo)
+    slog.Debug("Successfully added todo", "id", created.ID)
+    writeTodo(c, http.StatusCreated, created)
 }
 
 func (h *TodoHandler) UpdateTodo(c *gin.Context){
@@ -57,23 +247,104 @@ func (h *TodoHandler) UpdateTodo(c *gin.Context){
     
     var updatedTodo models.Todo
     if err := c.BindJSON(&updatedTodo); err != nil {
-        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        badRequest(c, err)
+        return
+    }
+
+    opts, err := writeOptions(c)
+    if err != nil {
+        badRequest(c, err)
+        return
+    }
+
+    saved, err := h.service.UpdateTodo(c.Request.Context(), idParam, updatedTodo, opts)
+    if err != nil {
+        writeError(c, err)
+        return
+    }
+
+    writeTodo(c, http.StatusOK, saved)
+}
+
+// readMergePatch returns the raw merge patch body, rejecting other media
+// types with 415. It reports false when a response has been written.
+func readMergePatch(c *gin.Context) ([]byte, bool) {
+    switch c.ContentType() {
+    case "application/merge-patch+json", "application/json":
+    default:
+        writeProblem(c, newProblem(c, http.StatusUnsupportedMediaType, "PATCH requires Content-Type application/merge-patch+json"))
+        return nil, false
+    }
+
+    patch, err := c.GetRawData()
+    if err != nil {
+        badRequest(c, err)
+        return nil, false
+    }
+    return patch, true
+}
+
+// PatchTodo applies a JSON Merge Patch (RFC 7396) to a todo, leaving the
+// fields that are not mentioned in the body untouched.
+func (h *TodoHandler) PatchTodo(c *gin.Context) {
+    idParam := c.Param("id")
+
+    patch, ok := readMergePatch(c)
+    if !ok {
+        return
+    }
+
+    opts, err := writeOptions(c)
+    if err != nil {
+        badRequest(c, err)
+        return
+    }
+
+    saved, err := h.service.PatchTodo(c.Request.Context(), idParam, patch, opts)
+    if err != nil {
+        writeError(c, err)
+        return
+    }
+
+    writeTodo(c, http.StatusOK, saved)
+}
+
+// TransitionTodo performs a workflow action such as {"action": "archive"}.
+func (h *TodoHandler) TransitionTodo(c *gin.Context) {
+    var body struct {
+        Action string `json:"action" binding:"required"`
+    }
+    if err := c.BindJSON(&body); err != nil {
+        badRequest(c, err)
         return
     }
 
-    if err := h.service.UpdateTodo(idParam, updatedTodo); err != nil {
-        c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()}) 
+    opts, err := writeOptions(c)
+    if err != nil {
+        badRequest(c, err)
         return
     }
 
-    c.JSON(http.StatusOK, updatedTodo)
+    todo, err := h.service.TransitionTodo(c.Request.Context(), c.Param("id"), body.Action, opts)
+    if err != nil {
+        writeError(c, err)
+        return
+    }
+
+    writeTodo(c, http.StatusOK, todo)
 }
 
 func (h *TodoHandler) DeleteTodo(c *gin.Context) {
     idParam := c.Param("id")   
 
-    if err := h.service.DeleteTodo(idParam); err != nil {
-        c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+    opts, err := writeOptions(c)
+    if err != nil {
+        badRequest(c, err)
+        return
+    }
+
+    if err := h.service.DeleteTodo(c.Request.Context(), idParam, opts); err != nil {
+        writeError(c, err)
         return
     }
 
@@ -81,15 +352,69 @@ func (h *TodoHandler) DeleteTodo(c *gin.Context) {
 }
 
 func (h *TodoHandler) GetTodayTodos(c *gin.Context) {
-    filter := models.TodoFilter{
-        Period: "today",
+    filter, err := parseFilter(c)
+    if err != nil {
+        badRequest(c, err)
+        return
     }
+    filter.Period = "today"
     
-    todos, err := h.service.GetTodos(filter)
+    h.listTodos(c, filter)
+}
+
+// GetPeriodTodos lists todos due in the period named by the :period path
+// parameter, e.g. /todos/period/week.
+func (h *TodoHandler) GetPeriodTodos(c *gin.Context) {
+    filter, err := parseFilter(c)
     if err != nil {
-        c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+        badRequest(c, err)
         return
     }
+    filter.Period = c.Param("period")
     
-    c.JSON(http.StatusOK, todos)
+    h.listTodos(c, filter)
+}
+
+// SearchTodos runs the full-text query in ?q=, e.g.
+// /todos/search?q=label:backend "rate limit" status:in_progress, and
+// writes one page of hits, best first. Pages are selected with limit and
+// offset.
+func (h *TodoHandler) SearchTodos(c *gin.Context) {
+    query := c.Query("q")
+    if strings.TrimSpace(query) == "" {
+        badRequest(c, fmt.Errorf("missing search query q"))
+        return
+    }
+    opts, err := parseListOptions(c)
+    if err != nil {
+        badRequest(c, err)
+        return
+    }
+
+    results, err := h.service.Search(query, opts)
+    if err != nil {
+        writeError(c, err)
+        return
+    }
+
+    limit := strconv.Itoa(results.Limit)
+    results.Links = map[string]string{
+        "self":  c.Request.URL.RequestURI(),
+        "first": pageLink(c, map[string]string{"limit": limit, "offset": ""}),
+    }
+    if next := results.Offset + len(results.Items); next < results.Total {
+        results.Links["next"] = pageLink(c, map[string]string{"limit": limit, "offset": strconv.Itoa(next)})
+    }
+    if results.Offset > 0 {
+        prev := max(results.Offset-results.Limit, 0)
+        results.Links["prev"] = pageLink(c, map[string]string{"limit": limit, "offset": strconv.Itoa(prev)})
+    }
+
+    c.JSON(http.StatusOK, results)
+}
+
+// GetStorageHealth reports how many stored rows were skipped while loading,
+// and why.
+func (h *TodoHandler) GetStorageHealth(c *gin.Context) {
+    c.JSON(http.StatusOK, h.service.StorageHealth())
 }
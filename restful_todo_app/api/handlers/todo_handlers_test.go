@@ -0,0 +1,170 @@
+package handlers
+
+import (
+    "context"
+    "encoding/json"
+    "net/http"
+    "net/http/httptest"
+    "path/filepath"
+    "reflect"
+    "strings"
+    "testing"
+
+    "github.com/gin-gonic/gin"
+    "github.com/read-my-name/restful_todo_app/internal/service"
+    "github.com/read-my-name/restful_todo_app/internal/storage"
+    "github.com/read-my-name/restful_todo_app/pkg/models"
+)
+
+// newTestRouter serves the todo routes of cmd/main.go from a service on
+// a CSV file in a temporary directory.
+func newTestRouter(t *testing.T, opts ...service.Option) *gin.Engine {
+    t.Helper()
+    gin.SetMode(gin.TestMode)
+    store := storage.NewCSVStorage(filepath.Join(t.TempDir(), "todos.csv"))
+    svc := service.NewTodoService(store, opts...)
+    t.Cleanup(func() {
+        svc.Close(context.Background())
+        store.Close()
+    })
+    if _, err := svc.LoadInitialData(); err != nil {
+        t.Fatal(err)
+    }
+
+    h := NewTodoHandler(svc)
+    router := gin.New()
+    router.GET("/todos", h.GetTodos)
+    router.POST("/todos", h.AddTodo)
+    router.GET("/todos/:id", h.GetTodo)
+    router.PUT("/todos/:id", h.UpdateTodo)
+    router.PATCH("/todos/:id", h.PatchTodo)
+    router.DELETE("/todos/:id", h.DeleteTodo)
+    router.POST("/todos/:id/transitions", h.TransitionTodo)
+    router.POST("/todos/:id/subtasks", h.AddSubtask)
+    router.PUT("/todos/:id/subtasks/order", h.ReorderSubtasks)
+    router.PATCH("/todos/:id/subtasks/:subID", h.PatchSubtask)
+    router.DELETE("/todos/:id/subtasks/:subID", h.DeleteSubtask)
+    return router
+}
+
+// serve sends a request with the given body and headers, given as name
+// and value pairs, and returns the response.
+func serve(router *gin.Engine, method, path, body string, headers ...string) *httptest.ResponseRecorder {
+    req := httptest.NewRequest(method, path, strings.NewReader(body))
+    if body != "" {
+        req.Header.Set("Content-Type", "application/json")
+    }
+    for i := 0; i+1 < len(headers); i += 2 {
+        req.Header.Set(headers[i], headers[i+1])
+    }
+    w := httptest.NewRecorder()
+    router.ServeHTTP(w, req)
+    return w
+}
+
+const testTodoJSON = `{"title":"Write report","status":"not_started","priority":"low","due_date":"2099-01-01T00:00:00Z"}`
+
+// createTestTodo adds a todo through POST /todos and returns it.
+func createTestTodo(t *testing.T, router *gin.Engine, body string) models.Todo {
+    t.Helper()
+    w := serve(router, "POST", "/todos?sync=true", body)
+    if w.Code != http.StatusCreated {
+        t.Fatalf("POST /todos: %d %s", w.Code, w.Body)
+    }
+    var todo models.Todo
+    if err := json.Unmarshal(w.Body.Bytes(), &todo); err != nil {
+        t.Fatal(err)
+    }
+    return todo
+}
+
+// decodeProblem checks that w is a problem+json response with the given
+// status and returns its body.
+func decodeProblem(t *testing.T, w *httptest.ResponseRecorder, status int) problem {
+    t.Helper()
+    if w.Code != status {
+        t.Fatalf("got status %d, want %d: %s", w.Code, status, w.Body)
+    }
+    if ct := w.Header().Get("Content-Type"); ct != "application/problem+json" {
+        t.Fatalf("got Content-Type %q", ct)
+    }
+    var p problem
+    if err := json.Unmarshal(w.Body.Bytes(), &p); err != nil {
+        t.Fatal(err)
+    }
+    if p.Type != "about:blank" || p.Title != http.StatusText(status) || p.Status != status || p.Detail == "" {
+        t.Fatalf("got problem %+v", p)
+    }
+    return p
+}
+
+func TestAddTodoValidationProblem(t *testing.T) {
+    router := newTestRouter(t)
+    // 100 two-byte characters are within the title limit of 100.
+    title := strings.Repeat("é", 100)
+    body := `{"title":"` + title + `","status":"bogus","priority":"low","due_date":"2000-01-01T00:00:00Z","labels":["a","` + strings.Repeat("x", 21) + `"]}`
+
+    p := decodeProblem(t, serve(router, "POST", "/todos", body), http.StatusUnprocessableEntity)
+    want := []service.FieldError{
+        {Field: "status", Code: service.CodeInvalid},
+        {Field: "due_date", Code: service.CodeInPast},
+        {Field: "labels[1]", Code: service.CodeTooLong, Max: 20},
+    }
+    if !reflect.DeepEqual(p.Fields, want) {
+        t.Fatalf("got fields %+v, want %+v", p.Fields, want)
+    }
+    if p.Instance != "/todos" {
+        t.Errorf("got instance %q", p.Instance)
+    }
+}
+
+func TestUpdateTodoInvalidStatusProblem(t *testing.T) {
+    router := newTestRouter(t)
+    todo := createTestTodo(t, router, testTodoJSON)
+    long := strings.Repeat("x", 101)
+
+    for _, req := range []struct{ method, body string }{
+        {"PUT", `{"title":"` + long + `","status":"bogus","priority":"low","due_date":"2099-01-01T00:00:00Z"}`},
+        {"PATCH", `{"title":"` + long + `","status":"bogus"}`},
+    } {
+        w := serve(router, req.method, "/todos/"+todo.ID, req.body)
+        if w.Code == http.StatusConflict {
+            t.Fatalf("%s: an unknown status was reported as a transition: %s", req.method, w.Body)
+        }
+        p := decodeProblem(t, w, http.StatusUnprocessableEntity)
+        want := []service.FieldError{
+            {Field: "title", Code: service.CodeTooLong, Max: 100},
+            {Field: "status", Code: service.CodeInvalid},
+        }
+        if !reflect.DeepEqual(p.Fields, want) {
+            t.Fatalf("%s: got fields %+v, want %+v", req.method, p.Fields, want)
+        }
+    }
+    if !strings.Contains(serve(router, "PATCH", "/todos/"+todo.ID, `{"status":"bogus"}`).Body.String(), `{"field":"status","code":"invalid"}`) {
+        t.Fatal("the status field error is not in the body")
+    }
+}
+
+func TestTransitionTodoStatuses(t *testing.T) {
+    router := newTestRouter(t)
+    todo := createTestTodo(t, router, testTodoJSON)
+    path := "/todos/" + todo.ID + "/transitions?sync=true"
+
+    decodeProblem(t, serve(router, "POST", path, `{"action":"destroy"}`), http.StatusBadRequest)
+    decodeProblem(t, serve(router, "POST", path, `{"action":"archive"}`), http.StatusConflict)
+    decodeProblem(t, serve(router, "POST", "/todos/missing/transitions", `{"action":"start"}`), http.StatusNotFound)
+
+    w := serve(router, "POST", path, `{"action":"complete"}`)
+    var completed models.Todo
+    if err := json.Unmarshal(w.Body.Bytes(), &completed); err != nil || w.Code != http.StatusOK {
+        t.Fatalf("complete: %d %s", w.Code, w.Body)
+    }
+    if completed.Status != models.StatusCompleted || completed.CompletedAt == nil {
+        t.Fatalf("complete returned %+v", completed)
+    }
+
+    for _, status := range []string{"completed", "archived"} {
+        body := strings.Replace(testTodoJSON, "not_started", status, 1)
+        decodeProblem(t, serve(router, "POST", "/todos", body), http.StatusConflict)
+    }
+}
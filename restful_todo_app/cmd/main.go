@@ -1,40 +1,189 @@
 package main
 
 import (
-    "log"
-    
+    "context"
+    "errors"
+    "fmt"
+    "io"
+    "log/slog"
+    "net/http"
+    "os"
+    "os/signal"
+    "syscall"
+    // Embed the zone database so ?tz= works on hosts without one.
+    _ "time/tzdata"
+
     "github.com/gin-gonic/gin"
     "github.com/read-my-name/restful_todo_app/api/handlers"
+    "github.com/read-my-name/restful_todo_app/internal/config"
+    "github.com/read-my-name/restful_todo_app/internal/notify"
     "github.com/read-my-name/restful_todo_app/internal/service"
     "github.com/read-my-name/restful_todo_app/internal/storage"
     // "github.com/read-my-name/restful_todo_app/pkg/models"
 )
 
 func main() {
+    cfg, opts, err := config.Load("todo", os.Args[1:], os.Stderr)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "todo: %v\n", err)
+        os.Exit(2)
+    }
+    if opts.PrintConfig {
+        if err := cfg.Print(os.Stdout); err != nil {
+            fmt.Fprintf(os.Stderr, "todo: %v\n", err)
+            os.Exit(1)
+        }
+        return
+    }
+
+    level, _ := cfg.LogLevel()
+    slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level})))
+    if level > slog.LevelDebug {
+        gin.SetMode(gin.ReleaseMode)
+    }
+
     // Initialize dependencies
-    csvStorage := storage.NewCSVStorage("D:/GitHub/Freelance/restful_todo_app/data/todos.csv")
-    todoService := service.NewTodoService(csvStorage)
+    todoStorage, err := storage.Open(storage.Config{
+        Backend:     cfg.Storage.Backend,
+        Path:        cfg.Storage.Path,
+        MongoURI:    cfg.Storage.MongoURI,
+        RedisAddr:   cfg.Storage.RedisAddr,
+        CSVMode:     storage.LoadMode(cfg.Storage.CSVMode),
+        LockTimeout: cfg.Storage.LockTimeout,
+    })
+    if err != nil {
+        fatal("Failed to open storage", err)
+    }
+    serviceOpts := []service.Option{
+        service.WithAutoComplete(cfg.Server.AutoComplete),
+        service.WithRequireIfMatch(cfg.Server.RequireIfMatch),
+        service.WithQueueSizes(cfg.Queues.Save, cfg.Queues.Errors),
+        service.WithLimits(cfg.Validation.Limits()),
+    }
+    if cfg.Reminders.Enabled() {
+        sentLog, err := storage.OpenReminderLog(cfg.Reminders.SentFile)
+        if err != nil {
+            fatal("Failed to open reminder log", err)
+        }
+        ns, err := notifiers(cfg.Reminders)
+        if err != nil {
+            fatal("Failed to set up reminders", err)
+        }
+        serviceOpts = append(serviceOpts,
+            service.WithNotifiers(sentLog, ns...),
+            service.WithRetryPolicy(service.RetryPolicy{
+                Attempts: cfg.Reminders.Attempts,
+                Backoff:  cfg.Reminders.Backoff,
+            }),
+        )
+    }
+    todoService := service.NewTodoService(todoStorage, serviceOpts...)
     todoHandler := handlers.NewTodoHandler(todoService)
-    
+
     // Load existing todos
     if _, err := todoService.LoadInitialData(); err != nil {
-        log.Fatalf("Failed to load initial data: %v", err)
+        fatal("Failed to load initial data", err)
+    }
+
+    router := gin.New()
+    if level <= slog.LevelInfo {
+        router.Use(gin.Logger())
     }
-    
-    router := gin.Default()
-    
+    router.Use(gin.Recovery())
+
     // Routes
     router.GET("/todos", todoHandler.GetTodos)
     router.POST("/todos", todoHandler.AddTodo)
+    router.GET("/todos/:id", todoHandler.GetTodo)
     router.PUT("/todos/:id", todoHandler.UpdateTodo)
+    router.PATCH("/todos/:id", todoHandler.PatchTodo)
     router.DELETE("/todos/:id", todoHandler.DeleteTodo)
-    
+    router.POST("/todos/:id/transitions", todoHandler.TransitionTodo)
+
+    // Subtasks
+    router.POST("/todos/:id/subtasks", todoHandler.AddSubtask)
+    router.PUT("/todos/:id/subtasks/order", todoHandler.ReorderSubtasks)
+    router.PATCH("/todos/:id/subtasks/:subID", todoHandler.PatchSubtask)
+    router.DELETE("/todos/:id/subtasks/:subID", todoHandler.DeleteSubtask)
+
     // Time-based categorization
     router.GET("/todos/today", todoHandler.GetTodayTodos)
-    // router.GET("/todos/week", todoHandler.GetWeekTodos)
-    // router.GET("/todos/month", todoHandler.GetMonthTodos)
-    
-    if err := router.Run(":8080"); err != nil {
-        log.Fatalf("Failed to start server: %v", err)
+    router.GET("/todos/period/:period", todoHandler.GetPeriodTodos)
+
+    // Full-text search
+    router.GET("/todos/search", todoHandler.SearchTodos)
+
+    // Administration
+    router.GET("/admin/storage/health", todoHandler.GetStorageHealth)
+
+    server := &http.Server{
+        Addr:    cfg.Server.Addr,
+        Handler: router,
+    }
+
+    ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+    defer stop()
+
+    serverErr := make(chan error, 1)
+    go func() {
+        if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+            serverErr <- err
+        }
+    }()
+
+    select {
+    case err := <-serverErr:
+        fatal("Failed to start server", err)
+    case <-ctx.Done():
+        slog.Info("Shutting down server...")
+    }
+
+    shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
+    defer cancel()
+
+    // Stop taking requests first so no new writes reach the service,
+    // then drain whatever is still queued for storage.
+    if err := server.Shutdown(shutdownCtx); err != nil {
+        slog.Error("Server shutdown error", "err", err)
     }
+    if err := todoService.Close(shutdownCtx); err != nil {
+        slog.Error("Failed to drain save queue", "err", err)
+    }
+    if closer, ok := todoStorage.(io.Closer); ok {
+        if err := closer.Close(); err != nil {
+            slog.Error("Failed to close storage", "err", err)
+        }
+    }
+
+    slog.Info("Server stopped")
+}
+
+// notifiers returns the reminder notifiers set in cfg.
+func notifiers(cfg config.Reminders) ([]service.Notifier, error) {
+    var ns []service.Notifier
+    if cfg.Log {
+        ns = append(ns, notify.Log{})
+    }
+    if cfg.WebhookURL != "" {
+        ns = append(ns, notify.Webhook{URL: cfg.WebhookURL})
+    }
+    if cfg.SMTPAddr != "" {
+        mode, err := notify.ParseTLSMode(cfg.SMTPTLS)
+        if err != nil {
+            return nil, err
+        }
+        n := notify.SMTP{Addr: cfg.SMTPAddr, From: cfg.SMTPFrom, To: cfg.Recipients(), TLS: mode}
+        if cfg.SMTPCAFile != "" {
+            if n.RootCAs, err = notify.LoadRootCAs(cfg.SMTPCAFile); err != nil {
+                return nil, err
+            }
+        }
+        ns = append(ns, n)
+    }
+    return ns, nil
+}
+
+func fatal(msg string, err error) {
+    slog.Error(msg, "err", err)
+    os.Exit(1)
 }
\ No newline at end of file
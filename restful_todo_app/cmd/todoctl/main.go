@@ -0,0 +1,348 @@
+// Command todoctl moves todos between files and storage backends.
+//
+//  todoctl export   -storage sqlite -data todos.db -format jsonl -out todos.jsonl
+//  todoctl import   -storage mongo -format csv -in todos.csv
+//  todoctl upgrade  -in todos.csv [-out upgraded.csv]
+//  todoctl validate -format jsonl todos.jsonl
+package main
+
+import (
+    "errors"
+    "flag"
+    "fmt"
+    "io"
+    "os"
+    "strings"
+    "time"
+
+    "github.com/read-my-name/restful_todo_app/internal/config"
+    "github.com/read-my-name/restful_todo_app/internal/service"
+    "github.com/read-my-name/restful_todo_app/internal/storage"
+    "github.com/read-my-name/restful_todo_app/pkg/models"
+)
+
+const usage = `usage: todoctl <command> [flags]
+
+commands:
+  export    write every todo of a backend to a CSV or JSON Lines file
+  import    replace the todos of a backend with those of a file
+  upgrade   rewrite a CSV file in the current versioned layout
+  validate  check a CSV or JSON Lines file and report every bad line
+
+Run "todoctl <command> -h" for the flags of a command.
+`
+
+func main() {
+    if len(os.Args) < 2 {
+        fmt.Fprint(os.Stderr, usage)
+        os.Exit(2)
+    }
+
+    commands := map[string]func(args []string) error{
+        "export":   runExport,
+        "import":   runImport,
+        "upgrade":  runUpgrade,
+        "validate": runValidate,
+    }
+    run, ok := commands[os.Args[1]]
+    if !ok {
+        fmt.Fprintf(os.Stderr, "todoctl: unknown command %q\n\n%s", os.Args[1], usage)
+        os.Exit(2)
+    }
+    if err := run(os.Args[2:]); err != nil {
+        fmt.Fprintf(os.Stderr, "todoctl %s: %v\n", os.Args[1], err)
+        os.Exit(1)
+    }
+}
+
+// storageFlags registers the flags that select a backend, matching the
+// server's.
+func storageFlags(fs *flag.FlagSet) *storage.Config {
+    cfg := &storage.Config{}
+    fs.StringVar(&cfg.Backend, "storage", "csv", "storage backend: csv, sqlite or mongo")
+    fs.StringVar(&cfg.Path, "data", "", "path of the CSV file or SQLite database, or the MongoDB database name")
+    fs.StringVar(&cfg.MongoURI, "mongo-uri", "mongodb://localhost:27017", "MongoDB connection URI")
+    // Imports must go through the server's cache, or it keeps serving the old todos.
+    fs.StringVar(&cfg.RedisAddr, "redis-addr", "", "Redis cache in front of the backend, as given to the server")
+    fs.DurationVar(&cfg.LockTimeout, "lock-timeout", 5*time.Second, "how long to wait for the server to release the CSV file")
+    return cfg
+}
+
+// configFlag registers -config, naming the server's configuration file.
+// Its validation limits, and those set by TODO_* variables, are applied to
+// the todos that are checked.
+func configFlag(fs *flag.FlagSet) *string {
+    return fs.String("config", os.Getenv("TODO_CONFIG"), "server configuration file whose validation limits apply (env TODO_CONFIG)")
+}
+
+func limitsOf(configFile string) (service.Limits, error) {
+    cfg, err := config.LoadFile(configFile)
+    if err != nil {
+        return service.Limits{}, err
+    }
+    return cfg.Validation.Limits(), nil
+}
+
+func openBackend(cfg *storage.Config) (storage.Backend, func(), error) {
+    backend, err := storage.Open(*cfg)
+    if err != nil {
+        return nil, nil, fmt.Errorf("failed to open storage: %w", err)
+    }
+    closeBackend := func() {
+        if closer, ok := backend.(io.Closer); ok {
+            if err := closer.Close(); err != nil {
+                fmt.Fprintf(os.Stderr, "todoctl: failed to close storage: %v\n", err)
+            }
+        }
+    }
+    return backend, closeBackend, nil
+}
+
+// formatOf returns the file format named by the -format flag, or guessed
+// from the file extension when the flag is empty.
+func formatOf(format, path string) (string, error) {
+    if format == "" {
+        if strings.HasSuffix(path, ".jsonl") || strings.HasSuffix(path, ".ndjson") {
+            format = "jsonl"
+        } else {
+            format = "csv"
+        }
+    }
+    if format != "csv" && format != "jsonl" {
+        return "", fmt.Errorf("unknown format %q, want csv or jsonl", format)
+    }
+    return format, nil
+}
+
+// checkFile decodes every todo of path ("-" for stdin) and checks it as
+// the service would with limits, also rejecting duplicate IDs. Every bad
+// line is returned as a storage.LineErrors, together with the todos that
+// passed.
+func checkFile(path, format string, limits service.Limits) ([]models.Todo, error) {
+    in := os.Stdin
+    if path != "-" {
+        f, err := os.Open(path)
+        if err != nil {
+            return nil, err
+        }
+        defer f.Close()
+        in = f
+    }
+
+    todos := []models.Todo{}
+    var lineErrs storage.LineErrors
+    seen := make(map[string]int)
+    check := func(line int, t models.Todo, err error) {
+        if err == nil {
+            err = service.ValidateStoredTodo(t, limits)
+        }
+        if first, ok := seen[t.ID]; ok && err == nil {
+            err = fmt.Errorf("duplicate ID %s, first used on line %d", t.ID, first)
+        }
+        if err != nil {
+            lineErr, ok := err.(*storage.LineError)
+            if !ok {
+                lineErr = &storage.LineError{Line: line, Err: err}
+            }
+            lineErrs = append(lineErrs, lineErr)
+            return
+        }
+        seen[t.ID] = line
+        todos = append(todos, t)
+    }
+
+    var err error
+    if format == "jsonl" {
+        err = storage.ScanJSONL(in, check)
+    } else {
+        err = storage.ScanCSV(in, check)
+    }
+    if err != nil {
+        return nil, err
+    }
+    if len(lineErrs) > 0 {
+        return todos, lineErrs
+    }
+    return todos, nil
+}
+
+func printLineErrors(w io.Writer, path string, errs storage.LineErrors) {
+    for _, e := range errs {
+        if e.Column != "" {
+            fmt.Fprintf(w, "%s:%d: %s: %v\n", path, e.Line, e.Column, e.Err)
+            continue
+        }
+        fmt.Fprintf(w, "%s:%d: %v\n", path, e.Line, e.Err)
+    }
+}
+
+func runExport(args []string) error {
+    fs := flag.NewFlagSet("export", flag.ExitOnError)
+    cfg := storageFlags(fs)
+    out := fs.String("out", "-", "file to write, - for stdout")
+    format := fs.String("format", "", "csv or jsonl (default: from the -out extension, else csv)")
+    fs.Parse(args)
+
+    fileFormat, err := formatOf(*format, *out)
+    if err != nil {
+        return err
+    }
+    backend, closeBackend, err := openBackend(cfg)
+    if err != nil {
+        return err
+    }
+    defer closeBackend()
+
+    todos, err := backend.Load()
+    if err != nil {
+        return fmt.Errorf("failed to load todos: %w", err)
+    }
+
+    w := os.Stdout
+    if *out != "-" {
+        f, err := os.Create(*out)
+        if err != nil {
+            return err
+        }
+        defer f.Close()
+        w = f
+    }
+    if fileFormat == "jsonl" {
+        err = storage.WriteJSONL(w, todos)
+    } else {
+        err = storage.WriteCSV(w, todos)
+    }
+    if err != nil {
+        return err
+    }
+    if *out != "-" {
+        fmt.Fprintf(os.Stderr, "exported %d todos to %s\n", len(todos), *out)
+    }
+    return nil
+}
+
+func runImport(args []string) error {
+    fs := flag.NewFlagSet("import", flag.ExitOnError)
+    cfg := storageFlags(fs)
+    configFile := configFlag(fs)
+    in := fs.String("in", "-", "file to read, - for stdin")
+    format := fs.String("format", "", "csv or jsonl (default: from the -in extension, else csv)")
+    fs.Parse(args)
+
+    fileFormat, err := formatOf(*format, *in)
+    if err != nil {
+        return err
+    }
+    limits, err := limitsOf(*configFile)
+    if err != nil {
+        return err
+    }
+    // Check everything before touching the backend, so a bad file leaves
+    // it unchanged.
+    todos, err := checkFile(*in, fileFormat, limits)
+    var lineErrs storage.LineErrors
+    if errors.As(err, &lineErrs) {
+        printLineErrors(os.Stderr, *in, lineErrs)
+        return fmt.Errorf("%d bad lines, nothing imported", len(lineErrs))
+    }
+    if err != nil {
+        return err
+    }
+
+    backend, closeBackend, err := openBackend(cfg)
+    if err != nil {
+        return err
+    }
+    defer closeBackend()
+
+    if err := backend.SaveAll(todos); err != nil {
+        return fmt.Errorf("failed to save todos: %w", err)
+    }
+    fmt.Fprintf(os.Stderr, "imported %d todos\n", len(todos))
+    return nil
+}
+
+func runUpgrade(args []string) error {
+    fs := flag.NewFlagSet("upgrade", flag.ExitOnError)
+    in := fs.String("in", "", "CSV file to upgrade")
+    out := fs.String("out", "", "file to write (default: rewrite -in, keeping a .bak copy)")
+    strict := fs.Bool("strict", false, "fail on unreadable rows instead of moving them to the rejects file")
+    fs.Parse(args)
+
+    if *in == "" {
+        return errors.New("-in is required")
+    }
+    original, err := os.ReadFile(*in)
+    if err != nil {
+        return err
+    }
+
+    target := *out
+    if target == "" {
+        target = *in
+        if err := os.WriteFile(*in+".bak", original, 0600); err != nil {
+            return fmt.Errorf("failed to write backup: %w", err)
+        }
+    } else if err := os.WriteFile(target, original, 0600); err != nil {
+        return err
+    }
+
+    mode := storage.LenientLoad
+    if *strict {
+        mode = storage.StrictLoad
+    }
+    // Migrate reads the file the way the server does, so anything it
+    // accepts can be upgraded.
+    csvStorage := storage.NewCSVStorage(target, storage.WithLoadMode(mode))
+    defer csvStorage.Close()
+    from, err := csvStorage.Migrate()
+    if err != nil {
+        return fmt.Errorf("cannot upgrade %s: %w", *in, err)
+    }
+    if health := csvStorage.Health(); health.Skipped > 0 {
+        fmt.Fprintf(os.Stderr, "moved %d unreadable rows to %s\n", health.Skipped, health.RejectsFile)
+    }
+    if from == 0 {
+        fmt.Fprintf(os.Stderr, "upgraded %s from the unversioned layout\n", target)
+    } else {
+        fmt.Fprintf(os.Stderr, "upgraded %s from CSV version %d\n", target, from)
+    }
+    return nil
+}
+
+func runValidate(args []string) error {
+    fs := flag.NewFlagSet("validate", flag.ExitOnError)
+    configFile := configFlag(fs)
+    format := fs.String("format", "", "csv or jsonl (default: from the file extension, else csv)")
+    fs.Usage = func() {
+        fmt.Fprintln(fs.Output(), "usage: todoctl validate [-config FILE] [-format csv|jsonl] FILE")
+        fs.PrintDefaults()
+    }
+    fs.Parse(args)
+
+    if fs.NArg() != 1 {
+        fs.Usage()
+        return errors.New("expected exactly one file")
+    }
+    path := fs.Arg(0)
+    fileFormat, err := formatOf(*format, path)
+    if err != nil {
+        return err
+    }
+    limits, err := limitsOf(*configFile)
+    if err != nil {
+        return err
+    }
+
+    todos, err := checkFile(path, fileFormat, limits)
+    var lineErrs storage.LineErrors
+    if errors.As(err, &lineErrs) {
+        printLineErrors(os.Stdout, path, lineErrs)
+        return fmt.Errorf("%d bad lines in %s", len(lineErrs), path)
+    }
+    if err != nil {
+        return err
+    }
+    fmt.Fprintf(os.Stdout, "%s: %d todos OK\n", path, len(todos))
+    return nil
+}
@@ -0,0 +1,443 @@
+// Package config loads the settings of the todo server from, in order of
+// increasing precedence, built-in defaults, a YAML file, TODO_*
+// environment variables and command-line flags.
+package config
+
+import (
+    "errors"
+    "flag"
+    "fmt"
+    "io"
+    "log/slog"
+    "net"
+    "net/url"
+    "os"
+    "path/filepath"
+    "strconv"
+    "strings"
+    "time"
+
+    "gopkg.in/yaml.v3"
+
+    "github.com/read-my-name/restful_todo_app/internal/notify"
+    "github.com/read-my-name/restful_todo_app/internal/service"
+    "github.com/read-my-name/restful_todo_app/internal/storage"
+)
+
+// Config is the effective configuration of the server.
+type Config struct {
+    Server     Server     `yaml:"server"`
+    Storage    Storage    `yaml:"storage"`
+    Queues     Queues     `yaml:"queues"`
+    Validation Validation `yaml:"validation"`
+    Reminders  Reminders  `yaml:"reminders"`
+    Log        Log        `yaml:"log"`
+}
+
+type Server struct {
+    Addr            string        `yaml:"addr"`
+    ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+    AutoComplete    bool          `yaml:"auto_complete"`
+    RequireIfMatch  bool          `yaml:"require_if_match"`
+}
+
+type Storage struct {
+    Backend     string        `yaml:"backend"`
+    Path        string        `yaml:"path"`
+    MongoURI    string        `yaml:"mongo_uri"`
+    RedisAddr   string        `yaml:"redis_addr"`
+    CSVMode     string        `yaml:"csv_mode"`
+    LockTimeout time.Duration `yaml:"lock_timeout"`
+}
+
+// Queues sizes the buffers between the handlers and the background
+// workers of the service.
+type Queues struct {
+    Save   int `yaml:"save"`
+    Errors int `yaml:"errors"`
+}
+
+// Validation holds the maximum lengths of todo fields.
+type Validation struct {
+    MaxTitle        int `yaml:"max_title"`
+    MaxDescription  int `yaml:"max_description"`
+    MaxLabel        int `yaml:"max_label"`
+    MaxSubtaskTitle int `yaml:"max_subtask_title"`
+}
+
+// Limits returns the lengths as service limits.
+func (v Validation) Limits() service.Limits {
+    return service.Limits{
+        Title:        v.MaxTitle,
+        Description:  v.MaxDescription,
+        Label:        v.MaxLabel,
+        SubtaskTitle: v.MaxSubtaskTitle,
+    }
+}
+
+// Reminders selects where the reminders of todos are sent. They are only
+// scheduled when at least one notifier is set.
+type Reminders struct {
+    Log        bool          `yaml:"log"`
+    WebhookURL string        `yaml:"webhook_url"`
+    SMTPAddr   string        `yaml:"smtp_addr"`
+    SMTPFrom   string        `yaml:"smtp_from"`
+    SMTPTo     string        `yaml:"smtp_to"` // comma separated
+    SMTPTLS    string        `yaml:"smtp_tls"` // starttls, required, tls or none
+    SMTPCAFile string        `yaml:"smtp_ca_file"`
+    SentFile   string        `yaml:"sent_file"`
+    Attempts   int           `yaml:"attempts"`
+    Backoff    time.Duration `yaml:"backoff"`
+}
+
+// Enabled reports whether any notifier is set.
+func (r Reminders) Enabled() bool {
+    return r.Log || r.WebhookURL != "" || r.SMTPAddr != ""
+}
+
+type Log struct {
+    Level string `yaml:"level"` // debug, info, warn or error
+}
+
+// Default returns the configuration used when nothing is set.
+func Default() Config {
+    return Config{
+        Server: Server{
+            Addr:            ":8080",
+            ShutdownTimeout: 10 * time.Second,
+        },
+        Storage: Storage{
+            Backend:     "csv",
+            MongoURI:    "mongodb://localhost:27017",
+            CSVMode:     string(storage.LenientLoad),
+            LockTimeout: 5 * time.Second,
+        },
+        Queues: Queues{
+            Save:   100,
+            Errors: 10,
+        },
+        Validation: Validation{
+            MaxTitle:        100,
+            MaxDescription:  200,
+            MaxLabel:        20,
+            MaxSubtaskTitle: 100,
+        },
+        Reminders: Reminders{
+            SMTPTLS:  string(notify.TLSOpportunistic),
+            SentFile: filepath.Join("data", "reminders.json"),
+            Attempts: 5,
+            Backoff:  30 * time.Second,
+        },
+        Log: Log{
+            Level: "info",
+        },
+    }
+}
+
+// setting is a configuration value that can be set by an environment
+// variable and a flag.
+type setting struct {
+    flag  string
+    env   string
+    usage string
+    set   func(c *Config, value string) error
+    bool  bool
+}
+
+var settings = []setting{
+    {flag: "addr", env: "TODO_ADDR", usage: "address to listen on", set: stringVar(func(c *Config) *string { return &c.Server.Addr })},
+    {flag: "shutdown-timeout", env: "TODO_SHUTDOWN_TIMEOUT", usage: "time given to requests and queued saves to finish on shutdown", set: durationVar(func(c *Config) *time.Duration { return &c.Server.ShutdownTimeout })},
+    {flag: "auto-complete", env: "TODO_AUTO_COMPLETE", usage: "complete a todo once all of its subtasks are done", set: boolVar(func(c *Config) *bool { return &c.Server.AutoComplete }), bool: true},
+    {flag: "require-if-match", env: "TODO_REQUIRE_IF_MATCH", usage: "refuse writes to existing todos without an If-Match header", set: boolVar(func(c *Config) *bool { return &c.Server.RequireIfMatch }), bool: true},
+
+    {flag: "storage", env: "TODO_STORAGE", usage: "storage backend: csv, sqlite or mongo", set: stringVar(func(c *Config) *string { return &c.Storage.Backend })},
+    {flag: "data", env: "TODO_DATA", usage: "path of the CSV file or SQLite database, or the MongoDB database name", set: stringVar(func(c *Config) *string { return &c.Storage.Path })},
+    {flag: "mongo-uri", env: "TODO_MONGO_URI", usage: "MongoDB connection URI", set: stringVar(func(c *Config) *string { return &c.Storage.MongoURI })},
+    {flag: "redis-addr", env: "TODO_REDIS_ADDR", usage: "cache todos in the Redis server at this address (disabled when empty)", set: stringVar(func(c *Config) *string { return &c.Storage.RedisAddr })},
+    {flag: "csv-mode", env: "TODO_CSV_MODE", usage: "how the csv backend handles unreadable rows: strict fails to start, lenient moves them to a .rejects.csv file", set: stringVar(func(c *Config) *string { return &c.Storage.CSVMode })},
+    {flag: "lock-timeout", env: "TODO_LOCK_TIMEOUT", usage: "how long the csv backend waits for another process using the same file", set: durationVar(func(c *Config) *time.Duration { return &c.Storage.LockTimeout })},
+
+    {flag: "save-queue", env: "TODO_SAVE_QUEUE", usage: "writes that can wait for storage before new ones are refused", set: intVar(func(c *Config) *int { return &c.Queues.Save })},
+    {flag: "error-queue", env: "TODO_ERROR_QUEUE", usage: "storage errors buffered for the error handler", set: intVar(func(c *Config) *int { return &c.Queues.Errors })},
+
+    {flag: "max-title", env: "TODO_MAX_TITLE", usage: "maximum length of a title", set: intVar(func(c *Config) *int { return &c.Validation.MaxTitle })},
+    {flag: "max-description", env: "TODO_MAX_DESCRIPTION", usage: "maximum length of a description", set: intVar(func(c *Config) *int { return &c.Validation.MaxDescription })},
+    {flag: "max-label", env: "TODO_MAX_LABEL", usage: "maximum length of a label", set: intVar(func(c *Config) *int { return &c.Validation.MaxLabel })},
+    {flag: "max-subtask-title", env: "TODO_MAX_SUBTASK_TITLE", usage: "maximum length of a subtask title", set: intVar(func(c *Config) *int { return &c.Validation.MaxSubtaskTitle })},
+
+    {flag: "reminder-log", env: "TODO_REMINDER_LOG", usage: "write reminders to the log", set: boolVar(func(c *Config) *bool { return &c.Reminders.Log }), bool: true},
+    {flag: "reminder-webhook", env: "TODO_REMINDER_WEBHOOK", usage: "POST reminders as JSON to this URL", set: stringVar(func(c *Config) *string { return &c.Reminders.WebhookURL })},
+    {flag: "reminder-smtp-addr", env: "TODO_REMINDER_SMTP_ADDR", usage: "mail reminders through the SMTP server at this address", set: stringVar(func(c *Config) *string { return &c.Reminders.SMTPAddr })},
+    {flag: "reminder-smtp-from", env: "TODO_REMINDER_SMTP_FROM", usage: "sender of reminder mails", set: stringVar(func(c *Config) *string { return &c.Reminders.SMTPFrom })},
+    {flag: "reminder-smtp-to", env: "TODO_REMINDER_SMTP_TO", usage: "comma separated recipients of reminder mails", set: stringVar(func(c *Config) *string { return &c.Reminders.SMTPTo })},
+    {flag: "reminder-smtp-tls", env: "TODO_REMINDER_SMTP_TLS", usage: "starttls uses STARTTLS when offered, required insists on it, tls connects with TLS, none never uses it", set: stringVar(func(c *Config) *string { return &c.Reminders.SMTPTLS })},
+    {flag: "reminder-smtp-ca-file", env: "TODO_REMINDER_SMTP_CA_FILE", usage: "PEM file of CAs trusted for the mail server, besides the system's", set: stringVar(func(c *Config) *string { return &c.Reminders.SMTPCAFile })},
+    {flag: "reminder-sent-file", env: "TODO_REMINDER_SENT_FILE", usage: "file recording sent reminders, so a restart does not send them again", set: stringVar(func(c *Config) *string { return &c.Reminders.SentFile })},
+    {flag: "reminder-attempts", env: "TODO_REMINDER_ATTEMPTS", usage: "deliveries tried before a reminder is given up", set: intVar(func(c *Config) *int { return &c.Reminders.Attempts })},
+    {flag: "reminder-backoff", env: "TODO_REMINDER_BACKOFF", usage: "wait before retrying a failed reminder, doubled after each attempt", set: durationVar(func(c *Config) *time.Duration { return &c.Reminders.Backoff })},
+
+    {flag: "log-level", env: "TODO_LOG_LEVEL", usage: "debug, info, warn or error", set: stringVar(func(c *Config) *string { return &c.Log.Level })},
+}
+
+func stringVar(field func(c *Config) *string) func(c *Config, value string) error {
+    return func(c *Config, value string) error {
+        *field(c) = value
+        return nil
+    }
+}
+
+func intVar(field func(c *Config) *int) func(c *Config, value string) error {
+    return func(c *Config, value string) error {
+        n, err := strconv.Atoi(value)
+        if err != nil {
+            return fmt.Errorf("invalid number %q", value)
+        }
+        *field(c) = n
+        return nil
+    }
+}
+
+func boolVar(field func(c *Config) *bool) func(c *Config, value string) error {
+    return func(c *Config, value string) error {
+        b, err := strconv.ParseBool(value)
+        if err != nil {
+            return fmt.Errorf("invalid boolean %q", value)
+        }
+        *field(c) = b
+        return nil
+    }
+}
+
+func durationVar(field func(c *Config) *time.Duration) func(c *Config, value string) error {
+    return func(c *Config, value string) error {
+        d, err := time.ParseDuration(value)
+        if err != nil {
+            return fmt.Errorf("invalid duration %q", value)
+        }
+        *field(c) = d
+        return nil
+    }
+}
+
+// Options are the flags that are not configuration values.
+type Options struct {
+    File        string // the -config flag or TODO_CONFIG
+    PrintConfig bool
+}
+
+// Load parses args, the command-line flags without the program name, and
+// returns the effective configuration. Bad flags are reported to errOut
+// and exit the program, as with the flag package. Values are taken from, in order of
+// increasing precedence, Default, the YAML file named by -config or
+// TODO_CONFIG, the TODO_* environment variables and the flags set in args.
+func Load(name string, args []string, errOut io.Writer) (Config, Options, error) {
+    var opts Options
+    fs := flag.NewFlagSet(name, flag.ExitOnError)
+    fs.SetOutput(errOut)
+    fs.StringVar(&opts.File, "config", os.Getenv("TODO_CONFIG"), "YAML configuration file")
+    fs.BoolVar(&opts.PrintConfig, "print-config", false, "print the effective configuration, defaults included, and exit")
+
+    // Flags are only collected while parsing, then applied last.
+    type flagValue struct {
+        setting setting
+        value   string
+    }
+    var flagValues []flagValue
+    for _, s := range settings {
+        s := s
+        collect := func(value string) error {
+            // Report a bad value while parsing, next to the flag.
+            scratch := Default()
+            if err := s.set(&scratch, value); err != nil {
+                return err
+            }
+            flagValues = append(flagValues, flagValue{s, value})
+            return nil
+        }
+        usage := fmt.Sprintf("%s (env %s)", s.usage, s.env)
+        if s.bool {
+            fs.BoolFunc(s.flag, usage, collect)
+        } else {
+            fs.Func(s.flag, usage, collect)
+        }
+    }
+    fs.Parse(args)
+    if fs.NArg() > 0 {
+        return Config{}, opts, fmt.Errorf("unexpected arguments: %v", fs.Args())
+    }
+
+    cfg, err := fromFileAndEnv(opts.File)
+    if err != nil {
+        return Config{}, opts, err
+    }
+    for _, fv := range flagValues {
+        fv.setting.set(&cfg, fv.value)
+    }
+    if err := cfg.finish(); err != nil {
+        return Config{}, opts, err
+    }
+    return cfg, opts, nil
+}
+
+// LoadFile returns the configuration of a server started without flags:
+// Default, overlaid with the YAML file at path, if path is not empty, and
+// the TODO_* environment variables. Tools use it to share the server's
+// settings.
+func LoadFile(path string) (Config, error) {
+    cfg, err := fromFileAndEnv(path)
+    if err != nil {
+        return Config{}, err
+    }
+    if err := cfg.finish(); err != nil {
+        return Config{}, err
+    }
+    return cfg, nil
+}
+
+func fromFileAndEnv(path string) (Config, error) {
+    cfg := Default()
+    if path != "" {
+        if err := loadFile(&cfg, path); err != nil {
+            return Config{}, err
+        }
+    }
+    for _, s := range settings {
+        value, ok := os.LookupEnv(s.env)
+        if !ok {
+            continue
+        }
+        if err := s.set(&cfg, value); err != nil {
+            return Config{}, fmt.Errorf("%s: %w", s.env, err)
+        }
+    }
+    return cfg, nil
+}
+
+// finish fills in the values that depend on others and validates c.
+func (c *Config) finish() error {
+    if c.Storage.Path == "" {
+        c.Storage.Path = storage.DefaultPath(c.Storage.Backend)
+    }
+    return c.Validate()
+}
+
+// loadFile overlays the values set in the YAML file at path on cfg.
+func loadFile(cfg *Config, path string) error {
+    f, err := os.Open(path)
+    if err != nil {
+        return fmt.Errorf("failed to open config file: %w", err)
+    }
+    defer f.Close()
+
+    decoder := yaml.NewDecoder(f)
+    decoder.KnownFields(true)
+    if err := decoder.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+        return fmt.Errorf("invalid config file %s: %w", path, err)
+    }
+    return nil
+}
+
+// Validate reports the first setting that cannot be used.
+func (c Config) Validate() error {
+    switch c.Storage.Backend {
+        case "csv", "sqlite", "mongo":
+        default:
+            return fmt.Errorf("unknown storage backend %q", c.Storage.Backend)
+    }
+    switch storage.LoadMode(c.Storage.CSVMode) {
+        case storage.StrictLoad, storage.LenientLoad:
+        default:
+            return fmt.Errorf("unknown CSV mode %q", c.Storage.CSVMode)
+    }
+    if c.Storage.LockTimeout <= 0 {
+        return errors.New("lock timeout must be positive")
+    }
+    if c.Server.ShutdownTimeout <= 0 {
+        return errors.New("shutdown timeout must be positive")
+    }
+    if c.Queues.Save < 1 || c.Queues.Errors < 1 {
+        return errors.New("queue sizes must be at least 1")
+    }
+    limits := map[string]int{
+        "max_title":         c.Validation.MaxTitle,
+        "max_description":   c.Validation.MaxDescription,
+        "max_label":         c.Validation.MaxLabel,
+        "max_subtask_title": c.Validation.MaxSubtaskTitle,
+    }
+    for name, limit := range limits {
+        if limit < 1 {
+            return fmt.Errorf("validation.%s must be at least 1", name)
+        }
+    }
+    if err := c.Reminders.validate(); err != nil {
+        return err
+    }
+    if _, err := c.LogLevel(); err != nil {
+        return err
+    }
+    return nil
+}
+
+func (r Reminders) validate() error {
+    if r.Attempts < 1 {
+        return errors.New("reminder attempts must be at least 1")
+    }
+    if r.Backoff <= 0 {
+        return errors.New("reminder backoff must be positive")
+    }
+    if r.WebhookURL != "" {
+        u, err := url.Parse(r.WebhookURL)
+        if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+            return fmt.Errorf("invalid reminder webhook URL %q", r.WebhookURL)
+        }
+    }
+    if r.SMTPAddr != "" {
+        if _, _, err := net.SplitHostPort(r.SMTPAddr); err != nil {
+            return fmt.Errorf("invalid reminder SMTP address %q", r.SMTPAddr)
+        }
+        if r.SMTPFrom == "" || len(r.Recipients()) == 0 {
+            return errors.New("reminder SMTP server needs a sender and recipients")
+        }
+    }
+    mode, err := notify.ParseTLSMode(r.SMTPTLS)
+    if err != nil {
+        return err
+    }
+    if r.SMTPCAFile != "" && mode == notify.TLSNone {
+        return errors.New("reminder SMTP CA file is set, but TLS is not used")
+    }
+    if r.Enabled() && r.SentFile == "" {
+        return errors.New("reminder sent file must be set")
+    }
+    return nil
+}
+
+// Recipients splits SMTPTo into addresses.
+func (r Reminders) Recipients() []string {
+    var to []string
+    for _, addr := range strings.Split(r.SMTPTo, ",") {
+        if addr = strings.TrimSpace(addr); addr != "" {
+            to = append(to, addr)
+        }
+    }
+    return to
+}
+
+// LogLevel returns the parsed log level.
+func (c Config) LogLevel() (slog.Level, error) {
+    var level slog.Level
+    if err := level.UnmarshalText([]byte(c.Log.Level)); err != nil {
+        return 0, fmt.Errorf("unknown log level %q", c.Log.Level)
+    }
+    return level, nil
+}
+
+// Print writes c as YAML, in the format of the config file.
+func (c Config) Print(w io.Writer) error {
+    encoder := yaml.NewEncoder(w)
+    encoder.SetIndent(2)
+    if err := encoder.Encode(c); err != nil {
+        return err
+    }
+    return encoder.Close()
+}
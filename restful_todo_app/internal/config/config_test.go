@@ -0,0 +1,61 @@
+package config
+
+import (
+    "os"
+    "path/filepath"
+    "testing"
+
+    "github.com/read-my-name/restful_todo_app/internal/service"
+)
+
+func TestLoadFileLimits(t *testing.T) {
+    path := filepath.Join(t.TempDir(), "config.yaml")
+    data := "validation:\n  max_title: 40\n  max_label: 10\n"
+    if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+        t.Fatal(err)
+    }
+    t.Setenv("TODO_MAX_LABEL", "12")
+
+    cfg, err := LoadFile(path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    want := service.Limits{Title: 40, Description: 200, Label: 12, SubtaskTitle: 100}
+    if got := cfg.Validation.Limits(); got != want {
+        t.Errorf("got limits %+v, want %+v", got, want)
+    }
+}
+
+func TestLoadFileDefaults(t *testing.T) {
+    cfg, err := LoadFile("")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if got := cfg.Validation.Limits(); got != service.DefaultLimits() {
+        t.Errorf("got limits %+v, want the service defaults", got)
+    }
+    t.Setenv("TODO_MAX_TITLE", "0")
+    if _, err := LoadFile(""); err == nil {
+        t.Error("LoadFile accepted a zero title limit")
+    }
+}
+
+func TestLoadFileSMTPTLS(t *testing.T) {
+    cfg, err := LoadFile("")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if cfg.Reminders.SMTPTLS != "starttls" {
+        t.Errorf("default SMTP TLS mode is %q", cfg.Reminders.SMTPTLS)
+    }
+
+    t.Setenv("TODO_REMINDER_SMTP_TLS", "ssl")
+    if _, err := LoadFile(""); err == nil {
+        t.Error("LoadFile accepted an unknown SMTP TLS mode")
+    }
+    t.Setenv("TODO_REMINDER_SMTP_TLS", "none")
+    t.Setenv("TODO_REMINDER_SMTP_CA_FILE", "ca.pem")
+    if _, err := LoadFile(""); err == nil {
+        t.Error("LoadFile accepted a CA file without TLS")
+    }
+}
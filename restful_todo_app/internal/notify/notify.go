@@ -0,0 +1,31 @@
+// Package notify delivers todo reminders: to the log, to a webhook and by
+// mail. Each notifier implements service.Notifier.
+package notify
+
+import (
+    "context"
+    "log/slog"
+    "time"
+
+    "github.com/read-my-name/restful_todo_app/pkg/models"
+)
+
+// Log writes reminders to a logger, the default one when Logger is nil.
+type Log struct {
+    Logger *slog.Logger
+}
+
+func (n Log) Name() string { return "log" }
+
+func (n Log) Notify(ctx context.Context, r models.Reminder) error {
+    logger := n.Logger
+    if logger == nil {
+        logger = slog.Default()
+    }
+    logger.InfoContext(ctx, "Reminder",
+        "id", r.Todo.ID,
+        "title", r.Todo.Title,
+        "due", r.Todo.DueDate.Format(time.RFC3339),
+        "offset", r.Offset)
+    return nil
+}
@@ -0,0 +1,155 @@
+package notify
+
+import (
+    "bytes"
+    "context"
+    "crypto/tls"
+    "crypto/x509"
+    "errors"
+    "fmt"
+    "mime"
+    "net"
+    "net/smtp"
+    "os"
+    "strings"
+    "time"
+
+    "github.com/read-my-name/restful_todo_app/pkg/models"
+)
+
+// TLSMode decides how SMTP protects its connection to the mail server.
+type TLSMode string
+
+const (
+    // TLSOpportunistic upgrades the connection with STARTTLS when the
+    // server offers it, and sends in plain text otherwise. The default.
+    TLSOpportunistic TLSMode = "starttls"
+    // TLSRequired fails unless the server offers STARTTLS.
+    TLSRequired TLSMode = "required"
+    // TLSImplicit speaks TLS from the start, as on port 465.
+    TLSImplicit TLSMode = "tls"
+    // TLSNone never uses TLS, for relays on the same host.
+    TLSNone TLSMode = "none"
+)
+
+// ParseTLSMode checks a TLSMode given by name; "" is TLSOpportunistic.
+func ParseTLSMode(name string) (TLSMode, error) {
+    switch mode := TLSMode(name); mode {
+        case "":
+            return TLSOpportunistic, nil
+        case TLSOpportunistic, TLSRequired, TLSImplicit, TLSNone:
+            return mode, nil
+    }
+    return "", fmt.Errorf("unknown SMTP TLS mode %q, want starttls, required, tls or none", name)
+}
+
+// SMTP mails each reminder through the server at Addr, protected as TLS
+// says. The server's certificate must be signed by one of RootCAs, or by
+// a CA the system trusts when RootCAs is nil.
+type SMTP struct {
+    Addr    string
+    From    string
+    To      []string
+    Auth    smtp.Auth // optional
+    TLS     TLSMode
+    RootCAs *x509.CertPool
+}
+
+// LoadRootCAs returns the system's trusted CAs together with the PEM
+// certificates in the file at path.
+func LoadRootCAs(path string) (*x509.CertPool, error) {
+    pem, err := os.ReadFile(path)
+    if err != nil {
+        return nil, fmt.Errorf("failed to read CA file: %w", err)
+    }
+    pool, err := x509.SystemCertPool()
+    if err != nil {
+        pool = x509.NewCertPool()
+    }
+    if !pool.AppendCertsFromPEM(pem) {
+        return nil, fmt.Errorf("no PEM certificates in %s", path)
+    }
+    return pool, nil
+}
+
+func (n SMTP) Name() string { return "smtp" }
+
+func (n SMTP) Notify(ctx context.Context, r models.Reminder) error {
+    host, _, _ := net.SplitHostPort(n.Addr)
+    tlsConfig := &tls.Config{ServerName: host, RootCAs: n.RootCAs}
+
+    var conn net.Conn
+    var err error
+    if n.TLS == TLSImplicit {
+        dialer := tls.Dialer{Config: tlsConfig}
+        conn, err = dialer.DialContext(ctx, "tcp", n.Addr)
+    } else {
+        var dialer net.Dialer
+        conn, err = dialer.DialContext(ctx, "tcp", n.Addr)
+    }
+    if err != nil {
+        return fmt.Errorf("failed to connect to mail server: %w", err)
+    }
+    if deadline, ok := ctx.Deadline(); ok {
+        conn.SetDeadline(deadline)
+    }
+    client, err := smtp.NewClient(conn, host)
+    if err != nil {
+        conn.Close()
+        return fmt.Errorf("failed to greet mail server: %w", err)
+    }
+    defer client.Close()
+
+    if n.TLS != TLSImplicit && n.TLS != TLSNone {
+        offered, _ := client.Extension("STARTTLS")
+        if !offered && n.TLS == TLSRequired {
+            return errors.New("mail server does not offer STARTTLS")
+        }
+        if offered {
+            if err := client.StartTLS(tlsConfig); err != nil {
+                return fmt.Errorf("STARTTLS failed: %w", err)
+            }
+        }
+    }
+    if n.Auth != nil {
+        if err := client.Auth(n.Auth); err != nil {
+            return fmt.Errorf("mail server authentication failed: %w", err)
+        }
+    }
+    if err := client.Mail(n.From); err != nil {
+        return fmt.Errorf("mail server refused sender: %w", err)
+    }
+    for _, to := range n.To {
+        if err := client.Rcpt(to); err != nil {
+            return fmt.Errorf("mail server refused recipient %s: %w", to, err)
+        }
+    }
+    w, err := client.Data()
+    if err != nil {
+        return fmt.Errorf("mail server refused message: %w", err)
+    }
+    if _, err := w.Write(n.message(r)); err != nil {
+        return fmt.Errorf("failed to send message: %w", err)
+    }
+    if err := w.Close(); err != nil {
+        return fmt.Errorf("mail server refused message: %w", err)
+    }
+    return client.Quit()
+}
+
+// message formats a reminder as a plain text mail.
+func (n SMTP) message(r models.Reminder) []byte {
+    due := r.Todo.DueDate.Format(time.RFC1123Z)
+    var b bytes.Buffer
+    fmt.Fprintf(&b, "From: %s\r\n", n.From)
+    fmt.Fprintf(&b, "To: %s\r\n", strings.Join(n.To, ", "))
+    fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", "Reminder: "+r.Todo.Title))
+    fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+    b.WriteString("MIME-Version: 1.0\r\n")
+    b.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+    fmt.Fprintf(&b, "%s is due %s.\r\n", r.Todo.Title, due)
+    if r.Todo.Description != "" {
+        fmt.Fprintf(&b, "\r\n%s\r\n", r.Todo.Description)
+    }
+    return b.Bytes()
+}
@@ -0,0 +1,233 @@
+package notify
+
+import (
+    "bufio"
+    "context"
+    "crypto/ecdsa"
+    "crypto/elliptic"
+    "crypto/rand"
+    "crypto/tls"
+    "crypto/x509"
+    "crypto/x509/pkix"
+    "encoding/pem"
+    "math/big"
+    "net"
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/read-my-name/restful_todo_app/pkg/models"
+)
+
+// testCert returns a self-signed certificate for 127.0.0.1 and a pool
+// that trusts it.
+func testCert(t *testing.T) (tls.Certificate, *x509.CertPool, []byte) {
+    t.Helper()
+    key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+    if err != nil {
+        t.Fatal(err)
+    }
+    template := &x509.Certificate{
+        SerialNumber:          big.NewInt(1),
+        Subject:               pkix.Name{CommonName: "test mail server"},
+        NotBefore:             time.Now().Add(-time.Hour),
+        NotAfter:              time.Now().Add(time.Hour),
+        IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+        KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+        ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+        IsCA:                  true,
+        BasicConstraintsValid: true,
+    }
+    der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+    if err != nil {
+        t.Fatal(err)
+    }
+    leaf, err := x509.ParseCertificate(der)
+    if err != nil {
+        t.Fatal(err)
+    }
+    pool := x509.NewCertPool()
+    pool.AddCert(leaf)
+    certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+    return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}, pool, certPEM
+}
+
+// mailServer is a minimal SMTP server that accepts one connection. It
+// offers STARTTLS when starttls is set, and speaks TLS from the start when
+// implicit is set.
+type mailServer struct {
+    addr    string
+    message chan string // the message received, if any
+    tls     chan bool   // whether the message came over TLS
+}
+
+func startMailServer(t *testing.T, cert tls.Certificate, starttls, implicit bool) *mailServer {
+    t.Helper()
+    ln, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(func() { ln.Close() })
+    config := &tls.Config{Certificates: []tls.Certificate{cert}}
+    if implicit {
+        ln = tls.NewListener(ln, config)
+    }
+
+    s := &mailServer{addr: ln.Addr().String(), message: make(chan string, 1), tls: make(chan bool, 1)}
+    go func() {
+        conn, err := ln.Accept()
+        if err != nil {
+            return
+        }
+        defer func() { conn.Close() }()
+        conn.SetDeadline(time.Now().Add(5 * time.Second))
+        secure := implicit
+        r := bufio.NewReader(conn)
+        reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+
+        reply("220 test ESMTP")
+        var body strings.Builder
+        inData := false
+        for {
+            line, err := r.ReadString('\n')
+            if err != nil {
+                return
+            }
+            if inData {
+                if line == ".\r\n" {
+                    inData = false
+                    s.message <- body.String()
+                    s.tls <- secure
+                    reply("250 queued")
+                    continue
+                }
+                body.WriteString(line)
+                continue
+            }
+            switch cmd := strings.ToUpper(strings.TrimSpace(line)); {
+                case strings.HasPrefix(cmd, "EHLO"):
+                    if starttls && !secure {
+                        reply("250-test")
+                        reply("250 STARTTLS")
+                    } else {
+                        reply("250 test")
+                    }
+                case cmd == "STARTTLS":
+                    reply("220 go ahead")
+                    tlsConn := tls.Server(conn, config)
+                    if err := tlsConn.Handshake(); err != nil {
+                        return
+                    }
+                    conn, r, secure = tlsConn, bufio.NewReader(tlsConn), true
+                case cmd == "DATA":
+                    inData = true
+                    reply("354 end with .")
+                case cmd == "QUIT":
+                    reply("221 bye")
+                    return
+                default:
+                    reply("250 ok")
+            }
+        }
+    }()
+    return s
+}
+
+var testReminder = models.Reminder{
+    Todo:    models.Todo{ID: "a", Title: "Pay rent", DueDate: time.Now().Add(time.Hour)},
+    Offset:  "1h",
+    FireAt:  time.Now(),
+    Attempt: 1,
+}
+
+func notifyCtx(t *testing.T) context.Context {
+    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+    t.Cleanup(cancel)
+    return ctx
+}
+
+func TestSMTPTLSModes(t *testing.T) {
+    cert, pool, _ := testCert(t)
+    tests := []struct {
+        name     string
+        mode     TLSMode
+        starttls bool
+        implicit bool
+        wantTLS  bool
+    }{
+        {"opportunistic upgrades", TLSOpportunistic, true, false, true},
+        {"opportunistic falls back to plain text", TLSOpportunistic, false, false, false},
+        {"required upgrades", TLSRequired, true, false, true},
+        {"implicit", TLSImplicit, false, true, true},
+        {"none ignores STARTTLS", TLSNone, true, false, false},
+    }
+    for _, tt := range tests {
+        server := startMailServer(t, cert, tt.starttls, tt.implicit)
+        n := SMTP{Addr: server.addr, From: "todo@localhost", To: []string{"me@localhost"}, TLS: tt.mode, RootCAs: pool}
+        if err := n.Notify(notifyCtx(t), testReminder); err != nil {
+            t.Errorf("%s: %v", tt.name, err)
+            continue
+        }
+        if msg := <-server.message; !strings.Contains(msg, "Subject: Reminder: Pay rent") {
+            t.Errorf("%s: got message %q", tt.name, msg)
+        }
+        if secure := <-server.tls; secure != tt.wantTLS {
+            t.Errorf("%s: sent over TLS: %v, want %v", tt.name, secure, tt.wantTLS)
+        }
+    }
+}
+
+func TestSMTPRequiredTLS(t *testing.T) {
+    cert, pool, _ := testCert(t)
+    server := startMailServer(t, cert, false, false)
+    n := SMTP{Addr: server.addr, From: "todo@localhost", To: []string{"me@localhost"}, TLS: TLSRequired, RootCAs: pool}
+    if err := n.Notify(notifyCtx(t), testReminder); err == nil {
+        t.Fatal("sent in plain text although TLS is required")
+    }
+}
+
+func TestSMTPVerifiesCertificate(t *testing.T) {
+    cert, _, certPEM := testCert(t)
+
+    // The system does not trust the test CA.
+    server := startMailServer(t, cert, true, false)
+    n := SMTP{Addr: server.addr, From: "todo@localhost", To: []string{"me@localhost"}}
+    if err := n.Notify(notifyCtx(t), testReminder); err == nil {
+        t.Fatal("an untrusted certificate was accepted")
+    }
+
+    path := filepath.Join(t.TempDir(), "ca.pem")
+    if err := os.WriteFile(path, certPEM, 0600); err != nil {
+        t.Fatal(err)
+    }
+    pool, err := LoadRootCAs(path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    server = startMailServer(t, cert, true, false)
+    n.Addr, n.RootCAs = server.addr, pool
+    if err := n.Notify(notifyCtx(t), testReminder); err != nil {
+        t.Fatalf("the CA loaded from a file is not trusted: %v", err)
+    }
+    if secure := <-server.tls; !secure {
+        t.Fatal("sent in plain text")
+    }
+
+    if err := os.WriteFile(path, []byte("not a certificate"), 0600); err != nil {
+        t.Fatal(err)
+    }
+    if _, err := LoadRootCAs(path); err == nil {
+        t.Fatal("a file without certificates was accepted")
+    }
+}
+
+func TestParseTLSMode(t *testing.T) {
+    if mode, err := ParseTLSMode(""); err != nil || mode != TLSOpportunistic {
+        t.Errorf("empty mode is %q, %v", mode, err)
+    }
+    if _, err := ParseTLSMode("ssl"); err == nil {
+        t.Error("an unknown mode was accepted")
+    }
+}
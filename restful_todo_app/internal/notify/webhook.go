@@ -0,0 +1,49 @@
+package notify
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "fmt"
+    "io"
+    "net/http"
+
+    "github.com/read-my-name/restful_todo_app/pkg/models"
+)
+
+// Webhook POSTs each reminder as JSON to URL. Any response other than a
+// 2xx counts as a failure, so the reminder is retried.
+type Webhook struct {
+    URL    string
+    Client *http.Client // http.DefaultClient when nil
+}
+
+func (n Webhook) Name() string { return "webhook" }
+
+func (n Webhook) Notify(ctx context.Context, r models.Reminder) error {
+    body, err := json.Marshal(r)
+    if err != nil {
+        return fmt.Errorf("failed to encode reminder: %w", err)
+    }
+    req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.URL, bytes.NewReader(body))
+    if err != nil {
+        return fmt.Errorf("invalid webhook request: %w", err)
+    }
+    req.Header.Set("Content-Type", "application/json")
+
+    client := n.Client
+    if client == nil {
+        client = http.DefaultClient
+    }
+    resp, err := client.Do(req)
+    if err != nil {
+        return fmt.Errorf("webhook request failed: %w", err)
+    }
+    defer resp.Body.Close()
+    // Drain the body so the connection can be reused.
+    io.Copy(io.Discard, resp.Body)
+    if resp.StatusCode < 200 || resp.StatusCode > 299 {
+        return fmt.Errorf("webhook responded %s", resp.Status)
+    }
+    return nil
+}
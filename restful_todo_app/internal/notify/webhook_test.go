@@ -0,0 +1,83 @@
+package notify
+
+import (
+    "encoding/json"
+    "io"
+    "net/http"
+    "net/http/httptest"
+    "testing"
+    "time"
+
+    "github.com/read-my-name/restful_todo_app/pkg/models"
+)
+
+func TestWebhookPostsReminder(t *testing.T) {
+    var got *http.Request
+    var body []byte
+    server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        got = r
+        body, _ = io.ReadAll(r.Body)
+        w.WriteHeader(http.StatusNoContent)
+    }))
+    defer server.Close()
+
+    due := time.Date(2030, 1, 2, 9, 0, 0, 0, time.UTC)
+    reminder := models.Reminder{
+        Todo:    models.Todo{ID: "a", Title: "Pay rent", Status: models.StatusNotStarted, DueDate: due, Reminders: []string{"1d"}},
+        Offset:  "1d",
+        FireAt:  due.AddDate(0, 0, -1),
+        Attempt: 2,
+    }
+    n := Webhook{URL: server.URL + "/hooks/todo"}
+    if err := n.Notify(notifyCtx(t), reminder); err != nil {
+        t.Fatal(err)
+    }
+
+    if got.Method != http.MethodPost || got.URL.Path != "/hooks/todo" {
+        t.Errorf("got %s %s", got.Method, got.URL.Path)
+    }
+    if ct := got.Header.Get("Content-Type"); ct != "application/json" {
+        t.Errorf("got Content-Type %q", ct)
+    }
+    var fields map[string]json.RawMessage
+    if err := json.Unmarshal(body, &fields); err != nil {
+        t.Fatal(err)
+    }
+    for _, name := range []string{"todo", "offset", "fire_at", "attempt"} {
+        if _, ok := fields[name]; !ok {
+            t.Errorf("body %s has no %s", body, name)
+        }
+    }
+    var decoded models.Reminder
+    if err := json.Unmarshal(body, &decoded); err != nil {
+        t.Fatal(err)
+    }
+    if decoded.Todo.ID != "a" || decoded.Todo.Title != "Pay rent" || decoded.Offset != "1d" || !decoded.FireAt.Equal(reminder.FireAt) || decoded.Attempt != 2 {
+        t.Errorf("posted %+v", decoded)
+    }
+}
+
+func TestWebhookFailsOnErrorStatus(t *testing.T) {
+    status := http.StatusOK
+    server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        w.WriteHeader(status)
+    }))
+    defer server.Close()
+    n := Webhook{URL: server.URL, Client: server.Client()}
+
+    for _, status = range []int{http.StatusOK, http.StatusAccepted} {
+        if err := n.Notify(notifyCtx(t), testReminder); err != nil {
+            t.Errorf("%d: %v", status, err)
+        }
+    }
+    for _, status = range []int{http.StatusMovedPermanently, http.StatusBadRequest, http.StatusServiceUnavailable} {
+        if err := n.Notify(notifyCtx(t), testReminder); err == nil {
+            t.Errorf("%d counted as delivered", status)
+        }
+    }
+
+    server.Close()
+    if err := n.Notify(notifyCtx(t), testReminder); err == nil {
+        t.Error("an unreachable webhook counted as delivered")
+    }
+}
@@ -0,0 +1,41 @@
+package service
+
+import "errors"
+
+// Kinds of failure callers can handle with errors.Is. More specific errors,
+// such as ErrInvalidTransition or a *ValidationError, match their kind too.
+var (
+    // ErrNotFound is returned when no todo has the requested ID.
+    ErrNotFound = errors.New("not found")
+    // ErrValidation is matched by every *ValidationError.
+    ErrValidation = errors.New("validation failed")
+    // ErrConflict is returned when a write clashes with the current state
+    // of the todo.
+    ErrConflict = errors.New("conflict")
+    // ErrBackpressure is returned when writes arrive faster than storage
+    // takes them; the write can be retried shortly.
+    ErrBackpressure = errors.New("too many pending writes")
+    // ErrClosed is returned for writes submitted after Close has been called.
+    ErrClosed = errors.New("todo service is closed")
+    // ErrPreconditionRequired is returned for unconditional writes when
+    // the service requires WriteOptions.IfMatch.
+    ErrPreconditionRequired = errors.New("write must name the version it replaces")
+)
+
+// ErrPreconditionFailed is returned when a conditional write names a
+// version the todo no longer has.
+var ErrPreconditionFailed error = &kindError{msg: "todo has been changed since it was read", kind: ErrConflict}
+
+// kindError is a specific error that is also of one of the kinds above.
+type kindError struct {
+    msg  string
+    kind error
+}
+
+func (e *kindError) Error() string {
+    return e.msg
+}
+
+func (e *kindError) Unwrap() error {
+    return e.kind
+}
@@ -0,0 +1,148 @@
+package service
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "strings"
+    "time"
+
+    "github.com/read-my-name/restful_todo_app/pkg/models"
+)
+
+// ErrInvalidFilter is returned when a TodoFilter contains unknown values.
+var ErrInvalidFilter = errors.New("invalid filter")
+
+func validateFilter(filter models.TodoFilter) error {
+    if filter.Period != "" && !isValidPeriod(filter.Period) {
+        return fmt.Errorf("%w: unknown period %q", ErrInvalidFilter, filter.Period)
+    }
+    for _, status := range filter.Statuses {
+        if !isValidStatus(status) {
+            return fmt.Errorf("%w: unknown status %q", ErrInvalidFilter, status)
+        }
+    }
+    for _, priority := range filter.Priorities {
+        if !isValidPriority(priority) {
+            return fmt.Errorf("%w: unknown priority %q", ErrInvalidFilter, priority)
+        }
+    }
+    if !filter.DueAfter.IsZero() && !filter.DueBefore.IsZero() && !filter.DueAfter.Before(filter.DueBefore) {
+        return fmt.Errorf("%w: due_after must be before due_before", ErrInvalidFilter)
+    }
+    return nil
+}
+
+// isFiltered reports whether filter leaves out any todos at all.
+func isFiltered(filter models.TodoFilter) bool {
+    return (filter.Period != "" && filter.Period != "all") ||
+        len(filter.Statuses) > 0 || len(filter.Priorities) > 0 || len(filter.Labels) > 0 ||
+        !filter.DueAfter.IsZero() || !filter.DueBefore.IsZero()
+}
+
+// pushdown returns filter for a Querier, with its period resolved, as seen
+// at now, to the due date range it covers.
+func pushdown(filter models.TodoFilter, now time.Time) models.TodoFilter {
+    start, end, ok := periodBounds(filter.Period, now)
+    switch filter.Period {
+        case "overdue":
+            end, ok = now, true
+        case "upcoming":
+            start, end, ok = now, now.Add(upcomingWindow), true
+    }
+    if ok {
+        if start.After(filter.DueAfter) {
+            filter.DueAfter = start
+        }
+        if filter.DueBefore.IsZero() || end.Before(filter.DueBefore) {
+            filter.DueBefore = end
+        }
+    }
+    filter.Period = ""
+    filter.Location = nil
+    return filter
+}
+
+// queryStorage evaluates filter in storage. Storage only holds the writes
+// that have left the save queue, so the queue is flushed first. Recurring
+// todos due before the period can still recur in it; those are taken from
+// the index, and the result is narrowed down and expanded as in memory.
+func (s *TodoService) queryStorage(q Querier, filter models.TodoFilter) ([]models.Todo, error) {
+    if err := s.Flush(context.Background()); err != nil && !errors.Is(err, ErrClosed) {
+        return nil, err
+    }
+
+    loc := filter.Location
+    if loc == nil {
+        loc = time.Local
+    }
+    now := time.Now().In(loc)
+    pushed := pushdown(filter, now)
+    todos, err := q.Query(pushed)
+    if err != nil {
+        return nil, fmt.Errorf("failed to query storage: %w", err)
+    }
+
+    if _, _, bounded := periodBounds(filter.Period, now); bounded {
+        s.mu.RLock()
+        for _, id := range s.order {
+            if t := s.todos[id]; t.Recurrence != "" && t.DueDate.Before(pushed.DueAfter) {
+                todos = append(todos, t)
+            }
+        }
+        s.mu.RUnlock()
+    }
+    return s.CategorizeTodos(todos, filter), nil
+}
+
+// matchesFilter reports whether t satisfies the status, priority, label and
+// due date range criteria of filter. Fields are combined with AND; the values within a
+// field are combined with OR. Empty fields match everything.
+func matchesFilter(t models.Todo, filter models.TodoFilter) bool {
+    if len(filter.Statuses) > 0 && !containsStatus(filter.Statuses, t.Status) {
+        return false
+    }
+    if len(filter.Priorities) > 0 && !containsPriority(filter.Priorities, t.Priority) {
+        return false
+    }
+    if len(filter.Labels) > 0 && !hasAnyLabel(t.Labels, filter.Labels) {
+        return false
+    }
+    if !filter.DueAfter.IsZero() && t.DueDate.Before(filter.DueAfter) {
+        return false
+    }
+    if !filter.DueBefore.IsZero() && !t.DueDate.Before(filter.DueBefore) {
+        return false
+    }
+    return true
+}
+
+func containsStatus(statuses []models.Status, s models.Status) bool {
+    for _, status := range statuses {
+        if status == s {
+            return true
+        }
+    }
+    return false
+}
+
+func containsPriority(priorities []models.Priority, p models.Priority) bool {
+    for _, priority := range priorities {
+        if priority == p {
+            return true
+        }
+    }
+    return false
+}
+
+// hasAnyLabel reports whether labels contains any of wanted, ignoring case.
+func hasAnyLabel(labels, wanted []string) bool {
+    for _, label := range labels {
+        for _, w := range wanted {
+            if strings.EqualFold(label, w) {
+                return true
+            }
+        }
+    }
+    return false
+}
\ No newline at end of file
@@ -0,0 +1,184 @@
+package service
+
+import (
+    "context"
+    "sync"
+    "testing"
+    "time"
+
+    "github.com/read-my-name/restful_todo_app/pkg/models"
+)
+
+// filterStorage is an in-memory Storage that also evaluates filters, and
+// records the filters it was given.
+type filterStorage struct {
+    mu      sync.Mutex
+    todos   []models.Todo
+    queries []models.TodoFilter
+}
+
+func (s *filterStorage) Save(t models.Todo) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    s.todos = append(s.todos, t)
+    return nil
+}
+
+func (s *filterStorage) SaveAll(todos []models.Todo) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    s.todos = append([]models.Todo(nil), todos...)
+    return nil
+}
+
+func (s *filterStorage) Load() ([]models.Todo, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    return append([]models.Todo(nil), s.todos...), nil
+}
+
+func (s *filterStorage) Update(id string, t models.Todo) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    for i := range s.todos {
+        if s.todos[i].ID == id {
+            s.todos[i] = t
+        }
+    }
+    return nil
+}
+
+func (s *filterStorage) Delete(id string) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    for i := range s.todos {
+        if s.todos[i].ID == id {
+            s.todos = append(s.todos[:i], s.todos[i+1:]...)
+            break
+        }
+    }
+    return nil
+}
+
+func (s *filterStorage) Query(filter models.TodoFilter) ([]models.Todo, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    s.queries = append(s.queries, filter)
+    var todos []models.Todo
+    for _, t := range s.todos {
+        if matchesFilter(t, filter) {
+            todos = append(todos, t)
+        }
+    }
+    return todos, nil
+}
+
+func (s *filterStorage) lastQuery() (models.TodoFilter, int) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    if len(s.queries) == 0 {
+        return models.TodoFilter{}, 0
+    }
+    return s.queries[len(s.queries)-1], len(s.queries)
+}
+
+// newTestService returns a service on an empty filterStorage.
+func newTestService(t *testing.T, opts ...Option) *TodoService {
+    t.Helper()
+    svc := NewTodoService(&filterStorage{}, opts...)
+    t.Cleanup(func() { svc.Close(context.Background()) })
+    return svc
+}
+
+// addTestTodo adds a todo titled title, due in a day.
+func addTestTodo(t *testing.T, svc *TodoService, title string) models.Todo {
+    t.Helper()
+    todo, err := svc.AddTodo(context.Background(), newTestTodo(title, time.Now().Add(24*time.Hour)), WriteOptions{})
+    if err != nil {
+        t.Fatal(err)
+    }
+    return todo
+}
+
+func newTestTodo(title string, due time.Time) models.Todo {
+    return models.Todo{Title: title, Status: models.StatusNotStarted, Priority: models.PriorityLow, DueDate: due}
+}
+
+func TestGetTodosQueriesStorage(t *testing.T) {
+    store := &filterStorage{}
+    svc := NewTodoService(store)
+    t.Cleanup(func() { svc.Close(context.Background()) })
+    ctx := context.Background()
+
+    now := time.Now()
+    soon := newTestTodo("soon", now.Add(time.Hour))
+    soon.Labels = []string{"Work"}
+    if _, err := svc.AddTodo(ctx, soon, WriteOptions{}); err != nil {
+        t.Fatal(err)
+    }
+    if _, err := svc.AddTodo(ctx, newTestTodo("later", now.AddDate(0, 2, 0)), WriteOptions{}); err != nil {
+        t.Fatal(err)
+    }
+
+    // Writes still queued are flushed before the query, so they are found.
+    got, err := svc.GetTodos(models.TodoFilter{Labels: []string{"work"}})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(got) != 1 || got[0].Title != "soon" {
+        t.Fatalf("label filter returned %+v", got)
+    }
+    if q, n := store.lastQuery(); n != 1 || len(q.Labels) != 1 {
+        t.Fatalf("label filter was not passed to storage: %+v (%d queries)", q, n)
+    }
+
+    got, err = svc.GetTodos(models.TodoFilter{Period: "upcoming"})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(got) != 1 || got[0].Title != "soon" {
+        t.Fatalf("upcoming returned %+v", got)
+    }
+    q, _ := store.lastQuery()
+    if q.Period != "" || q.DueAfter.IsZero() || !q.DueBefore.After(q.DueAfter) || q.DueBefore.Sub(q.DueAfter) != upcomingWindow {
+        t.Fatalf("period was not resolved to a due date range: %+v", q)
+    }
+
+    // Unfiltered listings are served from the index.
+    if _, err := svc.GetTodos(models.TodoFilter{}); err != nil {
+        t.Fatal(err)
+    }
+    if _, n := store.lastQuery(); n != 2 {
+        t.Fatalf("unfiltered listing queried storage")
+    }
+}
+
+func TestGetTodosQueryKeepsEarlierRecurrences(t *testing.T) {
+    store := &filterStorage{}
+    svc := NewTodoService(store)
+    t.Cleanup(func() { svc.Close(context.Background()) })
+
+    // Due before this month, but recurring daily into it.
+    start, _, _ := periodBounds("month", time.Now())
+    daily := newTestTodo("daily", start.Add(-time.Hour))
+    daily.ID = "daily"
+    daily.Version = 1
+    daily.Recurrence = "FREQ=DAILY"
+    store.todos = []models.Todo{daily}
+    if _, err := svc.LoadInitialData(); err != nil {
+        t.Fatal(err)
+    }
+
+    got, err := svc.GetTodos(models.TodoFilter{Period: "month"})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(got) == 0 {
+        t.Fatal("no occurrences of a todo recurring into the month")
+    }
+    for _, occ := range got {
+        if !occ.Virtual || occ.DueDate.Before(start) {
+            t.Fatalf("unexpected todo %+v", occ)
+        }
+    }
+}
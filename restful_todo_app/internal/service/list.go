@@ -0,0 +1,183 @@
+package service
+
+import (
+    "encoding/base64"
+    "encoding/json"
+    "fmt"
+    "sort"
+    "strings"
+    "time"
+
+    "github.com/read-my-name/restful_todo_app/pkg/models"
+)
+
+const (
+    defaultPageLimit = 50
+    maxPageLimit     = 500
+    defaultSort      = "created_at"
+)
+
+type sortField struct {
+    name string
+    desc bool
+}
+
+// sortComparators compare two todos on a single field, returning a negative
+// number, zero or a positive number like strings.Compare.
+var sortComparators = map[string]func(a, b models.Todo) int{
+    "due_date":   func(a, b models.Todo) int { return a.DueDate.Compare(b.DueDate) },
+    "created_at": func(a, b models.Todo) int { return a.CreatedAt.Compare(b.CreatedAt) },
+    "updated_at": func(a, b models.Todo) int { return a.UpdatedAt.Compare(b.UpdatedAt) },
+    "priority":   func(a, b models.Todo) int { return a.Priority.Rank() - b.Priority.Rank() },
+    "status":     func(a, b models.Todo) int { return strings.Compare(string(a.Status), string(b.Status)) },
+    "title":      func(a, b models.Todo) int { return strings.Compare(strings.ToLower(a.Title), strings.ToLower(b.Title)) },
+}
+
+func parseSort(raw string) ([]sortField, error) {
+    var fields []sortField
+    for _, part := range strings.Split(raw, ",") {
+        part = strings.TrimSpace(part)
+        f := sortField{name: strings.TrimPrefix(part, "-"), desc: strings.HasPrefix(part, "-")}
+        if _, ok := sortComparators[f.name]; !ok {
+            return nil, fmt.Errorf("%w: unknown sort field %q", ErrInvalidFilter, part)
+        }
+        fields = append(fields, f)
+    }
+    return fields, nil
+}
+
+// less orders todos by fields, falling back to the ID, and the due date of
+// virtual occurrences sharing it, so that the order is total and cursors
+// are stable.
+func less(fields []sortField, a, b models.Todo) bool {
+    for _, f := range fields {
+        c := sortComparators[f.name](a, b)
+        if f.desc {
+            c = -c
+        }
+        if c != 0 {
+            return c < 0
+        }
+    }
+    if a.ID != b.ID {
+        return a.ID < b.ID
+    }
+    return a.DueDate.Before(b.DueDate)
+}
+
+// pageCursor records the sort key of the last item on a page so the next
+// page starts after it even if todos were added or removed in between.
+type pageCursor struct {
+    Sort      string          `json:"s"`
+    ID        string          `json:"id"`
+    Title     string          `json:"t,omitempty"`
+    Status    models.Status   `json:"st,omitempty"`
+    Priority  models.Priority `json:"p,omitempty"`
+    DueDate   time.Time       `json:"d"`
+    CreatedAt time.Time       `json:"c"`
+    UpdatedAt time.Time       `json:"u"`
+}
+
+func encodeCursor(sortSpec string, t models.Todo) string {
+    data, _ := json.Marshal(pageCursor{
+        Sort:      sortSpec,
+        ID:        t.ID,
+        Title:     t.Title,
+        Status:    t.Status,
+        Priority:  t.Priority,
+        DueDate:   t.DueDate,
+        CreatedAt: t.CreatedAt,
+        UpdatedAt: t.UpdatedAt,
+    })
+    return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(raw, sortSpec string) (models.Todo, error) {
+    data, err := base64.RawURLEncoding.DecodeString(raw)
+    if err != nil {
+        return models.Todo{}, fmt.Errorf("%w: malformed cursor", ErrInvalidFilter)
+    }
+    var c pageCursor
+    if err := json.Unmarshal(data, &c); err != nil {
+        return models.Todo{}, fmt.Errorf("%w: malformed cursor", ErrInvalidFilter)
+    }
+    if c.Sort != sortSpec {
+        return models.Todo{}, fmt.Errorf("%w: cursor was issued for sort %q", ErrInvalidFilter, c.Sort)
+    }
+    return models.Todo{
+        ID:        c.ID,
+        Title:     c.Title,
+        Status:    c.Status,
+        Priority:  c.Priority,
+        DueDate:   c.DueDate,
+        CreatedAt: c.CreatedAt,
+        UpdatedAt: c.UpdatedAt,
+    }, nil
+}
+
+// checkPaging rejects a negative limit or offset and applies the default
+// and maximum page sizes.
+func checkPaging(opts models.ListOptions) (models.ListOptions, error) {
+    if opts.Limit < 0 || opts.Offset < 0 {
+        return opts, fmt.Errorf("%w: limit and offset must not be negative", ErrInvalidFilter)
+    }
+    if opts.Limit == 0 {
+        opts.Limit = defaultPageLimit
+    }
+    if opts.Limit > maxPageLimit {
+        opts.Limit = maxPageLimit
+    }
+    return opts, nil
+}
+
+// ListTodos returns one sorted page of the todos matching filter. Pages are
+// addressed either by Offset or by the Cursor of the previous page.
+func (s *TodoService) ListTodos(filter models.TodoFilter, opts models.ListOptions) (models.TodoPage, error) {
+    opts, err := checkPaging(opts)
+    if err != nil {
+        return models.TodoPage{}, err
+    }
+    if opts.Cursor != "" && opts.Offset > 0 {
+        return models.TodoPage{}, fmt.Errorf("%w: cursor and offset cannot be combined", ErrInvalidFilter)
+    }
+    if opts.Sort == "" {
+        opts.Sort = defaultSort
+    }
+    fields, err := parseSort(opts.Sort)
+    if err != nil {
+        return models.TodoPage{}, err
+    }
+
+    todos, err := s.GetTodos(filter)
+    if err != nil {
+        return models.TodoPage{}, err
+    }
+    sort.Slice(todos, func(i, j int) bool { return less(fields, todos[i], todos[j]) })
+
+    start := opts.Offset
+    if opts.Cursor != "" {
+        after, err := decodeCursor(opts.Cursor, opts.Sort)
+        if err != nil {
+            return models.TodoPage{}, err
+        }
+        start = sort.Search(len(todos), func(i int) bool { return less(fields, after, todos[i]) })
+    }
+    if start > len(todos) {
+        start = len(todos)
+    }
+    end := start + opts.Limit
+    if end > len(todos) {
+        end = len(todos)
+    }
+
+    page := models.TodoPage{
+        Items:  todos[start:end],
+        Total:  len(todos),
+        Limit:  opts.Limit,
+        Offset: start,
+    }
+    if end < len(todos) && end > start {
+        page.NextCursor = encodeCursor(opts.Sort, todos[end-1])
+    }
+    return page, nil
+}
\ No newline at end of file
@@ -0,0 +1,64 @@
+package service
+
+// Option configures optional TodoService behaviour.
+type Option func(*TodoService)
+
+// WithAutoComplete moves a todo to completed once every one of its
+// subtasks has been completed through the subtask operations.
+func WithAutoComplete(enabled bool) Option {
+    return func(s *TodoService) {
+        s.autoComplete = enabled
+    }
+}
+
+// WithWorkflow replaces the default status workflow.
+func WithWorkflow(w Workflow) Option {
+    return func(s *TodoService) {
+        s.workflow = w
+    }
+}
+
+// WithLimits replaces the default maximum field lengths.
+func WithLimits(l Limits) Option {
+    return func(s *TodoService) {
+        s.limits = l
+    }
+}
+
+// WithRequireIfMatch refuses writes to existing todos that do not set
+// WriteOptions.IfMatch, so that no client overwrites a change it has not
+// seen.
+func WithRequireIfMatch(required bool) Option {
+    return func(s *TodoService) {
+        s.requireIfMatch = required
+    }
+}
+
+// WithNotifiers sends the reminders of todos through each of notifiers,
+// recording deliveries in log. A nil log keeps them in memory, so
+// reminders that were sent before a restart are sent again.
+func WithNotifiers(log ReminderLog, notifiers ...Notifier) Option {
+    return func(s *TodoService) {
+        if log != nil {
+            s.reminderLog = log
+        }
+        s.notifiers = notifiers
+    }
+}
+
+// WithRetryPolicy replaces the default retries of failed reminders.
+func WithRetryPolicy(p RetryPolicy) Option {
+    return func(s *TodoService) {
+        s.retry = p
+    }
+}
+
+// WithQueueSizes sets how many writes can wait for storage before new
+// ones are refused, and how many storage errors are buffered for the
+// error handler.
+func WithQueueSizes(saveQueue, errorQueue int) Option {
+    return func(s *TodoService) {
+        s.saveQueue = make(chan saveOp, saveQueue)
+        s.errorChan = make(chan error, errorQueue)
+    }
+}
@@ -0,0 +1,75 @@
+package service
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+
+    "github.com/read-my-name/restful_todo_app/pkg/models"
+)
+
+// ErrInvalidPatch is returned when a patch document cannot be applied.
+var ErrInvalidPatch = errors.New("invalid patch")
+
+// applyMergePatch applies an RFC 7396 JSON Merge Patch to t. Members
+// that are not fields of a todo are ignored, as they are by PUT.
+func applyMergePatch(t models.Todo, patch []byte) (models.Todo, error) {
+    var patched models.Todo
+    if err := applyMergePatchTo(t, patch, &patched); err != nil {
+        return models.Todo{}, err
+    }
+    return patched, nil
+}
+
+// applyMergePatchTo merges patch into the JSON form of current and decodes
+// the result into out.
+func applyMergePatchTo(current interface{}, patch []byte, out interface{}) error {
+    var patchDoc interface{}
+    if err := json.Unmarshal(patch, &patchDoc); err != nil {
+        return fmt.Errorf("%w: %v", ErrInvalidPatch, err)
+    }
+    // A non-object patch would replace the whole value, which is what PUT is for.
+    if _, ok := patchDoc.(map[string]interface{}); !ok {
+        return fmt.Errorf("%w: patch must be a JSON object", ErrInvalidPatch)
+    }
+
+    data, err := json.Marshal(current)
+    if err != nil {
+        return err
+    }
+    var target interface{}
+    if err := json.Unmarshal(data, &target); err != nil {
+        return err
+    }
+
+    merged, err := json.Marshal(mergePatch(target, patchDoc))
+    if err != nil {
+        return err
+    }
+    if err := json.Unmarshal(merged, out); err != nil {
+        return fmt.Errorf("%w: %v", ErrInvalidPatch, err)
+    }
+    return nil
+}
+
+// mergePatch implements the MergePatch algorithm from RFC 7396 section 2:
+// objects are merged recursively, null removes a member and any other value
+// replaces the target.
+func mergePatch(target, patch interface{}) interface{} {
+    patchObj, ok := patch.(map[string]interface{})
+    if !ok {
+        return patch
+    }
+    targetObj, ok := target.(map[string]interface{})
+    if !ok {
+        targetObj = map[string]interface{}{}
+    }
+    for key, value := range patchObj {
+        if value == nil {
+            delete(targetObj, key)
+        } else {
+            targetObj[key] = mergePatch(targetObj[key], value)
+        }
+    }
+    return targetObj
+}
@@ -0,0 +1,153 @@
+package service
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "reflect"
+    "testing"
+    "time"
+
+    "github.com/read-my-name/restful_todo_app/pkg/models"
+)
+
+func TestMergePatch(t *testing.T) {
+    // The examples of RFC 7396 appendix A.
+    tests := []struct {
+        target, patch, want string
+    }{
+        {`{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+        {`{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+        {`{"a":"b"}`, `{"a":null}`, `{}`},
+        {`{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`},
+        {`{"a":["b"]}`, `{"a":"c"}`, `{"a":"c"}`},
+        {`{"a":"c"}`, `{"a":["b"]}`, `{"a":["b"]}`},
+        {`{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`},
+        {`{"a":[{"b":"c"}]}`, `{"a":[1]}`, `{"a":[1]}`},
+        {`["a","b"]`, `["c","d"]`, `["c","d"]`},
+        {`{"a":"b"}`, `["c"]`, `["c"]`},
+        {`{"a":"foo"}`, `null`, `null`},
+        {`{"a":"foo"}`, `"bar"`, `"bar"`},
+        {`{"e":null}`, `{"a":1}`, `{"e":null,"a":1}`},
+        {`[1,2]`, `{"a":"b","c":null}`, `{"a":"b"}`},
+        {`{}`, `{"a":{"bb":{"ccc":null}}}`, `{"a":{"bb":{}}}`},
+        // Nested objects merge member by member, arrays are replaced.
+        {`{"a":{"b":1,"c":[1,2]},"d":1}`, `{"a":{"c":[3]}}`, `{"a":{"b":1,"c":[3]},"d":1}`},
+    }
+    for _, tt := range tests {
+        var target, patch, want interface{}
+        for _, doc := range []struct {
+            json string
+            v    *interface{}
+        }{{tt.target, &target}, {tt.patch, &patch}, {tt.want, &want}} {
+            if err := json.Unmarshal([]byte(doc.json), doc.v); err != nil {
+                t.Fatal(err)
+            }
+        }
+        if got := mergePatch(target, patch); !reflect.DeepEqual(got, want) {
+            t.Errorf("%s patched with %s: got %v, want %s", tt.target, tt.patch, got, tt.want)
+        }
+    }
+}
+
+func TestApplyMergePatch(t *testing.T) {
+    todo := addTestTodo(t, newTestService(t), "Write report")
+    todo.Description = "for finance"
+    todo.Labels = []string{"work", "urgent"}
+    todo.Reminders = []string{"1d"}
+    todo.Subtasks = []models.Subtask{{ID: "s1", Title: "Draft"}, {ID: "s2", Title: "Send"}}
+
+    tests := []struct {
+        name, patch string
+        change      func(*models.Todo)
+    }{
+        {"empty", `{}`, func(*models.Todo) {}},
+        {"replaced", `{"title":"Write summary","priority":"high"}`, func(want *models.Todo) {
+            want.Title = "Write summary"
+            want.Priority = models.PriorityHigh
+        }},
+        {"deleted", `{"description":null,"labels":null,"reminders":null}`, func(want *models.Todo) {
+            want.Description = ""
+            want.Labels = nil
+            want.Reminders = nil
+        }},
+        {"arrays replaced", `{"labels":["home"],"subtasks":[{"id":"s2","title":"Send"}]}`, func(want *models.Todo) {
+            want.Labels = []string{"home"}
+            want.Subtasks = []models.Subtask{{ID: "s2", Title: "Send"}}
+        }},
+        {"unknown ignored", `{"colour":"red","owner":{"name":"ann"}}`, func(*models.Todo) {}},
+    }
+    for _, tt := range tests {
+        want := todo
+        want.Labels = append([]string(nil), todo.Labels...)
+        tt.change(&want)
+        got, err := applyMergePatch(todo, []byte(tt.patch))
+        if err != nil {
+            t.Errorf("%s: %v", tt.name, err)
+            continue
+        }
+        // Times go through JSON, so compare them separately.
+        if !got.DueDate.Equal(want.DueDate) || !got.CreatedAt.Equal(want.CreatedAt) {
+            t.Errorf("%s: times changed to %v and %v", tt.name, got.DueDate, got.CreatedAt)
+        }
+        got.DueDate, got.CreatedAt, got.UpdatedAt = want.DueDate, want.CreatedAt, want.UpdatedAt
+        if !reflect.DeepEqual(got, want) {
+            t.Errorf("%s: got %+v, want %+v", tt.name, got, want)
+        }
+    }
+    if len(todo.Labels) != 2 || todo.Description != "for finance" {
+        t.Errorf("patching changed the original todo: %+v", todo)
+    }
+}
+
+func TestApplyMergePatchRejects(t *testing.T) {
+    todo := newTestTodo("Write report", time.Now())
+    for _, patch := range []string{``, `{`, `null`, `[]`, `"title"`, `42`, `{"title":5}`, `{"labels":"work"}`, `{"due_date":"tomorrow"}`} {
+        if _, err := applyMergePatch(todo, []byte(patch)); !errors.Is(err, ErrInvalidPatch) {
+            t.Errorf("%q: got %v, want an invalid patch", patch, err)
+        }
+    }
+}
+
+func TestPatchTodo(t *testing.T) {
+    ctx := context.Background()
+    svc := newTestService(t)
+    todo := addTestTodo(t, svc, "Write report")
+
+    patched, err := svc.PatchTodo(ctx, todo.ID, []byte(`{"status":"in_progress","id":"other","version":40}`), WriteOptions{})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if patched.Status != models.StatusInProgress || patched.ID != todo.ID || patched.Version != todo.Version+1 || patched.Title != todo.Title {
+        t.Fatalf("patched to %+v", patched)
+    }
+
+    // Status changes go through the workflow.
+    if _, err := svc.PatchTodo(ctx, todo.ID, []byte(`{"status":"archived"}`), WriteOptions{}); !errors.Is(err, ErrInvalidTransition) || !errors.Is(err, ErrConflict) {
+        t.Fatalf("archiving an active todo: got %v, want an invalid transition", err)
+    }
+    completed, err := svc.PatchTodo(ctx, todo.ID, []byte(`{"status":"completed"}`), WriteOptions{})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if completed.CompletedAt == nil {
+        t.Fatal("completing through a patch did not stamp completed_at")
+    }
+    if _, err := svc.PatchTodo(ctx, todo.ID, []byte(`{"status":"in_progress"}`), WriteOptions{}); !errors.Is(err, ErrInvalidTransition) {
+        t.Fatalf("reopening through a patch: got %v, want an invalid transition", err)
+    }
+
+    // A patched todo is validated like any other, and failures change nothing.
+    if _, err := svc.PatchTodo(ctx, todo.ID, []byte(`{"priority":null}`), WriteOptions{}); !errors.Is(err, ErrValidation) {
+        t.Fatalf("removing the priority: got %v, want a validation error", err)
+    }
+    if _, err := svc.PatchTodo(ctx, todo.ID, []byte(`{"status":"done"}`), WriteOptions{}); !errors.Is(err, ErrValidation) {
+        t.Fatalf("an unknown status: got %v, want a validation error", err)
+    }
+    if _, err := svc.PatchTodo(ctx, "missing", []byte(`{}`), WriteOptions{}); !errors.Is(err, ErrNotFound) {
+        t.Fatalf("patching a missing todo: got %v", err)
+    }
+    if got, err := svc.GetTodo(todo.ID); err != nil || got.Version != completed.Version || got.Status != models.StatusCompleted {
+        t.Fatalf("refused patches changed the todo to %+v, %v", got, err)
+    }
+}
@@ -0,0 +1,59 @@
+package service
+
+import (
+    "time"
+
+    "github.com/read-my-name/restful_todo_app/pkg/models"
+)
+
+// upcomingWindow is how far ahead the "upcoming" period looks.
+const upcomingWindow = 7 * 24 * time.Hour
+
+// startOfDay returns local midnight of t's day in t's location.
+func startOfDay(t time.Time) time.Time {
+    y, m, d := t.Date()
+    return time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+}
+
+// periodBounds returns the half-open interval [start, end) covered by a
+// calendar period relative to now. Days are stepped with AddDate so that
+// daylight saving transitions are handled by the location.
+func periodBounds(period string, now time.Time) (time.Time, time.Time, bool) {
+    today := startOfDay(now)
+    switch period {
+        case "today":
+            return today, today.AddDate(0, 0, 1), true
+        case "tomorrow":
+            return today.AddDate(0, 0, 1), today.AddDate(0, 0, 2), true
+        case "week":
+            // ISO weeks start on Monday.
+            offset := (int(today.Weekday()) + 6) % 7
+            start := today.AddDate(0, 0, -offset)
+            return start, start.AddDate(0, 0, 7), true
+        case "month":
+            start := time.Date(today.Year(), today.Month(), 1, 0, 0, 0, 0, today.Location())
+            return start, start.AddDate(0, 1, 0), true
+    }
+    return time.Time{}, time.Time{}, false
+}
+
+func isDone(t models.Todo) bool {
+    return t.Status == models.StatusCompleted || t.Status == models.StatusArchived
+}
+
+// inPeriod reports whether t falls into period as seen at now.
+func inPeriod(t models.Todo, period string, now time.Time) bool {
+    switch period {
+        case "", "all":
+            return true
+        case "overdue":
+            return !t.DueDate.IsZero() && t.DueDate.Before(now) && !isDone(t)
+        case "upcoming":
+            return !t.DueDate.Before(now) && t.DueDate.Before(now.Add(upcomingWindow)) && !isDone(t)
+    }
+    start, end, ok := periodBounds(period, now)
+    if !ok {
+        return false
+    }
+    return !t.DueDate.Before(start) && t.DueDate.Before(end)
+}
@@ -0,0 +1,403 @@
+package service
+
+import (
+    "errors"
+    "fmt"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/google/uuid"
+    "github.com/read-my-name/restful_todo_app/pkg/models"
+)
+
+// maxOccurrences bounds how many virtual occurrences of one todo a listing
+// expands within its period.
+const maxOccurrences = 1000
+
+// maxMonths bounds how far ahead a monthly rule looks for its next
+// occurrence, so that a rule no month satisfies ends the schedule.
+const maxMonths = 1000
+
+var weekdays = map[string]time.Weekday{
+    "SU": time.Sunday, "MO": time.Monday, "TU": time.Tuesday, "WE": time.Wednesday,
+    "TH": time.Thursday, "FR": time.Friday, "SA": time.Saturday,
+}
+
+// weekdayNum is a BYDAY value such as MO, or 2TU and -1FR in monthly rules.
+type weekdayNum struct {
+    n   int // nth weekday of the month, negative counting from its end; 0 for every one
+    day time.Weekday
+}
+
+func (w weekdayNum) String() string {
+    name := strings.ToUpper(w.day.String()[:2])
+    if w.n != 0 {
+        return strconv.Itoa(w.n) + name
+    }
+    return name
+}
+
+// rrule is the subset of an RFC 5545 recurrence rule that todos support:
+// FREQ (DAILY, WEEKLY or MONTHLY), INTERVAL, BYDAY, UNTIL and COUNT. The
+// schedule starts at the todo's due date, and weeks start on Monday.
+type rrule struct {
+    freq     string
+    interval int
+    byDay    []weekdayNum
+    until    time.Time // zero when unbounded
+    count    int       // occurrences left, the current one included; 0 when unbounded
+}
+
+const untilLayout = "20060102T150405Z"
+
+// parseRRule reads a rule such as "FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,TH",
+// with or without the "RRULE:" prefix.
+func parseRRule(raw string) (rrule, error) {
+    r := rrule{interval: 1}
+    raw = strings.TrimSpace(raw)
+    if len(raw) >= 6 && strings.EqualFold(raw[:6], "RRULE:") {
+        raw = raw[6:]
+    }
+
+    seen := make(map[string]bool)
+    for _, part := range strings.Split(raw, ";") {
+        key, value, ok := strings.Cut(part, "=")
+        key = strings.ToUpper(strings.TrimSpace(key))
+        value = strings.ToUpper(strings.TrimSpace(value))
+        if !ok || value == "" {
+            return rrule{}, fmt.Errorf("malformed rule part %q", part)
+        }
+        if seen[key] {
+            return rrule{}, fmt.Errorf("%s given twice", key)
+        }
+        seen[key] = true
+
+        switch key {
+            case "FREQ":
+                if value != "DAILY" && value != "WEEKLY" && value != "MONTHLY" {
+                    return rrule{}, fmt.Errorf("unsupported FREQ %s, want DAILY, WEEKLY or MONTHLY", value)
+                }
+                r.freq = value
+            case "INTERVAL":
+                n, err := strconv.Atoi(value)
+                if err != nil || n < 1 {
+                    return rrule{}, fmt.Errorf("invalid INTERVAL %s", value)
+                }
+                r.interval = n
+            case "COUNT":
+                n, err := strconv.Atoi(value)
+                if err != nil || n < 1 {
+                    return rrule{}, fmt.Errorf("invalid COUNT %s", value)
+                }
+                r.count = n
+            case "UNTIL":
+                until, err := time.Parse(untilLayout, value)
+                if err != nil {
+                    // A date includes the whole of that day.
+                    date, dateErr := time.Parse("20060102", value)
+                    if dateErr != nil {
+                        return rrule{}, fmt.Errorf("invalid UNTIL %s", value)
+                    }
+                    until = date.Add(24*time.Hour - time.Second)
+                }
+                r.until = until
+            case "BYDAY":
+                for _, item := range strings.Split(value, ",") {
+                    day, err := parseWeekdayNum(item)
+                    if err != nil {
+                        return rrule{}, err
+                    }
+                    r.byDay = append(r.byDay, day)
+                }
+            default:
+                return rrule{}, fmt.Errorf("unsupported rule part %s", key)
+        }
+    }
+
+    if r.freq == "" {
+        return rrule{}, errors.New("FREQ is required")
+    }
+    if r.count > 0 && !r.until.IsZero() {
+        return rrule{}, errors.New("COUNT and UNTIL cannot be combined")
+    }
+    for _, day := range r.byDay {
+        if day.n != 0 && r.freq != "MONTHLY" {
+            return rrule{}, fmt.Errorf("BYDAY %s needs FREQ=MONTHLY", day)
+        }
+    }
+    return r, nil
+}
+
+func parseWeekdayNum(item string) (weekdayNum, error) {
+    item = strings.TrimSpace(item)
+    if len(item) < 2 {
+        return weekdayNum{}, fmt.Errorf("invalid BYDAY %q", item)
+    }
+    day, ok := weekdays[item[len(item)-2:]]
+    if !ok {
+        return weekdayNum{}, fmt.Errorf("invalid BYDAY %q", item)
+    }
+    w := weekdayNum{day: day}
+    if prefix := item[:len(item)-2]; prefix != "" {
+        n, err := strconv.Atoi(prefix)
+        if err != nil || n == 0 || n < -5 || n > 5 {
+            return weekdayNum{}, fmt.Errorf("invalid BYDAY %q", item)
+        }
+        w.n = n
+    }
+    return w, nil
+}
+
+// String formats r in canonical form, which is what todos store.
+func (r rrule) String() string {
+    parts := []string{"FREQ=" + r.freq}
+    if r.interval > 1 {
+        parts = append(parts, "INTERVAL="+strconv.Itoa(r.interval))
+    }
+    if len(r.byDay) > 0 {
+        days := make([]string, len(r.byDay))
+        for i, day := range r.byDay {
+            days[i] = day.String()
+        }
+        parts = append(parts, "BYDAY="+strings.Join(days, ","))
+    }
+    if !r.until.IsZero() {
+        parts = append(parts, "UNTIL="+r.until.UTC().Format(untilLayout))
+    }
+    if r.count > 0 {
+        parts = append(parts, "COUNT="+strconv.Itoa(r.count))
+    }
+    return strings.Join(parts, ";")
+}
+
+// next returns the occurrence after the current one, at, and the rule
+// that applies from there on. ok is false when the schedule has ended.
+func (r rrule) next(at time.Time) (time.Time, rrule, bool) {
+    if r.count == 1 {
+        return time.Time{}, rrule{}, false
+    }
+    var next time.Time
+    var ok bool
+    switch r.freq {
+        case "DAILY":
+            next, ok = r.nextDaily(at)
+        case "WEEKLY":
+            next, ok = r.nextWeekly(at)
+        case "MONTHLY":
+            next, ok = r.nextMonthly(at)
+    }
+    if !ok || (!r.until.IsZero() && next.After(r.until)) {
+        return time.Time{}, rrule{}, false
+    }
+    if r.count > 0 {
+        r.count--
+    }
+    return next, r, true
+}
+
+func (r rrule) allowsDay(day time.Weekday) bool {
+    if len(r.byDay) == 0 {
+        return true
+    }
+    for _, d := range r.byDay {
+        if d.day == day {
+            return true
+        }
+    }
+    return false
+}
+
+// Days are stepped with AddDate so that the time of day survives daylight
+// saving changes.
+func (r rrule) nextDaily(at time.Time) (time.Time, bool) {
+    // Stepping by the interval visits every weekday it can within a week
+    // of steps.
+    for k := 1; k <= 7; k++ {
+        next := at.AddDate(0, 0, k*r.interval)
+        if r.allowsDay(next.Weekday()) {
+            return next, true
+        }
+    }
+    return time.Time{}, false
+}
+
+func (r rrule) nextWeekly(at time.Time) (time.Time, bool) {
+    days := r.byDay
+    if len(days) == 0 {
+        days = []weekdayNum{{day: at.Weekday()}}
+    }
+    week := mondayOf(at)
+    for k := 1; k <= 7*(r.interval+1); k++ {
+        next := at.AddDate(0, 0, k)
+        weeks := int(mondayOf(next).Sub(week).Hours()+12) / (7 * 24)
+        if weeks%r.interval != 0 {
+            continue
+        }
+        for _, d := range days {
+            if d.day == next.Weekday() {
+                return next, true
+            }
+        }
+    }
+    return time.Time{}, false
+}
+
+// mondayOf returns midnight of the Monday starting t's week.
+func mondayOf(t time.Time) time.Time {
+    offset := (int(t.Weekday()) + 6) % 7
+    return startOfDay(t).AddDate(0, 0, -offset)
+}
+
+// nextMonthly keeps the day of the month of at, skipping months that are
+// too short, or picks the BYDAY weekdays of each month.
+func (r rrule) nextMonthly(at time.Time) (time.Time, bool) {
+    hour, minute, sec := at.Clock()
+    first := time.Date(at.Year(), at.Month(), 1, hour, minute, sec, at.Nanosecond(), at.Location())
+    for k := 0; k <= maxMonths; k += r.interval {
+        month := first.AddDate(0, k, 0)
+        for _, day := range r.daysOf(month, at.Day()) {
+            if next := month.AddDate(0, 0, day-1); next.After(at) {
+                return next, true
+            }
+        }
+    }
+    return time.Time{}, false
+}
+
+// daysOf returns the days of month, in order, that the rule picks.
+func (r rrule) daysOf(month time.Time, dayOfMonth int) []int {
+    length := month.AddDate(0, 1, -1).Day()
+    if len(r.byDay) == 0 {
+        if dayOfMonth > length {
+            return nil
+        }
+        return []int{dayOfMonth}
+    }
+
+    var days []int
+    for _, w := range r.byDay {
+        first := 1 + (int(w.day)-int(month.Weekday())+7)%7
+        var matches []int
+        for day := first; day <= length; day += 7 {
+            matches = append(matches, day)
+        }
+        switch {
+            case w.n == 0:
+                days = append(days, matches...)
+            case w.n > 0 && w.n <= len(matches):
+                days = append(days, matches[w.n-1])
+            case w.n < 0 && -w.n <= len(matches):
+                days = append(days, matches[len(matches)+w.n])
+        }
+    }
+    sort.Ints(days)
+    return days
+}
+
+// checkRecurrence reports why a todo's recurrence cannot be used, if it
+// cannot.
+func checkRecurrence(v *validator, t models.Todo) {
+    if t.Recurrence == "" {
+        return
+    }
+    r, err := parseRRule(t.Recurrence)
+    if err != nil || !t.DueDate.IsZero() && !r.recurs(t.DueDate) {
+        v.add("recurrence", CodeInvalid)
+    }
+    if t.DueDate.IsZero() {
+        v.add("due_date", CodeRequired)
+    }
+}
+
+// recurs reports whether the rule yields an occurrence after due, leaving
+// COUNT and UNTIL aside. A rule can match no day it ever reaches, such as
+// FREQ=DAILY;INTERVAL=7;BYDAY=TU from a Monday, which only reaches
+// Mondays.
+func (r rrule) recurs(due time.Time) bool {
+    r.count, r.until = 0, time.Time{}
+    _, _, ok := r.next(due)
+    return ok
+}
+
+// normalizeRecurrence stores a valid rule in canonical form.
+func normalizeRecurrence(t *models.Todo) {
+    if t.Recurrence == "" {
+        return
+    }
+    if r, err := parseRRule(t.Recurrence); err == nil {
+        t.Recurrence = r.String()
+    }
+}
+
+// nextInstance returns the todo that follows done, an occurrence of a
+// recurring todo that has just been completed: a fresh todo due at the
+// next occurrence, with the same details, labels and reminders, and its
+// subtasks reset. ok is false when the schedule has ended.
+func nextInstance(done models.Todo, now time.Time) (models.Todo, bool) {
+    r, err := parseRRule(done.Recurrence)
+    if err != nil {
+        return models.Todo{}, false
+    }
+    due, rest, ok := r.next(done.DueDate)
+    if !ok {
+        return models.Todo{}, false
+    }
+
+    subtasks := make([]models.Subtask, len(done.Subtasks))
+    for i, st := range done.Subtasks {
+        subtasks[i] = models.Subtask{ID: uuid.New().String(), Title: st.Title, CreatedAt: now, UpdatedAt: now}
+    }
+    return models.Todo{
+        ID:          uuid.New().String(),
+        Title:       done.Title,
+        Description: done.Description,
+        Status:      models.StatusNotStarted,
+        Priority:    done.Priority,
+        DueDate:     due,
+        CreatedAt:   now,
+        UpdatedAt:   now,
+        Labels:      append([]string(nil), done.Labels...),
+        Reminders:   append([]string(nil), done.Reminders...),
+        Subtasks:    subtasks,
+        Recurrence:  rest.String(),
+        Version:     1,
+    }, true
+}
+
+// occurrences returns virtual copies of a recurring todo for its
+// occurrences after its due date within [start, end), at most
+// maxOccurrences of them. They are listed like todos but not stored;
+// completing the todo itself creates the next one.
+func occurrences(t models.Todo, start, end time.Time) []models.Todo {
+    if t.Recurrence == "" || isDone(t) {
+        return nil
+    }
+    r, err := parseRRule(t.Recurrence)
+    if err != nil {
+        return nil
+    }
+
+    var copies []models.Todo
+    at := t.DueDate
+    for len(copies) < maxOccurrences {
+        next, rest, ok := r.next(at)
+        if !ok || !next.Before(end) {
+            break
+        }
+        if next.Before(start) {
+            // Stepped over without counting, so that a series that began
+            // long ago still reaches the period.
+            at, r = next, rest
+            continue
+        }
+        occurrence := t
+        occurrence.DueDate = next
+        occurrence.Recurrence = rest.String()
+        occurrence.Virtual = true
+        copies = append(copies, occurrence)
+        at, r = next, rest
+    }
+    return copies
+}
@@ -0,0 +1,115 @@
+package service
+
+import (
+    "errors"
+    "testing"
+    "time"
+
+    "github.com/read-my-name/restful_todo_app/pkg/models"
+)
+
+// monday is 9:00 on Monday, January 1, 2024.
+var monday = time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC)
+
+func schedule(t *testing.T, rule string, start time.Time, n int) []string {
+    t.Helper()
+    r, err := parseRRule(rule)
+    if err != nil {
+        t.Fatalf("%s: %v", rule, err)
+    }
+    days := []string{start.Format("Mon 01-02")}
+    at := start
+    for len(days) < n {
+        next, rest, ok := r.next(at)
+        if !ok {
+            break
+        }
+        days = append(days, next.Format("Mon 01-02"))
+        at, r = next, rest
+    }
+    return days
+}
+
+func TestRRuleNext(t *testing.T) {
+    tests := []struct {
+        rule  string
+        start time.Time
+        want  string
+    }{
+        {"FREQ=DAILY;INTERVAL=2", monday, "[Mon 01-01 Wed 01-03 Fri 01-05 Sun 01-07]"},
+        {"FREQ=DAILY;INTERVAL=3;BYDAY=TU", monday, "[Mon 01-01 Tue 01-16 Tue 02-06 Tue 02-27]"},
+        {"FREQ=DAILY;INTERVAL=7;BYDAY=MO", monday, "[Mon 01-01 Mon 01-08 Mon 01-15 Mon 01-22]"},
+        {"FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,WE", monday, "[Mon 01-01 Wed 01-03 Mon 01-15 Wed 01-17]"},
+        {"FREQ=MONTHLY", monday.AddDate(0, 0, 30), "[Wed 01-31 Sun 03-31 Fri 05-31 Wed 07-31]"},
+        {"FREQ=MONTHLY;BYDAY=-1FR", monday, "[Mon 01-01 Fri 01-26 Fri 02-23 Fri 03-29]"},
+        {"FREQ=DAILY;COUNT=3", monday, "[Mon 01-01 Tue 01-02 Wed 01-03]"},
+    }
+    for _, tt := range tests {
+        if got := fmtDays(schedule(t, tt.rule, tt.start, 4)); got != tt.want {
+            t.Errorf("%s: got %s, want %s", tt.rule, got, tt.want)
+        }
+    }
+}
+
+func fmtDays(days []string) string {
+    out := "["
+    for i, day := range days {
+        if i > 0 {
+            out += " "
+        }
+        out += day
+    }
+    return out + "]"
+}
+
+func TestRecurrenceMustRecur(t *testing.T) {
+    todo := newTestTodo("weekly review", monday)
+    todo.ID = "a"
+    todo.Recurrence = "FREQ=DAILY;INTERVAL=7;BYDAY=TU"
+    err := ValidateStoredTodo(todo, DefaultLimits())
+    var validationErr *ValidationError
+    if !errors.As(err, &validationErr) || len(validationErr.Fields) != 1 || validationErr.Fields[0].Field != "recurrence" {
+        t.Fatalf("a rule that never reaches a Tuesday from a Monday got %v", err)
+    }
+
+    // The same rule is fine from a Tuesday.
+    todo.DueDate = monday.AddDate(0, 0, 1)
+    if err := ValidateStoredTodo(todo, DefaultLimits()); err != nil {
+        t.Fatalf("a rule that recurs on Tuesdays from a Tuesday got %v", err)
+    }
+    if _, ok := nextInstance(todo, time.Now()); !ok {
+        t.Fatal("completing the todo ended the series")
+    }
+}
+
+func TestOccurrencesCapCountsFromPeriodStart(t *testing.T) {
+    // Due long enough ago that more than maxOccurrences days have passed.
+    daily := newTestTodo("daily", monday)
+    daily.Recurrence = "FREQ=DAILY"
+    start := monday.AddDate(0, 0, 2*maxOccurrences)
+    end := start.AddDate(0, 0, 7)
+
+    got := occurrences(daily, start, end)
+    if len(got) != 7 {
+        t.Fatalf("got %d occurrences in a week, want 7", len(got))
+    }
+    for i, occ := range got {
+        if want := start.AddDate(0, 0, i); !occ.DueDate.Equal(want) || !occ.Virtual {
+            t.Fatalf("occurrence %d is %+v, want one due %v", i, occ, want)
+        }
+    }
+
+    // The cap still bounds what a single period expands to.
+    if got := occurrences(daily, monday, monday.AddDate(0, 0, 3*maxOccurrences)); len(got) != maxOccurrences {
+        t.Fatalf("got %d occurrences, want the cap of %d", len(got), maxOccurrences)
+    }
+}
+
+func TestOccurrencesSkipDoneTodos(t *testing.T) {
+    done := newTestTodo("done", monday)
+    done.Recurrence = "FREQ=DAILY"
+    done.Status = models.StatusCompleted
+    if got := occurrences(done, monday, monday.AddDate(0, 0, 7)); got != nil {
+        t.Fatalf("a completed todo has occurrences %+v", got)
+    }
+}
@@ -0,0 +1,115 @@
+package service
+
+import (
+    "context"
+    "errors"
+    "path/filepath"
+    "testing"
+    "time"
+
+    "github.com/read-my-name/restful_todo_app/internal/storage"
+    "github.com/read-my-name/restful_todo_app/pkg/models"
+)
+
+// newSharedService opens a service on its own CSVStorage of path, as a
+// second server or process would.
+func newSharedService(t *testing.T, path string) *TodoService {
+    t.Helper()
+    store := storage.NewCSVStorage(path)
+    svc := NewTodoService(store)
+    t.Cleanup(func() {
+        svc.Close(context.Background())
+        store.Close()
+    })
+    if _, err := svc.LoadInitialData(); err != nil {
+        t.Fatal(err)
+    }
+    return svc
+}
+
+func titles(todos []models.Todo) map[string]bool {
+    seen := make(map[string]bool, len(todos))
+    for _, todo := range todos {
+        seen[todo.Title] = true
+    }
+    return seen
+}
+
+func TestServicesShareCSVFile(t *testing.T) {
+    path := filepath.Join(t.TempDir(), "todos.csv")
+    a := newSharedService(t, path)
+    b := newSharedService(t, path)
+    ctx := context.Background()
+    sync := WriteOptions{Sync: true}
+    due := time.Now().Add(48 * time.Hour)
+
+    added, err := a.AddTodo(ctx, newTestTodo("from a", due), sync)
+    if err != nil {
+        t.Fatal(err)
+    }
+    got, err := b.GetTodo(added.ID)
+    if err != nil {
+        t.Fatalf("a todo added by another service is not found: %v", err)
+    }
+    if got.Title != "from a" {
+        t.Fatalf("got %+v", got)
+    }
+    results, err := b.Search("from", models.ListOptions{})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if results.Total != 1 {
+        t.Errorf("search does not find a todo added by another service: %+v", results)
+    }
+
+    renamed := got
+    renamed.Title = "renamed by b"
+    if _, err := b.UpdateTodo(ctx, added.ID, renamed, sync); err != nil {
+        t.Fatalf("updating a todo added by another service: %v", err)
+    }
+    if got, _ := a.GetTodo(added.ID); got.Title != "renamed by b" || got.Version != 2 {
+        t.Fatalf("the other service's update is not seen: %+v", got)
+    }
+    stale := WriteOptions{Sync: true, IfMatch: []int{1}}
+    if _, err := a.UpdateTodo(ctx, added.ID, renamed, stale); !errors.Is(err, ErrPreconditionFailed) {
+        t.Fatalf("a write based on the version before the other service's update got %v", err)
+    }
+
+    if err := a.DeleteTodo(ctx, added.ID, sync); err != nil {
+        t.Fatal(err)
+    }
+    if _, err := b.GetTodo(added.ID); !errors.Is(err, ErrNotFound) {
+        t.Fatalf("a todo deleted by another service is still found: %v", err)
+    }
+}
+
+func TestServiceRefreshKeepsQueuedWrites(t *testing.T) {
+    path := filepath.Join(t.TempDir(), "todos.csv")
+    a := newSharedService(t, path)
+    b := newSharedService(t, path)
+    ctx := context.Background()
+    due := time.Now().Add(48 * time.Hour)
+
+    // Possibly still queued for storage when b's write is noticed.
+    if _, err := a.AddTodo(ctx, newTestTodo("queued in a", due), WriteOptions{}); err != nil {
+        t.Fatal(err)
+    }
+    if _, err := b.AddTodo(ctx, newTestTodo("from b", due), WriteOptions{Sync: true}); err != nil {
+        t.Fatal(err)
+    }
+
+    todos, err := a.GetTodos(models.TodoFilter{})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if seen := titles(todos); len(todos) != 2 || !seen["queued in a"] || !seen["from b"] {
+        t.Fatalf("a lists %+v", todos)
+    }
+    todos, err = b.GetTodos(models.TodoFilter{})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(todos) != 2 {
+        t.Fatalf("b lists %+v", todos)
+    }
+}
@@ -0,0 +1,306 @@
+package service
+
+import (
+    "container/heap"
+    "context"
+    "fmt"
+    "log/slog"
+    "slices"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+
+    "github.com/read-my-name/restful_todo_app/pkg/models"
+)
+
+// Notifier delivers reminders. A failed delivery is retried according to
+// the RetryPolicy, so Notify should only fail when trying again may help.
+type Notifier interface {
+    // Name tells notifiers apart in the ReminderLog and in logs.
+    Name() string
+    Notify(ctx context.Context, r models.Reminder) error
+}
+
+// ReminderLog remembers which reminders have been delivered, so that a
+// restart does not send them again.
+type ReminderLog interface {
+    Sent(key string) bool
+    MarkSent(key string) error
+}
+
+// RetryPolicy controls how failed reminder deliveries are retried.
+type RetryPolicy struct {
+    Attempts int           // deliveries tried before giving up, at least 1
+    Backoff  time.Duration // wait before the second attempt, doubled for each one after
+}
+
+func DefaultRetryPolicy() RetryPolicy {
+    return RetryPolicy{Attempts: 5, Backoff: 30 * time.Second}
+}
+
+// notifyTimeout bounds a single delivery attempt.
+const notifyTimeout = 10 * time.Second
+
+// idleWait is how long the scheduler sleeps when nothing is scheduled; it
+// is woken early whenever a reminder is added.
+const idleWait = time.Hour
+
+// parseReminderOffset reads how long before the due date a reminder
+// fires: a Go duration such as "1h30m", optionally after a number of days,
+// as in "1d" or "2d12h".
+func parseReminderOffset(raw string) (time.Duration, error) {
+    s := strings.TrimSpace(raw)
+    var offset time.Duration
+    if days, rest, ok := strings.Cut(s, "d"); ok {
+        n, err := strconv.Atoi(days)
+        if err != nil || n < 0 {
+            return 0, fmt.Errorf("invalid reminder %q", raw)
+        }
+        offset, s = time.Duration(n)*24*time.Hour, rest
+    }
+    if s != "" {
+        d, err := time.ParseDuration(s)
+        if err != nil {
+            return 0, fmt.Errorf("invalid reminder %q", raw)
+        }
+        offset += d
+    }
+    if offset <= 0 {
+        return 0, fmt.Errorf("reminder %q must be before the due date", raw)
+    }
+    return offset, nil
+}
+
+func checkReminders(v *validator, t models.Todo) {
+    for i, offset := range t.Reminders {
+        if _, err := parseReminderOffset(offset); err != nil {
+            v.add(fmt.Sprintf("reminders[%d]", i), CodeInvalid)
+        }
+    }
+}
+
+// reminderJob is a pending delivery of one reminder through one notifier.
+type reminderJob struct {
+    at       time.Time // when to try next
+    fireAt   time.Time // when the reminder was due
+    key      string
+    todoID   string
+    due      time.Time
+    offset   string
+    notifier int
+    attempt  int
+}
+
+// reminderKey identifies a delivery in the ReminderLog. The due date is
+// part of it, so moving a todo arms its reminders again.
+func reminderKey(id string, due time.Time, offset, notifier string) string {
+    return strings.Join([]string{id, due.UTC().Format(time.RFC3339), offset, notifier}, "|")
+}
+
+// reminderDue returns the due date in a reminderKey. It is counted from
+// the end, as IDs of imported todos may contain "|".
+func reminderDue(key string) (time.Time, bool) {
+    parts := strings.Split(key, "|")
+    if len(parts) < 4 {
+        return time.Time{}, false
+    }
+    due, err := time.Parse(time.RFC3339, parts[len(parts)-3])
+    return due, err == nil
+}
+
+// reminderHeap is a min-heap of jobs by the time they are next tried.
+type reminderHeap []reminderJob
+
+func (h reminderHeap) Len() int           { return len(h) }
+func (h reminderHeap) Less(i, j int) bool { return h[i].at.Before(h[j].at) }
+func (h reminderHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+
+func (h *reminderHeap) Push(x any) {
+    *h = append(*h, x.(reminderJob))
+}
+
+func (h *reminderHeap) Pop() any {
+    old := *h
+    job := old[len(old)-1]
+    *h = old[:len(old)-1]
+    return job
+}
+
+// reminderScheduler holds the reminders waiting to be sent. Jobs are not
+// removed when their todo changes; they are checked against the todo when
+// they come up and dropped if they no longer apply.
+type reminderScheduler struct {
+    mu      sync.Mutex
+    jobs    reminderHeap
+    pending map[string]bool // keys of the jobs, so that rescheduling a todo does not repeat them
+    wake    chan struct{}
+}
+
+func newReminderScheduler() *reminderScheduler {
+    return &reminderScheduler{
+        pending: make(map[string]bool),
+        wake:    make(chan struct{}, 1),
+    }
+}
+
+// memoryReminderLog is the ReminderLog used when none is configured. It
+// forgets everything on restart, and each entry once its due date has
+// passed: reminders are only scheduled for todos that are still due, so
+// the entry can no longer be asked for. That bounds it by the reminders of
+// todos not yet due, deleted ones included.
+type memoryReminderLog struct {
+    mu   sync.Mutex
+    sent map[string]time.Time // key, then the due date it is for
+}
+
+func newMemoryReminderLog() *memoryReminderLog {
+    return &memoryReminderLog{sent: make(map[string]time.Time)}
+}
+
+func (l *memoryReminderLog) Sent(key string) bool {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+    _, ok := l.sent[key]
+    return ok
+}
+
+func (l *memoryReminderLog) MarkSent(key string) error {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+    now := time.Now()
+    for k, due := range l.sent {
+        if due.Before(now) {
+            delete(l.sent, k)
+        }
+    }
+    due, _ := reminderDue(key)
+    l.sent[key] = due
+    return nil
+}
+
+// scheduleReminders queues the reminders of t that have not been sent
+// yet. Reminders whose time has passed while the todo is still due fire
+// straight away. It is called with s.mu held.
+func (s *TodoService) scheduleReminders(t models.Todo) {
+    if len(s.notifiers) == 0 || len(t.Reminders) == 0 || isDone(t) {
+        return
+    }
+    now := time.Now()
+    if !t.DueDate.After(now) {
+        return
+    }
+
+    r := s.reminders
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    for _, offset := range t.Reminders {
+        d, err := parseReminderOffset(offset)
+        if err != nil {
+            continue
+        }
+        fireAt := t.DueDate.Add(-d)
+        for i, n := range s.notifiers {
+            key := reminderKey(t.ID, t.DueDate, offset, n.Name())
+            if r.pending[key] || s.reminderLog.Sent(key) {
+                continue
+            }
+            heap.Push(&r.jobs, reminderJob{
+                at:       fireAt,
+                fireAt:   fireAt,
+                key:      key,
+                todoID:   t.ID,
+                due:      t.DueDate,
+                offset:   offset,
+                notifier: i,
+                attempt:  1,
+            })
+            r.pending[key] = true
+        }
+    }
+    select {
+        case r.wake <- struct{}{}:
+        default:
+    }
+}
+
+// runReminders sends reminders as they come due until ctx is canceled.
+func (s *TodoService) runReminders(ctx context.Context) {
+    r := s.reminders
+    for {
+        r.mu.Lock()
+        wait := idleWait
+        if len(r.jobs) > 0 {
+            wait = time.Until(r.jobs[0].at)
+        }
+        r.mu.Unlock()
+
+        timer := time.NewTimer(max(wait, 0))
+        select {
+            case <-ctx.Done():
+                timer.Stop()
+                slog.Debug("Reminder scheduler context canceled, exiting")
+                return
+            case <-r.wake:
+            case <-timer.C:
+        }
+        timer.Stop()
+        s.sendDueReminders(ctx)
+    }
+}
+
+func (s *TodoService) sendDueReminders(ctx context.Context) {
+    r := s.reminders
+    for ctx.Err() == nil {
+        r.mu.Lock()
+        if len(r.jobs) == 0 || r.jobs[0].at.After(time.Now()) {
+            r.mu.Unlock()
+            return
+        }
+        job := heap.Pop(&r.jobs).(reminderJob)
+        r.mu.Unlock()
+
+        if retry := s.deliver(ctx, job); retry {
+            job.at = time.Now().Add(s.retry.Backoff << (job.attempt - 1))
+            job.attempt++
+            r.mu.Lock()
+            heap.Push(&r.jobs, job)
+            r.mu.Unlock()
+            continue
+        }
+        r.mu.Lock()
+        delete(r.pending, job.key)
+        r.mu.Unlock()
+    }
+}
+
+// deliver sends a reminder if it still applies to its todo, and reports
+// whether a failed delivery should be tried again.
+func (s *TodoService) deliver(ctx context.Context, job reminderJob) bool {
+    s.mu.RLock()
+    t, ok := s.todos[job.todoID]
+    s.mu.RUnlock()
+    if !ok || isDone(t) || !t.DueDate.Equal(job.due) || !slices.Contains(t.Reminders, job.offset) {
+        return false
+    }
+    if s.reminderLog.Sent(job.key) {
+        return false
+    }
+
+    notifier := s.notifiers[job.notifier]
+    attemptCtx, cancel := context.WithTimeout(ctx, notifyTimeout)
+    err := notifier.Notify(attemptCtx, models.Reminder{Todo: t, Offset: job.offset, FireAt: job.fireAt, Attempt: job.attempt})
+    cancel()
+    if err != nil {
+        if job.attempt < s.retry.Attempts && ctx.Err() == nil {
+            slog.Warn("Reminder delivery failed, will retry", "id", t.ID, "notifier", notifier.Name(), "attempt", job.attempt, "err", err)
+            return true
+        }
+        slog.Error("Giving up on reminder", "id", t.ID, "notifier", notifier.Name(), "attempts", job.attempt, "err", err)
+        return false
+    }
+    if err := s.reminderLog.MarkSent(job.key); err != nil {
+        slog.Warn("Failed to record sent reminder", "id", t.ID, "notifier", notifier.Name(), "err", err)
+    }
+    return false
+}
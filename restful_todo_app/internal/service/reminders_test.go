@@ -0,0 +1,229 @@
+package service
+
+import (
+    "container/heap"
+    "context"
+    "errors"
+    "path/filepath"
+    "sync"
+    "testing"
+    "time"
+
+    "github.com/read-my-name/restful_todo_app/internal/storage"
+    "github.com/read-my-name/restful_todo_app/pkg/models"
+)
+
+// recordingNotifier records every delivery attempt, failing the first
+// failures of them.
+type recordingNotifier struct {
+    mu       sync.Mutex
+    failures int
+    attempts []models.Reminder
+}
+
+func (n *recordingNotifier) Name() string { return "recording" }
+
+func (n *recordingNotifier) Notify(ctx context.Context, r models.Reminder) error {
+    n.mu.Lock()
+    defer n.mu.Unlock()
+    n.attempts = append(n.attempts, r)
+    if len(n.attempts) <= n.failures {
+        return errors.New("delivery failed")
+    }
+    return nil
+}
+
+// waitAttempts waits up to a second for n attempts, then a little longer
+// to catch any that should not come, and returns them all.
+func (n *recordingNotifier) waitAttempts(count int) []models.Reminder {
+    deadline := time.Now().Add(time.Second)
+    for {
+        n.mu.Lock()
+        got := len(n.attempts)
+        n.mu.Unlock()
+        if got >= count || time.Now().After(deadline) {
+            break
+        }
+        time.Sleep(5 * time.Millisecond)
+    }
+    time.Sleep(100 * time.Millisecond)
+    n.mu.Lock()
+    defer n.mu.Unlock()
+    return append([]models.Reminder(nil), n.attempts...)
+}
+
+// testRetry retries quickly enough for tests.
+var testRetry = WithRetryPolicy(RetryPolicy{Attempts: 3, Backoff: 10 * time.Millisecond})
+
+// remindedTodo is due in an hour with a reminder an hour ahead, which
+// fires straight away.
+func remindedTodo(title string) models.Todo {
+    todo := newTestTodo(title, time.Now().Add(time.Hour))
+    todo.Reminders = []string{"1h"}
+    return todo
+}
+
+func TestReminderHeapOrder(t *testing.T) {
+    base := time.Now()
+    var h reminderHeap
+    for _, minutes := range []int{5, 1, 4, 2, 3} {
+        heap.Push(&h, reminderJob{at: base.Add(time.Duration(minutes) * time.Minute)})
+    }
+    for want := 1; want <= 5; want++ {
+        job := heap.Pop(&h).(reminderJob)
+        if got := job.at.Sub(base); got != time.Duration(want)*time.Minute {
+            t.Fatalf("popped the job at %s, want %dm", got, want)
+        }
+    }
+}
+
+func TestReminderRetries(t *testing.T) {
+    ctx := context.Background()
+
+    // Fails every time: tried as often as the policy allows.
+    failing := &recordingNotifier{failures: 100}
+    svc := newTestService(t, WithNotifiers(nil, failing), testRetry)
+    if _, err := svc.AddTodo(ctx, remindedTodo("always failing"), WriteOptions{}); err != nil {
+        t.Fatal(err)
+    }
+    attempts := failing.waitAttempts(3)
+    if len(attempts) != 3 {
+        t.Fatalf("tried %d times, want 3", len(attempts))
+    }
+    for i, r := range attempts {
+        if r.Attempt != i+1 || r.Offset != "1h" || r.Todo.Title != "always failing" {
+            t.Errorf("attempt %d was %+v", i+1, r)
+        }
+    }
+    if !attempts[2].FireAt.Equal(attempts[0].FireAt) {
+        t.Error("retries changed when the reminder was due")
+    }
+
+    // Fails once: delivered on the second attempt, and recorded.
+    flaky := &recordingNotifier{failures: 1}
+    log := newMemoryReminderLog()
+    svc = newTestService(t, WithNotifiers(log, flaky), testRetry)
+    todo, err := svc.AddTodo(ctx, remindedTodo("flaky"), WriteOptions{})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if attempts := flaky.waitAttempts(2); len(attempts) != 2 {
+        t.Fatalf("tried %d times, want 2", len(attempts))
+    }
+    if !log.Sent(reminderKey(todo.ID, todo.DueDate, "1h", flaky.Name())) {
+        t.Fatal("the delivered reminder was not recorded")
+    }
+}
+
+func TestReminderNotResentAfterRestart(t *testing.T) {
+    ctx := context.Background()
+    store := &filterStorage{}
+    logPath := filepath.Join(t.TempDir(), "reminders.json")
+    start := func(n *recordingNotifier) *TodoService {
+        log, err := storage.OpenReminderLog(logPath)
+        if err != nil {
+            t.Fatal(err)
+        }
+        svc := NewTodoService(store, WithNotifiers(log, n), testRetry)
+        t.Cleanup(func() { svc.Close(ctx) })
+        if _, err := svc.LoadInitialData(); err != nil {
+            t.Fatal(err)
+        }
+        return svc
+    }
+
+    first := &recordingNotifier{}
+    svc := start(first)
+    if _, err := svc.AddTodo(ctx, remindedTodo("pay rent"), WriteOptions{Sync: true}); err != nil {
+        t.Fatal(err)
+    }
+    if attempts := first.waitAttempts(1); len(attempts) != 1 {
+        t.Fatalf("sent %d times, want once", len(attempts))
+    }
+    if err := svc.Close(ctx); err != nil {
+        t.Fatal(err)
+    }
+
+    second := &recordingNotifier{}
+    start(second)
+    if attempts := second.waitAttempts(1); len(attempts) != 0 {
+        t.Fatalf("sent again after a restart: %+v", attempts)
+    }
+
+    // Without the log the restarted service sends it again.
+    third := &recordingNotifier{}
+    svc = NewTodoService(store, WithNotifiers(nil, third), testRetry)
+    t.Cleanup(func() { svc.Close(ctx) })
+    if _, err := svc.LoadInitialData(); err != nil {
+        t.Fatal(err)
+    }
+    if attempts := third.waitAttempts(1); len(attempts) != 1 {
+        t.Fatalf("sent %d times without a log, want once", len(attempts))
+    }
+}
+
+func TestReminderFollowsTodoChanges(t *testing.T) {
+    ctx := context.Background()
+    n := &recordingNotifier{}
+    svc := newTestService(t, WithNotifiers(nil, n), testRetry)
+
+    // Both fire 200ms from now, unless their todos change first.
+    fire := time.Now().Add(200 * time.Millisecond)
+    moved := newTestTodo("moved", fire.Add(time.Hour))
+    moved.Reminders = []string{"1h"}
+    deleted := moved
+    deleted.Title = "deleted"
+    kept := moved
+    kept.Title = "kept"
+    var err error
+    for _, todo := range []*models.Todo{&moved, &deleted, &kept} {
+        if *todo, err = svc.AddTodo(ctx, *todo, WriteOptions{}); err != nil {
+            t.Fatal(err)
+        }
+    }
+
+    moved.DueDate = moved.DueDate.Add(24 * time.Hour)
+    if _, err := svc.UpdateTodo(ctx, moved.ID, moved, WriteOptions{}); err != nil {
+        t.Fatal(err)
+    }
+    if err := svc.DeleteTodo(ctx, deleted.ID, WriteOptions{}); err != nil {
+        t.Fatal(err)
+    }
+
+    time.Sleep(time.Until(fire))
+    attempts := n.waitAttempts(1)
+    if len(attempts) != 1 || attempts[0].Todo.ID != kept.ID {
+        t.Fatalf("sent %+v, want only the reminder of the unchanged todo", attempts)
+    }
+}
+
+func TestMemoryReminderLogForgetsPastDueDates(t *testing.T) {
+    log := newMemoryReminderLog()
+    now := time.Now()
+    past := reminderKey("a|imported", now.Add(-time.Minute), "1h", "smtp")
+    future := reminderKey("b", now.Add(time.Hour), "1h", "smtp")
+
+    for _, key := range []string{past, future} {
+        if err := log.MarkSent(key); err != nil {
+            t.Fatal(err)
+        }
+    }
+    if !log.Sent(future) {
+        t.Fatal("a reminder for a todo still due was forgotten")
+    }
+
+    // The next delivery drops the reminder whose todo is past due.
+    later := reminderKey("c", now.Add(2*time.Hour), "1h", "webhook")
+    if err := log.MarkSent(later); err != nil {
+        t.Fatal(err)
+    }
+    if log.Sent(past) {
+        t.Error("a reminder for a todo past due is still remembered")
+    }
+    if !log.Sent(future) || !log.Sent(later) {
+        t.Error("a reminder for a todo still due was forgotten")
+    }
+    if len(log.sent) != 2 {
+        t.Errorf("the log holds %d entries, want 2", len(log.sent))
+    }
+}
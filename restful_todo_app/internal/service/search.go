@@ -0,0 +1,167 @@
+package service
+
+import (
+    "fmt"
+    "math"
+    "sort"
+    "strings"
+    "unicode"
+
+    "github.com/read-my-name/restful_todo_app/pkg/models"
+)
+
+// queryClause is one condition of a search query. Every result meets
+// all of them.
+type queryClause struct {
+    fields []searchField // searched fields, all of them when unqualified
+    terms  []string      // a phrase when there is more than one
+    prefix bool          // the last term also matches longer words
+}
+
+type searchQuery struct {
+    clauses []queryClause
+    filter  models.TodoFilter // status: and priority: qualifiers
+}
+
+var allSearchFields = []searchField{fieldTitle, fieldDescription, fieldLabel, fieldSubtask}
+
+// parseQuery reads a search query: words, "quoted phrases" and words
+// ending in * that match any word they start, each optionally qualified
+// by a field as in title:report or label:"code review". status: and
+// priority: qualifiers filter on those values instead, several of the
+// same one matching any of them. Anything before a colon that is not a
+// known qualifier is searched as text.
+func parseQuery(raw string) (searchQuery, error) {
+    var q searchQuery
+    rest := raw
+    for {
+        rest = strings.TrimLeftFunc(rest, unicode.IsSpace)
+        if rest == "" {
+            break
+        }
+
+        qualifier := ""
+        if i := strings.IndexByte(rest, ':'); i > 0 && !strings.ContainsFunc(rest[:i], isQueryBreak) {
+            name := strings.ToLower(rest[:i])
+            if _, ok := searchFields[name]; ok || name == "status" || name == "priority" {
+                qualifier, rest = name, rest[i+1:]
+            }
+        }
+
+        var value string
+        if strings.HasPrefix(rest, `"`) {
+            end := strings.IndexByte(rest[1:], '"')
+            if end < 0 {
+                return searchQuery{}, fmt.Errorf("%w: unclosed quote in search query", ErrInvalidFilter)
+            }
+            value, rest = rest[1:end+1], rest[end+2:]
+        } else {
+            end := strings.IndexFunc(rest, unicode.IsSpace)
+            if end < 0 {
+                end = len(rest)
+            }
+            value, rest = rest[:end], rest[end:]
+        }
+
+        if err := q.add(qualifier, value); err != nil {
+            return searchQuery{}, err
+        }
+    }
+    if len(q.clauses) == 0 && len(q.filter.Statuses) == 0 && len(q.filter.Priorities) == 0 {
+        return searchQuery{}, fmt.Errorf("%w: empty search query", ErrInvalidFilter)
+    }
+    return q, validateFilter(q.filter)
+}
+
+func isQueryBreak(r rune) bool {
+    return unicode.IsSpace(r) || r == '"'
+}
+
+func (q *searchQuery) add(qualifier, value string) error {
+    switch qualifier {
+        case "status":
+            q.filter.Statuses = append(q.filter.Statuses, models.Status(strings.ToLower(value)))
+            return nil
+        case "priority":
+            q.filter.Priorities = append(q.filter.Priorities, models.Priority(strings.ToLower(value)))
+            return nil
+    }
+
+    c := queryClause{fields: allSearchFields, terms: tokenize(value), prefix: strings.HasSuffix(value, "*")}
+    if qualifier != "" {
+        c.fields = []searchField{searchFields[qualifier]}
+    }
+    if len(c.terms) == 0 {
+        if qualifier != "" {
+            return fmt.Errorf("%w: nothing to search for in %s:", ErrInvalidFilter, qualifier)
+        }
+        // Punctuation on its own matches nothing worth finding.
+        return nil
+    }
+    q.clauses = append(q.clauses, c)
+    return nil
+}
+
+// Search returns one page of the todos matching query, the best matches
+// first, or ordered by opts.Sort when it is set. Words are matched in the
+// title, description, labels and subtask titles; see parseQuery for the
+// syntax. Search results cannot be paged with a cursor.
+func (s *TodoService) Search(query string, opts models.ListOptions) (models.SearchResults, error) {
+    q, err := parseQuery(query)
+    if err != nil {
+        return models.SearchResults{}, err
+    }
+    opts, err = checkPaging(opts)
+    if err != nil {
+        return models.SearchResults{}, err
+    }
+    if opts.Cursor != "" {
+        return models.SearchResults{}, fmt.Errorf("%w: search results are paged with offset, not cursor", ErrInvalidFilter)
+    }
+    var fields []sortField
+    if opts.Sort != "" {
+        if fields, err = parseSort(opts.Sort); err != nil {
+            return models.SearchResults{}, err
+        }
+    }
+
+    if err := s.refresh(); err != nil {
+        return models.SearchResults{}, err
+    }
+    hits := []models.SearchHit{}
+    s.mu.RLock()
+    if len(q.clauses) == 0 {
+        for _, id := range s.order {
+            if t := s.todos[id]; matchesFilter(t, q.filter) {
+                hits = append(hits, models.SearchHit{Todo: t})
+            }
+        }
+    } else {
+        for id, score := range s.index.search(q.clauses) {
+            if t := s.todos[id]; matchesFilter(t, q.filter) {
+                hits = append(hits, models.SearchHit{Todo: t, Score: math.Round(score*1000) / 1000})
+            }
+        }
+    }
+    s.mu.RUnlock()
+
+    sort.Slice(hits, func(i, j int) bool {
+        a, b := hits[i], hits[j]
+        if fields == nil && a.Score != b.Score {
+            return a.Score > b.Score
+        }
+        if fields == nil && !a.Todo.UpdatedAt.Equal(b.Todo.UpdatedAt) {
+            return a.Todo.UpdatedAt.After(b.Todo.UpdatedAt)
+        }
+        return less(fields, a.Todo, b.Todo)
+    })
+
+    start := min(opts.Offset, len(hits))
+    end := min(start+opts.Limit, len(hits))
+    return models.SearchResults{
+        Items:  hits[start:end],
+        Total:  len(hits),
+        Limit:  opts.Limit,
+        Offset: start,
+    }, nil
+}
@@ -0,0 +1,285 @@
+package service
+
+import (
+    "math"
+    "sort"
+    "strings"
+    "unicode"
+
+    "github.com/read-my-name/restful_todo_app/pkg/models"
+)
+
+// searchField is a text field of a todo covered by the search index.
+type searchField int
+
+const (
+    fieldTitle searchField = iota
+    fieldDescription
+    fieldLabel
+    fieldSubtask
+    numSearchFields
+)
+
+// searchFields maps the qualifiers of a search query to indexed fields.
+var searchFields = map[string]searchField{
+    "title":       fieldTitle,
+    "description": fieldDescription,
+    "label":       fieldLabel,
+    "subtask":     fieldSubtask,
+}
+
+// fieldBoosts weight a match by the field it is in: a word in the title
+// says more about a todo than one in its description.
+var fieldBoosts = [numSearchFields]float64{
+    fieldTitle:       3,
+    fieldDescription: 1,
+    fieldLabel:       2,
+    fieldSubtask:     1,
+}
+
+// BM25 parameters: how quickly repeated terms stop adding to the score,
+// and how much long fields are penalised.
+const (
+    bm25K1 = 1.2
+    bm25B  = 0.75
+)
+
+// tokenize splits text into lower-case words of letters and digits.
+func tokenize(text string) []string {
+    return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+        return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+    })
+}
+
+// posting holds the positions of a term in each field of one todo.
+type posting [numSearchFields][]int
+
+type indexedTodo struct {
+    terms   []string // distinct terms, to find the postings on removal
+    lengths [numSearchFields]int
+}
+
+// searchIndex is an inverted index from the words of todos to where they
+// occur. It is not safe for concurrent use; TodoService guards it with mu.
+type searchIndex struct {
+    postings map[string]map[string]*posting // term, then todo ID
+    terms    []string                       // sorted, for prefix matching
+    todos    map[string]indexedTodo
+    totals   [numSearchFields]int // summed field lengths, for averages
+}
+
+func newSearchIndex() *searchIndex {
+    return &searchIndex{
+        postings: make(map[string]map[string]*posting),
+        todos:    make(map[string]indexedTodo),
+    }
+}
+
+// add indexes t, replacing any earlier version of it.
+func (ix *searchIndex) add(t models.Todo) {
+    ix.remove(t.ID)
+
+    var doc indexedTodo
+    var next [numSearchFields]int // position of the next word in each field
+    index := func(field searchField, text string) {
+        for _, term := range tokenize(text) {
+            byTodo, ok := ix.postings[term]
+            if !ok {
+                byTodo = make(map[string]*posting)
+                ix.postings[term] = byTodo
+                ix.insertTerm(term)
+            }
+            p, ok := byTodo[t.ID]
+            if !ok {
+                p = &posting{}
+                byTodo[t.ID] = p
+                doc.terms = append(doc.terms, term)
+            }
+            p[field] = append(p[field], next[field])
+            next[field]++
+            doc.lengths[field]++
+        }
+    }
+
+    index(fieldTitle, t.Title)
+    index(fieldDescription, t.Description)
+    for _, label := range t.Labels {
+        index(fieldLabel, label)
+        // Leave a gap so that phrases do not run from one value into the next.
+        next[fieldLabel]++
+    }
+    for _, st := range t.Subtasks {
+        index(fieldSubtask, st.Title)
+        next[fieldSubtask]++
+    }
+
+    for field, length := range doc.lengths {
+        ix.totals[field] += length
+    }
+    ix.todos[t.ID] = doc
+}
+
+// remove drops the todo with the given ID from the index.
+func (ix *searchIndex) remove(id string) {
+    doc, ok := ix.todos[id]
+    if !ok {
+        return
+    }
+    for _, term := range doc.terms {
+        byTodo := ix.postings[term]
+        delete(byTodo, id)
+        if len(byTodo) == 0 {
+            delete(ix.postings, term)
+            ix.deleteTerm(term)
+        }
+    }
+    for field, length := range doc.lengths {
+        ix.totals[field] -= length
+    }
+    delete(ix.todos, id)
+}
+
+func (ix *searchIndex) insertTerm(term string) {
+    i := sort.SearchStrings(ix.terms, term)
+    ix.terms = append(ix.terms, "")
+    copy(ix.terms[i+1:], ix.terms[i:])
+    ix.terms[i] = term
+}
+
+func (ix *searchIndex) deleteTerm(term string) {
+    i := sort.SearchStrings(ix.terms, term)
+    if i < len(ix.terms) && ix.terms[i] == term {
+        ix.terms = append(ix.terms[:i], ix.terms[i+1:]...)
+    }
+}
+
+// expand returns the indexed terms starting with prefix.
+func (ix *searchIndex) expand(prefix string) []string {
+    var terms []string
+    for i := sort.SearchStrings(ix.terms, prefix); i < len(ix.terms) && strings.HasPrefix(ix.terms[i], prefix); i++ {
+        terms = append(terms, ix.terms[i])
+    }
+    return terms
+}
+
+// idf is the inverse document frequency of a term found in n todos.
+func (ix *searchIndex) idf(n int) float64 {
+    total := float64(len(ix.todos))
+    return math.Log(1 + (total-float64(n)+0.5)/(float64(n)+0.5))
+}
+
+// weight scores tf occurrences in one field of a todo with BM25, shorter
+// fields counting for more.
+func (ix *searchIndex) weight(id string, field searchField, tf int) float64 {
+    norm := 1.0
+    if ix.totals[field] > 0 {
+        average := float64(ix.totals[field]) / float64(len(ix.todos))
+        norm = 1 - bm25B + bm25B*float64(ix.todos[id].lengths[field])/average
+    }
+    f := float64(tf)
+    return fieldBoosts[field] * f * (bm25K1 + 1) / (f + bm25K1*norm)
+}
+
+// search returns the todos matching every clause with the sum of their
+// scores.
+func (ix *searchIndex) search(clauses []queryClause) map[string]float64 {
+    var scores map[string]float64
+    for _, c := range clauses {
+        matches := ix.match(c)
+        if scores == nil {
+            scores = matches
+            continue
+        }
+        for id, score := range scores {
+            if m, ok := matches[id]; ok {
+                scores[id] = score + m
+            } else {
+                delete(scores, id)
+            }
+        }
+        if len(scores) == 0 {
+            break
+        }
+    }
+    return scores
+}
+
+// match scores the todos matching a single clause.
+func (ix *searchIndex) match(c queryClause) map[string]float64 {
+    // The words that may stand at each position of the clause.
+    options := make([][]string, len(c.terms))
+    for i, term := range c.terms {
+        options[i] = []string{term}
+        if c.prefix && i == len(c.terms)-1 {
+            options[i] = ix.expand(term)
+        }
+    }
+
+    scores := make(map[string]float64)
+    if len(c.terms) == 1 {
+        for _, term := range options[0] {
+            idf := ix.idf(len(ix.postings[term]))
+            for id, p := range ix.postings[term] {
+                for _, field := range c.fields {
+                    if tf := len(p[field]); tf > 0 {
+                        scores[id] += idf * ix.weight(id, field, tf)
+                    }
+                }
+            }
+        }
+        return scores
+    }
+
+    // A phrase is as rare as its words together; a prefix counts as its
+    // most common completion.
+    idf := 0.0
+    for _, terms := range options {
+        if len(terms) == 0 {
+            return scores
+        }
+        n := 0
+        for _, term := range terms {
+            n = max(n, len(ix.postings[term]))
+        }
+        idf += ix.idf(n)
+    }
+    for id, p := range ix.postings[c.terms[0]] {
+        for _, field := range c.fields {
+            tf := 0
+            for _, start := range p[field] {
+                if ix.phraseAt(id, field, options[1:], start+1) {
+                    tf++
+                }
+            }
+            if tf > 0 {
+                scores[id] += idf * ix.weight(id, field, tf)
+            }
+        }
+    }
+    return scores
+}
+
+// phraseAt reports whether the words of a phrase follow each other from
+// pos onwards, any one of the options standing at each position.
+func (ix *searchIndex) phraseAt(id string, field searchField, options [][]string, pos int) bool {
+    for i, terms := range options {
+        if !ix.occursAt(id, field, terms, pos+i) {
+            return false
+        }
+    }
+    return true
+}
+
+func (ix *searchIndex) occursAt(id string, field searchField, terms []string, pos int) bool {
+    for _, term := range terms {
+        p := ix.postings[term][id]
+        if p == nil {
+            continue
+        }
+        positions := p[field]
+        if i := sort.SearchInts(positions, pos); i < len(positions) && positions[i] == pos {
+            return true
+        }
+    }
+    return false
+}
@@ -0,0 +1,170 @@
+package service
+
+import (
+    "context"
+    "errors"
+    "reflect"
+    "sort"
+    "testing"
+    "time"
+
+    "github.com/read-my-name/restful_todo_app/pkg/models"
+)
+
+// newSearchService returns a service holding todos to search, by title.
+func newSearchService(t *testing.T) (*TodoService, map[string]models.Todo) {
+    t.Helper()
+    svc := newTestService(t)
+    due := time.Now().Add(24 * time.Hour)
+    seed := []struct {
+        title, description string
+        status             models.Status
+        priority           models.Priority
+        labels             []string
+    }{
+        {"Quarterly report", "numbers for finance", models.StatusNotStarted, models.PriorityLow, nil},
+        {"Call mom", "ask her about the report", models.StatusNotStarted, models.PriorityLow, nil},
+        {"Code review", "review the code of the parser", models.StatusNotStarted, models.PriorityMedium, []string{"backend"}},
+        {"Review code style", "", models.StatusInProgress, models.PriorityHigh, nil},
+        {"Reporting dashboard", "", models.StatusOnHold, models.PriorityHigh, []string{"frontend"}},
+    }
+    byTitle := make(map[string]models.Todo)
+    for _, s := range seed {
+        todo := newTestTodo(s.title, due)
+        todo.Description = s.description
+        todo.Status = s.status
+        todo.Priority = s.priority
+        todo.Labels = s.labels
+        added, err := svc.AddTodo(context.Background(), todo, WriteOptions{})
+        if err != nil {
+            t.Fatal(err)
+        }
+        byTitle[s.title] = added
+    }
+    return svc, byTitle
+}
+
+// searchTitles returns the titles of the todos matching query, best first.
+func searchTitles(t *testing.T, svc *TodoService, query string) []string {
+    t.Helper()
+    results, err := svc.Search(query, models.ListOptions{})
+    if err != nil {
+        t.Fatalf("%s: %v", query, err)
+    }
+    titles := []string{}
+    for _, hit := range results.Items {
+        titles = append(titles, hit.Todo.Title)
+    }
+    if results.Total != len(titles) {
+        t.Fatalf("%s: total %d for %d hits", query, results.Total, len(titles))
+    }
+    return titles
+}
+
+func TestSearch(t *testing.T) {
+    svc, _ := newSearchService(t)
+    tests := []struct {
+        query   string
+        want    []string
+        ordered bool // whether the order of want is the ranking
+    }{
+        // A word in the title ranks above the same word in the description.
+        {"report", []string{"Quarterly report", "Call mom"}, true},
+        {"REPORT", []string{"Quarterly report", "Call mom"}, true},
+        // A phrase only matches its words in order.
+        {`"code review"`, []string{"Code review"}, false},
+        {`"review code"`, []string{"Review code style"}, false},
+        {"code review", []string{"Code review", "Review code style"}, false},
+        // Words of different clauses must all match, in any field.
+        {"code parser", []string{"Code review"}, false},
+        {"review finance", []string{}, false},
+        // A prefix matches the words it starts.
+        {"repo*", []string{"Quarterly report", "Reporting dashboard", "Call mom"}, false},
+        {"report*", []string{"Quarterly report", "Reporting dashboard", "Call mom"}, false},
+        {`"code rev*"`, []string{"Code review"}, false},
+        {"xyz*", []string{}, false},
+        // Fields.
+        {"title:report", []string{"Quarterly report"}, false},
+        {"description:report", []string{"Call mom"}, false},
+        {"label:backend", []string{"Code review"}, false},
+        {"title:parser", []string{}, false},
+        // Qualifiers filter, repeated ones matching any of their values.
+        {"review status:in_progress", []string{"Review code style"}, false},
+        {"review status:in_progress status:not_started", []string{"Code review", "Review code style"}, false},
+        {"priority:high", []string{"Review code style", "Reporting dashboard"}, false},
+        {"priority:high priority:medium status:on_hold", []string{"Reporting dashboard"}, false},
+        {"priority:low priority:medium repo*", []string{"Quarterly report", "Call mom"}, false},
+        // An unknown qualifier is searched as text, here the phrase "for
+        // finance".
+        {"for:finance", []string{"Quarterly report"}, false},
+        {"finance:for", []string{}, false},
+    }
+    for _, tt := range tests {
+        got := searchTitles(t, svc, tt.query)
+        if !tt.ordered {
+            sort.Strings(got)
+            sort.Strings(tt.want)
+        }
+        if !reflect.DeepEqual(got, tt.want) {
+            t.Errorf("%s: got %q, want %q", tt.query, got, tt.want)
+        }
+    }
+}
+
+func TestSearchRanksTitleOverDescription(t *testing.T) {
+    svc, _ := newSearchService(t)
+    results, err := svc.Search("report", models.ListOptions{})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(results.Items) != 2 || results.Items[0].Score <= results.Items[1].Score {
+        t.Fatalf("got hits %+v", results.Items)
+    }
+}
+
+func TestSearchInvalidQueries(t *testing.T) {
+    svc, _ := newSearchService(t)
+    for _, query := range []string{"", "   ", `"unclosed`, "status:bogus", "priority:urgent", "title:", "title:..."} {
+        if _, err := svc.Search(query, models.ListOptions{}); !errors.Is(err, ErrInvalidFilter) {
+            t.Errorf("%q: got %v, want an invalid filter", query, err)
+        }
+    }
+}
+
+func TestSearchIndexFollowsWrites(t *testing.T) {
+    svc, byTitle := newSearchService(t)
+    ctx := context.Background()
+
+    report := byTitle["Quarterly report"]
+    report.Title = "Quarterly budget"
+    if _, err := svc.UpdateTodo(ctx, report.ID, report, WriteOptions{}); err != nil {
+        t.Fatal(err)
+    }
+    if got := searchTitles(t, svc, "title:report"); len(got) != 0 {
+        t.Errorf("the old title still matches: %q", got)
+    }
+    if got := searchTitles(t, svc, "budget"); !reflect.DeepEqual(got, []string{"Quarterly budget"}) {
+        t.Errorf("the new title does not match: %q", got)
+    }
+
+    if err := svc.DeleteTodo(ctx, byTitle["Call mom"].ID, WriteOptions{}); err != nil {
+        t.Fatal(err)
+    }
+    for _, query := range []string{"mom", "mo*", `"about the report"`, "report"} {
+        if got := searchTitles(t, svc, query); len(got) != 0 {
+            t.Errorf("%s: a deleted todo still matches: %q", query, got)
+        }
+    }
+
+    // Terms no todo uses any more are dropped from the index.
+    svc.mu.RLock()
+    defer svc.mu.RUnlock()
+    for _, term := range []string{"mom", "her", "report"} {
+        if _, ok := svc.index.postings[term]; ok {
+            t.Errorf("%q is still indexed", term)
+        }
+        if i := sort.SearchStrings(svc.index.terms, term); i < len(svc.index.terms) && svc.index.terms[i] == term {
+            t.Errorf("%q is still offered for prefixes", term)
+        }
+    }
+}
@@ -0,0 +1,130 @@
+package service
+
+import (
+    "context"
+    "fmt"
+    "time"
+
+    "github.com/google/uuid"
+    "github.com/read-my-name/restful_todo_app/pkg/models"
+)
+
+// prepareSubtasks assigns server-side IDs and timestamps to subtasks that
+// arrive without them.
+func prepareSubtasks(subtasks []models.Subtask, now time.Time) []models.Subtask {
+    for i := range subtasks {
+        if subtasks[i].ID == "" {
+            subtasks[i].ID = uuid.New().String()
+        }
+        if subtasks[i].CreatedAt.IsZero() {
+            subtasks[i].CreatedAt = now
+        }
+        if subtasks[i].UpdatedAt.IsZero() {
+            subtasks[i].UpdatedAt = now
+        }
+    }
+    return subtasks
+}
+
+func findSubtask(subtasks []models.Subtask, subID string) (int, error) {
+    for i, st := range subtasks {
+        if st.ID == subID {
+            return i, nil
+        }
+    }
+    return -1, fmt.Errorf("subtask with ID %s %w", subID, ErrNotFound)
+}
+
+// modifySubtasks runs change against a private copy of the todo's subtasks
+// and, when auto-complete is enabled, completes the todo once all of its
+// subtasks are done.
+func (s *TodoService) modifySubtasks(ctx context.Context, id string, opts WriteOptions, change func(subtasks []models.Subtask, now time.Time) ([]models.Subtask, error)) (models.Todo, error) {
+    return s.modify(ctx, id, opts, func(existing models.Todo) (models.Todo, error) {
+        subtasks := append([]models.Subtask(nil), existing.Subtasks...)
+        subtasks, err := change(subtasks, time.Now().UTC())
+        if err != nil {
+            return models.Todo{}, err
+        }
+        existing.Subtasks = subtasks
+
+        progress := existing.Progress()
+        if s.autoComplete && progress.Total > 0 && progress.Completed == progress.Total &&
+            s.workflow.Allows(existing.Status, models.StatusCompleted) {
+            existing.Status = models.StatusCompleted
+        }
+        return existing, nil
+    })
+}
+
+// AddSubtask appends a subtask with a server-generated ID to the todo.
+func (s *TodoService) AddSubtask(ctx context.Context, id string, st models.Subtask, opts WriteOptions) (models.Todo, error) {
+    if st.Title == "" {
+        return models.Todo{}, &ValidationError{Fields: []FieldError{{Field: "title", Code: CodeRequired}}}
+    }
+    return s.modifySubtasks(ctx, id, opts, func(subtasks []models.Subtask, now time.Time) ([]models.Subtask, error) {
+        st.ID = uuid.New().String()
+        st.CreatedAt = now
+        st.UpdatedAt = now
+        return append(subtasks, st), nil
+    })
+}
+
+// PatchSubtask applies an RFC 7396 JSON Merge Patch to a single subtask.
+func (s *TodoService) PatchSubtask(ctx context.Context, id, subID string, patch []byte, opts WriteOptions) (models.Todo, error) {
+    return s.modifySubtasks(ctx, id, opts, func(subtasks []models.Subtask, now time.Time) ([]models.Subtask, error) {
+        i, err := findSubtask(subtasks, subID)
+        if err != nil {
+            return nil, err
+        }
+
+        var patched models.Subtask
+        if err := applyMergePatchTo(subtasks[i], patch, &patched); err != nil {
+            return nil, err
+        }
+        patched.ID = subtasks[i].ID
+        patched.CreatedAt = subtasks[i].CreatedAt
+        patched.UpdatedAt = now
+        subtasks[i] = patched
+        return subtasks, nil
+    })
+}
+
+// DeleteSubtask removes a subtask from the todo.
+func (s *TodoService) DeleteSubtask(ctx context.Context, id, subID string, opts WriteOptions) (models.Todo, error) {
+    return s.modifySubtasks(ctx, id, opts, func(subtasks []models.Subtask, now time.Time) ([]models.Subtask, error) {
+        i, err := findSubtask(subtasks, subID)
+        if err != nil {
+            return nil, err
+        }
+        return append(subtasks[:i], subtasks[i+1:]...), nil
+    })
+}
+
+// ReorderSubtasks puts the todo's subtasks in the order given by ids, which
+// must list every subtask exactly once. An ID that is not a subtask of the
+// todo, such as one deleted meanwhile, is not found.
+func (s *TodoService) ReorderSubtasks(ctx context.Context, id string, ids []string, opts WriteOptions) (models.Todo, error) {
+    return s.modifySubtasks(ctx, id, opts, func(subtasks []models.Subtask, now time.Time) ([]models.Subtask, error) {
+        byID := make(map[string]models.Subtask, len(subtasks))
+        for _, st := range subtasks {
+            byID[st.ID] = st
+        }
+        seen := make(map[string]bool, len(ids))
+        reordered := make([]models.Subtask, 0, len(ids))
+        for _, subID := range ids {
+            st, ok := byID[subID]
+            if !ok {
+                return nil, fmt.Errorf("subtask with ID %s %w", subID, ErrNotFound)
+            }
+            if seen[subID] {
+                return nil, fmt.Errorf("%w: repeated subtask ID %s", ErrInvalidPatch, subID)
+            }
+            seen[subID] = true
+            reordered = append(reordered, st)
+        }
+        if len(reordered) != len(subtasks) {
+            return nil, fmt.Errorf("%w: expected %d subtask IDs, got %d", ErrInvalidPatch, len(subtasks), len(ids))
+        }
+        return reordered, nil
+    })
+}
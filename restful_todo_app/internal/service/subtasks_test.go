@@ -0,0 +1,110 @@
+package service
+
+import (
+    "context"
+    "errors"
+    "testing"
+
+    "github.com/read-my-name/restful_todo_app/pkg/models"
+)
+
+// withSubtasks adds subtasks with the titles to the todo.
+func withSubtasks(t *testing.T, svc *TodoService, todo models.Todo, titles ...string) models.Todo {
+    t.Helper()
+    for _, title := range titles {
+        var err error
+        if todo, err = svc.AddSubtask(context.Background(), todo.ID, models.Subtask{Title: title}, WriteOptions{}); err != nil {
+            t.Fatal(err)
+        }
+    }
+    return todo
+}
+
+func completeSubtask(t *testing.T, svc *TodoService, id, subID string) models.Todo {
+    t.Helper()
+    todo, err := svc.PatchSubtask(context.Background(), id, subID, []byte(`{"completed":true}`), WriteOptions{})
+    if err != nil {
+        t.Fatal(err)
+    }
+    return todo
+}
+
+func TestAutoComplete(t *testing.T) {
+    svc := newTestService(t, WithAutoComplete(true))
+    todo := withSubtasks(t, svc, todoIn(t, svc, models.StatusOnHold), "Draft", "Send")
+
+    todo = completeSubtask(t, svc, todo.ID, todo.Subtasks[0].ID)
+    if todo.Status != models.StatusOnHold || todo.CompletedAt != nil {
+        t.Fatalf("completed with a subtask left: %+v", todo)
+    }
+    todo = completeSubtask(t, svc, todo.ID, todo.Subtasks[1].ID)
+    if todo.Status != models.StatusCompleted || todo.CompletedAt == nil {
+        t.Fatalf("not completed with every subtask done: %+v", todo)
+    }
+    if p := todo.Progress(); p.Completed != 2 || p.Total != 2 || p.Percent != 100 {
+        t.Fatalf("got progress %+v", p)
+    }
+
+    // A completed todo is left alone, even if a new subtask is done at once.
+    todo, err := svc.AddSubtask(context.Background(), todo.ID, models.Subtask{Title: "Archive", Completed: true}, WriteOptions{})
+    if err != nil || todo.Status != models.StatusCompleted {
+        t.Fatalf("got %+v, %v", todo, err)
+    }
+
+    // The last subtask may also be done by removing the open one.
+    other := withSubtasks(t, svc, addTestTodo(t, svc, "other"), "Done", "Dropped")
+    other = completeSubtask(t, svc, other.ID, other.Subtasks[0].ID)
+    other, err = svc.DeleteSubtask(context.Background(), other.ID, other.Subtasks[1].ID, WriteOptions{})
+    if err != nil || other.Status != models.StatusCompleted {
+        t.Fatalf("got %+v, %v", other, err)
+    }
+}
+
+func TestAutoCompleteDisabled(t *testing.T) {
+    svc := newTestService(t)
+    todo := withSubtasks(t, svc, addTestTodo(t, svc, "Write report"), "Draft")
+    todo = completeSubtask(t, svc, todo.ID, todo.Subtasks[0].ID)
+    if todo.Status != models.StatusNotStarted || todo.CompletedAt != nil {
+        t.Fatalf("completed without auto-complete: %+v", todo)
+    }
+}
+
+func TestAutoCompleteFollowsWorkflow(t *testing.T) {
+    // A workflow where only in progress todos can be completed.
+    w := DefaultWorkflow()
+    w.Transitions[models.StatusNotStarted] = []models.Status{models.StatusInProgress}
+    svc := newTestService(t, WithAutoComplete(true), WithWorkflow(w))
+    todo := withSubtasks(t, svc, addTestTodo(t, svc, "Write report"), "Draft")
+    todo = completeSubtask(t, svc, todo.ID, todo.Subtasks[0].ID)
+    if todo.Status != models.StatusNotStarted {
+        t.Fatalf("completed against the workflow: %+v", todo)
+    }
+}
+
+func TestReorderSubtasksErrors(t *testing.T) {
+    ctx := context.Background()
+    svc := newTestService(t)
+    todo := withSubtasks(t, svc, addTestTodo(t, svc, "Write report"), "Draft", "Send")
+    a, b := todo.Subtasks[0].ID, todo.Subtasks[1].ID
+
+    tests := []struct {
+        ids  []string
+        want error
+    }{
+        {[]string{a}, ErrInvalidPatch},
+        {[]string{a, a}, ErrInvalidPatch},
+        {[]string{a, b, a}, ErrInvalidPatch},
+        {nil, ErrInvalidPatch},
+        {[]string{a, "stale"}, ErrNotFound},
+        {[]string{"stale"}, ErrNotFound},
+    }
+    for _, tt := range tests {
+        if _, err := svc.ReorderSubtasks(ctx, todo.ID, tt.ids, WriteOptions{}); !errors.Is(err, tt.want) {
+            t.Errorf("%q: got %v, want %v", tt.ids, err, tt.want)
+        }
+    }
+    reordered, err := svc.ReorderSubtasks(ctx, todo.ID, []string{b, a}, WriteOptions{})
+    if err != nil || reordered.Subtasks[0].ID != b || reordered.Subtasks[1].ID != a {
+        t.Fatalf("got %+v, %v", reordered.Subtasks, err)
+    }
+}
@@ -3,6 +3,8 @@ package service
 import (
     "context"
     "fmt"
+    "log/slog"
+    "sync"
     "time"
 
     "github.com/read-my-name/restful_todo_app/pkg/models"
@@ -11,25 +13,145 @@ import (
 
 type TodoService struct {
     storage    Storage
-    saveQueue  chan models.Todo
+    saveQueue  chan saveOp
     errorChan  chan error
+
+    // ctx is canceled by Close once the queue has been drained, which
+    // stops the background workers.
+    ctx        context.Context
+    cancel     context.CancelFunc
+
+    // mu guards the in-memory index, which is the authoritative view of
+    // the todos; storage catches up through saveQueue. generation is that
+    // of a Watcher storage when the index was loaded from it.
+    mu         sync.RWMutex
+    todos      map[string]models.Todo
+    order      []string
+    index      *searchIndex
+    generation uint64
+    closed     bool
+
+    autoComplete   bool
+    requireIfMatch bool
+    workflow       Workflow
+    limits         Limits
+
+    // Reminders of due todos, sent by runReminders when notifiers are set.
+    reminders   *reminderScheduler
+    notifiers   []Notifier
+    reminderLog ReminderLog
+    retry       RetryPolicy
+}
+
+// WriteOptions controls how a write is acknowledged.
+type WriteOptions struct {
+    // Sync waits until the write has reached storage and returns any
+    // error from the save worker instead of handing it to errorChan.
+    Sync bool
+
+    // IfMatch makes a write to an existing todo conditional on its
+    // current version, as the If-Match header does: the write fails with
+    // ErrPreconditionFailed unless the version is listed. nil writes
+    // unconditionally and an empty slice matches any version.
+    IfMatch []int
+}
+
+// checkVersion reports whether opts allow a write to t.
+func (s *TodoService) checkVersion(t models.Todo, opts WriteOptions) error {
+    if opts.IfMatch == nil {
+        if s.requireIfMatch {
+            return ErrPreconditionRequired
+        }
+        return nil
+    }
+    if len(opts.IfMatch) == 0 {
+        return nil
+    }
+    for _, v := range opts.IfMatch {
+        if v == t.Version {
+            return nil
+        }
+    }
+    return fmt.Errorf("%w: current version is %d", ErrPreconditionFailed, t.Version)
+}
+
+type opKind int
+
+const (
+    opSave opKind = iota
+    opUpdate
+    opDelete
+    opFlush
+)
+
+// saveOp is a unit of work for the save worker. done is non-nil when the
+// caller waits for the result.
+type saveOp struct {
+    kind opKind
+    todo models.Todo
+    done chan error
 }
 
 type Storage interface {
     Save(t models.Todo) error
     SaveAll([]models.Todo) error
     Load() ([]models.Todo, error)
+    Update(id string, t models.Todo) error
+    Delete(id string) error
 }
 
-func NewTodoService(storage Storage) *TodoService {
+// HealthReporter is implemented by storages that track problems found in
+// their data, such as rows skipped while loading.
+type HealthReporter interface {
+    Health() models.StorageHealth
+}
+
+// Querier is implemented by storages that can evaluate the status,
+// priority, label and due date criteria of a filter themselves. Periods
+// are resolved to a due date range before a filter is passed on.
+type Querier interface {
+    Query(filter models.TodoFilter) ([]models.Todo, error)
+}
+
+// Recoverer is implemented by storages that can be left with unfinished
+// writes by a crash. Recover completes or discards them and returns how
+// many were replayed.
+type Recoverer interface {
+    Recover() (int, error)
+}
+
+// Watcher is implemented by storages that other processes can write to.
+// Generation changes when the storage has seen such a write, and the
+// service then loads the todos again before it reads or changes them.
+type Watcher interface {
+    Generation() (uint64, error)
+}
+
+func NewTodoService(storage Storage, opts ...Option) *TodoService {
+    ctx, cancel := context.WithCancel(context.Background())
     svc := &TodoService{
-        storage:    storage,
-        saveQueue:  make(chan models.Todo, 100),
-        errorChan:  make(chan error, 10),
+        ctx:         ctx,
+        cancel:      cancel,
+        storage:     storage,
+        saveQueue:   make(chan saveOp, 100),
+        errorChan:   make(chan error, 10),
+        todos:       make(map[string]models.Todo),
+        index:       newSearchIndex(),
+        workflow:    DefaultWorkflow(),
+        limits:      DefaultLimits(),
+        reminders:   newReminderScheduler(),
+        reminderLog: newMemoryReminderLog(),
+        retry:       DefaultRetryPolicy(),
+    }
+    for _, opt := range opts {
+        opt(svc)
     }
     
-    go svc.startSaveWorker(context.Background())
-    go svc.startErrorHandler(context.Background())
+    go svc.startSaveWorker(ctx)
+    go svc.startErrorHandler(ctx)
+    if len(svc.notifiers) > 0 {
+        go svc.runReminders(ctx)
+    }
     
     return svc
 }
@@ -61,244 +183,524 @@ func isValidPeriod(p string) bool {
     switch p {
         case "all", 
             "today", 
+            "tomorrow", 
             "week", 
-            "month":
+            "month", 
+            "overdue", 
+            "upcoming":
             return true
     }
     return false
 }
 
-func isValidTitle(t string) bool {
-    if len(t) > 100 {
-        fmt.Println("Title should not be longer than 100 characters")
-        return false
+func (s *TodoService) startSaveWorker(ctx context.Context) {
+    for {
+        select {
+            case op := <-s.saveQueue:
+                err := s.apply(op)
+                if op.done != nil {
+                    op.done <- err
+                } else if err != nil {
+                    select {
+                        case s.errorChan <- err:
+                        case <-ctx.Done():
+                    }
+                }
+            case <-ctx.Done():
+                slog.Debug("Save worker context canceled, exiting")
+                return
+        }
     }
-    return true
 }
 
-func isValidDescription(d string) bool {
-    if len(d) > 200 {
-        fmt.Println("Description should not be longer than 200 characters")
-        return false
+// apply writes a single queued operation through to storage.
+func (s *TodoService) apply(op saveOp) error {
+    var err error
+    switch op.kind {
+        case opSave:
+            err = s.storage.Save(op.todo)
+        case opUpdate:
+            err = s.storage.Update(op.todo.ID, op.todo)
+        case opDelete:
+            err = s.storage.Delete(op.todo.ID)
+        case opFlush:
+            return nil
     }
-    return true
+    if err != nil {
+        slog.Warn("Error persisting todo", "id", op.todo.ID, "err", err)
+        return fmt.Errorf("failed to persist todo with ID %s: %w", op.todo.ID, err)
+    }
+    return nil
 }
 
-func isValidDueDate(d time.Time) bool {
-    if d.Before(time.Now()) {
-        fmt.Println("Due date should not be in the past")
-        return false
+// enqueue hands ops to the save worker without blocking, either all of
+// them or none. It must be called with s.mu held so that the queue order
+// matches the order of index changes; as every other sender is locked out,
+// the free space cannot shrink between checking and sending.
+func (s *TodoService) enqueue(ops ...saveOp) error {
+    if s.closed {
+        return ErrClosed
+    }
+    if cap(s.saveQueue)-len(s.saveQueue) < len(ops) {
+        return fmt.Errorf("%w: save queue is full", ErrBackpressure)
     }
-    return true
+    for _, op := range ops {
+        s.saveQueue <- op
+    }
+    return nil
 }
 
-func isValidLabel(l string) bool {
-    if len(l) > 20 {
-        fmt.Println("Label should not be longer than 20 characters")
-        return false
+// wait blocks until the worker has processed op when the write is synchronous.
+func wait(ctx context.Context, op saveOp) error {
+    if op.done == nil {
+        return nil
+    }
+    select {
+        case err := <-op.done:
+            return err
+        case <-ctx.Done():
+            return ctx.Err()
     }
-    return true
 }
 
-func isValidSubtask(s models.Subtask) bool {
-    if len(s.Title) > 100 {
-        fmt.Println("Subtask title should not be longer than 100 characters")
-        return false
+func newOp(kind opKind, t models.Todo, opts WriteOptions) saveOp {
+    op := saveOp{kind: kind, todo: t}
+    if opts.Sync {
+        op.done = make(chan error, 1)
     }
-    return true
+    return op
 }
 
-func (s *TodoService) startSaveWorker(ctx context.Context) {
-    for {
-        select {
-            case todo := <-s.saveQueue:
-                fmt.Printf("Received todo with ID %s for saving", todo.ID)
-                if err := s.storage.Save(todo); err != nil {
-                    fmt.Printf("Error saving todo with ID %s: %v", todo.ID, err)
-                    s.errorChan <- err
-                } else {
-                    fmt.Printf("Successfully saved todo with ID %s", todo.ID)
-                }
-            case <-ctx.Done():
-                fmt.Println("Save worker context canceled, exiting")
-                return
-        }
+// Flush waits until every write queued before the call has reached storage.
+func (s *TodoService) Flush(ctx context.Context) error {
+    s.mu.RLock()
+    if s.closed {
+        s.mu.RUnlock()
+        return ErrClosed
+    }
+    op := saveOp{kind: opFlush, done: make(chan error, 1)}
+    select {
+        case s.saveQueue <- op:
+        case <-ctx.Done():
+            s.mu.RUnlock()
+            return ctx.Err()
+    }
+    s.mu.RUnlock()
+    return wait(ctx, op)
+}
+
+// Close stops accepting writes, waits for the save queue to drain and then
+// stops the background workers. If ctx expires first, queued writes that
+// have not reached storage yet are abandoned and ctx's error is returned.
+func (s *TodoService) Close(ctx context.Context) error {
+    s.mu.Lock()
+    if s.closed {
+        s.mu.Unlock()
+        return nil
+    }
+    s.closed = true
+    s.mu.Unlock()
+    defer s.cancel()
+
+    // No new writes can be queued now, so once the worker reaches this
+    // marker everything before it has been persisted.
+    op := saveOp{kind: opFlush, done: make(chan error, 1)}
+    select {
+        case s.saveQueue <- op:
+        case <-ctx.Done():
+            return ctx.Err()
     }
+    return wait(ctx, op)
 }
 
 func (s *TodoService) startErrorHandler(ctx context.Context) {
     for {
         select {
             case err := <-s.errorChan:
-                fmt.Printf("Error encountered: %v", err)
+                slog.Error("Error encountered", "err", err)
             case <-ctx.Done():
-                fmt.Println("Error handler context canceled, exiting")
-                return
+                // Report anything the save worker queued before it stopped.
+                for {
+                    select {
+                        case err := <-s.errorChan:
+                            slog.Error("Error encountered", "err", err)
+                        default:
+                            slog.Debug("Error handler context canceled, exiting")
+                            return
+                    }
+                }
         }
     }
 }
 
 func (s *TodoService) GetTodos(filter models.TodoFilter) ([]models.Todo, error) {
-    todos, err := s.storage.Load()
-    if err != nil {
-        fmt.Printf("Error loading todos: %v", err)
+    if err := validateFilter(filter); err != nil {
         return nil, err
     }
+    if err := s.refresh(); err != nil {
+        return nil, err
+    }
+    if q, ok := s.storage.(Querier); ok && isFiltered(filter) {
+        return s.queryStorage(q, filter)
+    }
     
-    if filter.Period != "all" {
-        return s.CategorizeTodos(todos, filter), nil
+    return s.CategorizeTodos(s.snapshot(), filter), nil
+}
+
+// snapshot returns the indexed todos in insertion order.
+func (s *TodoService) snapshot() []models.Todo {
+    s.mu.RLock()
+    defer s.mu.RUnlock()
+
+    todos := make([]models.Todo, 0, len(s.order))
+    for _, id := range s.order {
+        todos = append(todos, s.todos[id])
     }
-    return todos, nil
+    return todos
 }
 
-func (s *TodoService) AddTodo(t models.Todo) error {
+// GetTodo returns the todo with the given ID from the in-memory index.
+func (s *TodoService) GetTodo(id string) (models.Todo, error) {
+    if err := s.refresh(); err != nil {
+        return models.Todo{}, err
+    }
+    s.mu.RLock()
+    defer s.mu.RUnlock()
+
+    t, ok := s.todos[id]
+    if !ok {
+        return models.Todo{}, fmt.Errorf("todo with ID %s %w", id, ErrNotFound)
+    }
+    return t, nil
+}
+
+func (s *TodoService) AddTodo(ctx context.Context, t models.Todo, opts WriteOptions) (models.Todo, error) {
     t.ID = uuid.New().String()
     
     // Set timestamps
     now := time.Now().UTC()
     t.CreatedAt = now
     t.UpdatedAt = now
+    t.Subtasks = prepareSubtasks(t.Subtasks, now)
+    t.CompletedAt = nil
+    t.ArchivedAt = nil
+    t.Version = 1
+    t.Virtual = false
+    normalizeRecurrence(&t)
+    stampStatus(&t, "", now)
     
-    // Validate status
-    if !isValidStatus(t.Status) {
-        return fmt.Errorf("invalid status: %s", t.Status)
+    if err := validateTodo(t, nil, s.limits); err != nil {
+        return models.Todo{}, err
+    }
+    if !s.workflow.AllowsInitial(t.Status) {
+        return models.Todo{}, fmt.Errorf("%w: a todo cannot be created %s", ErrInvalidTransition, t.Status)
     }
     
-    // Validate priority
-    if !isValidPriority(t.Priority) {
-        return fmt.Errorf("invalid priority: %s", t.Priority)
+    op := newOp(opSave, t, opts)
+    s.mu.Lock()
+    if err := s.refreshLocked(); err != nil {
+        s.mu.Unlock()
+        return models.Todo{}, err
+    }
+    if err := s.enqueue(op); err != nil {
+        s.mu.Unlock()
+        return models.Todo{}, err
+    }
+    s.todos[t.ID] = t
+    s.order = append(s.order, t.ID)
+    s.index.add(t)
+    s.scheduleReminders(t)
+    s.mu.Unlock()
+
+    return t, wait(ctx, op)
+}
+
+// modify applies change to the current version of the todo with the given
+// ID and queues the result. The todo is locked for the duration, so change
+// always sees the latest state. ID and CreatedAt cannot be changed,
+// UpdatedAt and Version are bumped and status changes must follow the
+// workflow. Completing a recurring todo also adds its next occurrence.
+func (s *TodoService) modify(ctx context.Context, id string, opts WriteOptions, change func(existing models.Todo) (models.Todo, error)) (models.Todo, error) {
+    return s.commit(ctx, id, opts, change, true)
+}
+
+// commit implements modify. checkWorkflow is false for explicit transition
+// actions, which validate the status change themselves.
+func (s *TodoService) commit(ctx context.Context, id string, opts WriteOptions, change func(existing models.Todo) (models.Todo, error), checkWorkflow bool) (models.Todo, error) {
+    s.mu.Lock()
+    if err := s.refreshLocked(); err != nil {
+        s.mu.Unlock()
+        return models.Todo{}, err
+    }
+    existing, ok := s.todos[id]
+    if !ok {
+        s.mu.Unlock()
+        return models.Todo{}, fmt.Errorf("todo with ID %s %w", id, ErrNotFound)
+    }
+    if err := s.checkVersion(existing, opts); err != nil {
+        s.mu.Unlock()
+        return models.Todo{}, err
     }
 
-    if !isValidDueDate(t.DueDate) {
-        return fmt.Errorf("invalid due date: %s", t.DueDate)
+    updated, err := change(existing)
+    if err != nil {
+        s.mu.Unlock()
+        return models.Todo{}, err
     }
-    
-    // Validate title
-    if !isValidTitle(t.Title) {
-        return fmt.Errorf("invalid title: %s", t.Title)
+    now := time.Now().UTC()
+    updated.ID = existing.ID
+    updated.CreatedAt = existing.CreatedAt
+    updated.UpdatedAt = now
+    updated.Subtasks = prepareSubtasks(updated.Subtasks, now)
+    updated.CompletedAt = existing.CompletedAt
+    updated.ArchivedAt = existing.ArchivedAt
+    updated.Version = existing.Version + 1
+    updated.Virtual = false
+    normalizeRecurrence(&updated)
+    stampStatus(&updated, existing.Status, now)
+
+    // Invalid values, an unknown status included, are reported before
+    // the status change is checked against the workflow.
+    if err := validateTodo(updated, &existing, s.limits); err != nil {
+        s.mu.Unlock()
+        return models.Todo{}, err
     }
-    
-    // Validate description
-    if !isValidDescription(t.Description) {
-        return fmt.Errorf("invalid description: %s", t.Description)
+    if checkWorkflow && updated.Status != existing.Status && !s.workflow.Allows(existing.Status, updated.Status) {
+        s.mu.Unlock()
+        return models.Todo{}, fmt.Errorf("%w: %s to %s", ErrInvalidTransition, existing.Status, updated.Status)
     }
 
-    // Validate subtasks
-    for _, subtask := range t.Subtasks {
-        if !isValidSubtask(subtask) {
-            return fmt.Errorf("invalid subtask: %s", subtask.Title)
+    var next models.Todo
+    recurs := false
+    if isDone(updated) && !isDone(existing) && updated.Recurrence != "" {
+        if next, recurs = nextInstance(updated, now); recurs {
+            // The schedule moves on to the new todo, so reopening this
+            // one and completing it again does not repeat it.
+            updated.Recurrence = ""
         }
     }
-    
-    // Validate labels
-    for _, label := range t.Labels {
-        if !isValidLabel(label) {
-            return fmt.Errorf("invalid label: %s", label)
+
+    ops := []saveOp{newOp(opUpdate, updated, opts)}
+    if recurs {
+        ops = append(ops, newOp(opSave, next, opts))
+    }
+    if err := s.enqueue(ops...); err != nil {
+        s.mu.Unlock()
+        return models.Todo{}, err
+    }
+    s.todos[id] = updated
+    s.index.add(updated)
+    s.scheduleReminders(updated)
+    if recurs {
+        s.todos[next.ID] = next
+        s.order = append(s.order, next.ID)
+        s.index.add(next)
+        s.scheduleReminders(next)
+    }
+    s.mu.Unlock()
+
+    for _, op := range ops {
+        if err := wait(ctx, op); err != nil {
+            return updated, err
         }
     }
-    
-    select {
-        case s.saveQueue <- t:
-            return nil
-        default:
-            return fmt.Errorf("save queue is full")
-    }
+    return updated, nil
 }
 
-func (s *TodoService) UpdateTodo(id string, updatedTodo models.Todo) error {
-    existing, err := s.storage.Load()
-    if err != nil {
+// UpdateTodo replaces the todo with the given ID.
+func (s *TodoService) UpdateTodo(ctx context.Context, id string, updatedTodo models.Todo, opts WriteOptions) (models.Todo, error) {
+    return s.modify(ctx, id, opts, func(models.Todo) (models.Todo, error) {
+        return updatedTodo, nil
+    })
+}
+
+// PatchTodo applies an RFC 7396 JSON Merge Patch to the todo with the given
+// ID, changing only the fields present in the patch.
+func (s *TodoService) PatchTodo(ctx context.Context, id string, patch []byte, opts WriteOptions) (models.Todo, error) {
+    return s.modify(ctx, id, opts, func(existing models.Todo) (models.Todo, error) {
+        return applyMergePatch(existing, patch)
+    })
+}
+
+func (s *TodoService) DeleteTodo(ctx context.Context, id string, opts WriteOptions) error {
+    op := newOp(opDelete, models.Todo{ID: id}, opts)
+    s.mu.Lock()
+    if err := s.refreshLocked(); err != nil {
+        s.mu.Unlock()
         return err
     }
-    
-    updatedTodo.UpdatedAt = time.Now().UTC()
-    
-    // Validate status
-    if !isValidStatus(updatedTodo.Status) {
-        return fmt.Errorf("invalid status: %s", updatedTodo.Status)
+    existing, ok := s.todos[id]
+    if !ok {
+        s.mu.Unlock()
+        return fmt.Errorf("todo with ID %s %w", id, ErrNotFound)
     }
-    
-    // Validate priority
-    if !isValidPriority(updatedTodo.Priority) {
-        return fmt.Errorf("invalid priority: %s", updatedTodo.Priority)
+    if err := s.checkVersion(existing, opts); err != nil {
+        s.mu.Unlock()
+        return err
     }
-    
-    // Validate due date
-    if !isValidDueDate(updatedTodo.DueDate) {
-        return fmt.Errorf("invalid due date: %s", updatedTodo.DueDate)
+    if err := s.enqueue(op); err != nil {
+        s.mu.Unlock()
+        return err
     }
-    
-    // Validate title
-    if !isValidTitle(updatedTodo.Title) {
-        return fmt.Errorf("invalid title: %s", updatedTodo.Title)
+    delete(s.todos, id)
+    s.index.remove(id)
+    for i, orderID := range s.order {
+        if orderID == id {
+            s.order = append(s.order[:i], s.order[i+1:]...)
+            break
+        }
     }
-    
-    // Validate description
-    if !isValidDescription(updatedTodo.Description) {
-        return fmt.Errorf("invalid description: %s", updatedTodo.Description)
+    s.mu.Unlock()
+
+    return wait(ctx, op)
+}
+
+// StorageHealth reports problems the storage found in its data. Storages
+// that do not track any are reported as ok.
+func (s *TodoService) StorageHealth() models.StorageHealth {
+    if reporter, ok := s.storage.(HealthReporter); ok {
+        return reporter.Health()
     }
+    return models.StorageHealth{Status: "ok"}
+}
 
-    // Validate subtasks
-    for _, subtask := range updatedTodo.Subtasks {
-        if !isValidSubtask(subtask) {
-            return fmt.Errorf("invalid subtask: %s", subtask.Title)
+// LoadInitialData reads every todo from storage into the in-memory index,
+// first letting the storage recover from a crash if it supports that.
+func (s *TodoService) LoadInitialData() ([]models.Todo, error) {
+    if recoverer, ok := s.storage.(Recoverer); ok {
+        replayed, err := recoverer.Recover()
+        if err != nil {
+            return nil, fmt.Errorf("failed to recover storage: %w", err)
+        }
+        if replayed > 0 {
+            slog.Info("Recovered journaled writes", "count", replayed)
         }
     }
 
-    // Validate labels
-    for _, label := range updatedTodo.Labels {
-        if !isValidLabel(label) {
-            return fmt.Errorf("invalid label: %s", label)
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    return s.load()
+}
+
+// load replaces the index with the todos in storage. Callers must hold
+// s.mu for writing.
+func (s *TodoService) load() ([]models.Todo, error) {
+    var gen uint64
+    if w, ok := s.storage.(Watcher); ok {
+        // Taken before loading: a write that comes in meanwhile moves the
+        // generation on again and is loaded next time.
+        g, err := w.Generation()
+        if err != nil {
+            return nil, fmt.Errorf("failed to check storage for changes: %w", err)
         }
+        gen = g
+    }
+    todos, err := s.storage.Load()
+    if err != nil {
+        return nil, err
     }
 
-    for i, todo := range existing {
-        if todo.ID == id {
-            existing[i] = updatedTodo
-            return s.storage.Save(updatedTodo)
+    s.todos = make(map[string]models.Todo, len(todos))
+    s.order = s.order[:0]
+    s.index = newSearchIndex()
+    for i, t := range todos {
+        // Todos stored before versions were tracked start at 1.
+        if t.Version < 1 {
+            t.Version = 1
+            todos[i] = t
         }
+        if _, ok := s.todos[t.ID]; !ok {
+            s.order = append(s.order, t.ID)
+        }
+        s.todos[t.ID] = t
+        s.index.add(t)
+        s.scheduleReminders(t)
     }
-    return fmt.Errorf("todo with ID %s not found", id)
+    s.generation = gen
+    return todos, nil
 }
 
-func (s *TodoService) DeleteTodo(id string) error {
-    existing, err := s.storage.Load()
+// refresh loads the todos again if another process has written to a
+// Watcher storage since they were loaded. Reads call it before they look
+// at the index.
+func (s *TodoService) refresh() error {
+    w, ok := s.storage.(Watcher)
+    if !ok {
+        return nil
+    }
+    gen, err := w.Generation()
     if err != nil {
-        return err
+        return fmt.Errorf("failed to check storage for changes: %w", err)
     }
-    
-    for i, todo := range existing {
-        if todo.ID == id {
-            existing = append(existing[:i], existing[i+1:]...)
-            return s.storage.Save(existing[i])
-        }
+    s.mu.RLock()
+    current := s.generation
+    s.mu.RUnlock()
+    if gen == current {
+        return nil
     }
-    return fmt.Errorf("todo with ID %s not found", id)
+
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    return s.refreshLocked()
 }
 
-func (s *TodoService) LoadInitialData() ([]models.Todo, error) {
-    return s.storage.Load()
+// refreshLocked is refresh for callers that hold s.mu for writing, as
+// writes do so that they apply to the latest todos.
+func (s *TodoService) refreshLocked() error {
+    w, ok := s.storage.(Watcher)
+    if !ok || s.closed {
+        return nil
+    }
+    gen, err := w.Generation()
+    if err != nil {
+        return fmt.Errorf("failed to check storage for changes: %w", err)
+    }
+    if gen == s.generation {
+        return nil
+    }
+    // Writes still queued are not in storage yet; once they are, it holds
+    // both theirs and ours.
+    if err := s.drain(); err != nil {
+        return err
+    }
+    _, err = s.load()
+    return err
 }
 
+// drain waits until the save worker has written every queued op. It is
+// called with s.mu held, which keeps new ops out; the worker does not
+// need s.mu to make progress.
+func (s *TodoService) drain() error {
+    op := saveOp{kind: opFlush, done: make(chan error, 1)}
+    select {
+        case s.saveQueue <- op:
+        case <-s.ctx.Done():
+            return ErrClosed
+    }
+    return wait(s.ctx, op)
+}
+
+// CategorizeTodos returns the todos matching filter. Calendar periods are
+// computed in filter.Location, or the server's local zone if it is nil.
 func (s *TodoService) CategorizeTodos(todos []models.Todo, filter models.TodoFilter) []models.Todo {
-    now := time.Now()
-    var filtered []models.Todo
+    loc := filter.Location
+    if loc == nil {
+        loc = time.Local
+    }
+    now := time.Now().In(loc)
+    filtered := []models.Todo{}
+    
+    start, end, bounded := periodBounds(filter.Period, now)
     
     for _, t := range todos {
-        switch filter.Period {
-        case "today":
-            if t.DueDate.Truncate(24*time.Hour).Equal(now.Truncate(24*time.Hour)) {
-                filtered = append(filtered, t)
-            }
-        case "week":
-            year, week := t.DueDate.ISOWeek()
-            currentYear, currentWeek := now.ISOWeek()
-            if year == currentYear && week == currentWeek {
-                filtered = append(filtered, t)
-            }
-        case "month":
-            if t.DueDate.Month() == now.Month() && t.DueDate.Year() == now.Year() {
-                filtered = append(filtered, t)
+        candidates := []models.Todo{t}
+        if bounded {
+            // Recurring todos also show up on the days they will recur.
+            candidates = append(candidates, occurrences(t, start, end)...)
+        }
+        for _, c := range candidates {
+            if matchesFilter(c, filter) && inPeriod(c, filter.Period, now) {
+                filtered = append(filtered, c)
             }
         }
     }
@@ -0,0 +1,131 @@
+package service
+
+import (
+    "fmt"
+    "strings"
+    "time"
+    "unicode/utf8"
+
+    "github.com/read-my-name/restful_todo_app/pkg/models"
+)
+
+// Codes of a FieldError.
+const (
+    CodeRequired = "required"
+    CodeInvalid  = "invalid"
+    CodeTooLong  = "too_long"
+    CodeInPast   = "in_past"
+)
+
+// Limits are the maximum lengths of todo fields, counted in characters.
+type Limits struct {
+    Title        int
+    Description  int
+    Label        int
+    SubtaskTitle int
+}
+
+// DefaultLimits returns the limits used unless WithLimits is given.
+func DefaultLimits() Limits {
+    return Limits{Title: 100, Description: 200, Label: 20, SubtaskTitle: 100}
+}
+
+// FieldError reports one invalid value. Field is its path in the JSON
+// form of the todo, such as "labels[2]" or "subtasks[0].title", and Max
+// is the limit a too_long value exceeds.
+type FieldError struct {
+    Field string `json:"field"`
+    Code  string `json:"code"`
+    Max   int    `json:"max,omitempty"`
+}
+
+func (e FieldError) String() string {
+    if e.Max > 0 {
+        return fmt.Sprintf("%s: %s (max %d)", e.Field, e.Code, e.Max)
+    }
+    return fmt.Sprintf("%s: %s", e.Field, e.Code)
+}
+
+// ValidationError lists every invalid value of a write.
+type ValidationError struct {
+    Fields []FieldError
+}
+
+func (e *ValidationError) Error() string {
+    msgs := make([]string, len(e.Fields))
+    for i, f := range e.Fields {
+        msgs[i] = f.String()
+    }
+    return "invalid todo: " + strings.Join(msgs, "; ")
+}
+
+func (e *ValidationError) Is(target error) bool {
+    return target == ErrValidation
+}
+
+// validator collects field errors so that all of them are reported at once.
+type validator struct {
+    fields []FieldError
+}
+
+func (v *validator) add(field, code string) {
+    v.fields = append(v.fields, FieldError{Field: field, Code: code})
+}
+
+func (v *validator) maxLength(field, value string, max int) {
+    if utf8.RuneCountInString(value) > max {
+        v.fields = append(v.fields, FieldError{Field: field, Code: CodeTooLong, Max: max})
+    }
+}
+
+func (v *validator) err() error {
+    if len(v.fields) == 0 {
+        return nil
+    }
+    return &ValidationError{Fields: v.fields}
+}
+
+// validateTodo checks the fields of a write, returning a *ValidationError
+// that lists every invalid one. The due date is only checked when it
+// differs from existing, so todos that are already overdue can still be
+// edited; existing is nil for new todos.
+func validateTodo(t models.Todo, existing *models.Todo, limits Limits) error {
+    var v validator
+    checkTodo(&v, t, existing, limits)
+    return v.err()
+}
+
+func checkTodo(v *validator, t models.Todo, existing *models.Todo, limits Limits) {
+    v.maxLength("title", t.Title, limits.Title)
+    v.maxLength("description", t.Description, limits.Description)
+    if !isValidStatus(t.Status) {
+        v.add("status", CodeInvalid)
+    }
+    if !isValidPriority(t.Priority) {
+        v.add("priority", CodeInvalid)
+    }
+    if existing == nil || !t.DueDate.Equal(existing.DueDate) {
+        if t.DueDate.Before(time.Now()) {
+            v.add("due_date", CodeInPast)
+        }
+    }
+    for i, label := range t.Labels {
+        v.maxLength(fmt.Sprintf("labels[%d]", i), label, limits.Label)
+    }
+    for i, subtask := range t.Subtasks {
+        v.maxLength(fmt.Sprintf("subtasks[%d].title", i), subtask.Title, limits.SubtaskTitle)
+    }
+    checkRecurrence(v, t)
+    checkReminders(v, t)
+}
+
+// ValidateStoredTodo checks a todo read from storage or an import file
+// with the same rules as writes, except that past due dates are allowed.
+func ValidateStoredTodo(t models.Todo, limits Limits) error {
+    var v validator
+    if t.ID == "" {
+        v.add("id", CodeRequired)
+    }
+    checkTodo(&v, t, &t, limits)
+    return v.err()
+}
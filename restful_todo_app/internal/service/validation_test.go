@@ -0,0 +1,101 @@
+package service
+
+import (
+    "context"
+    "errors"
+    "reflect"
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/read-my-name/restful_todo_app/pkg/models"
+)
+
+func fieldsOf(t *testing.T, err error) []FieldError {
+    t.Helper()
+    var validationErr *ValidationError
+    if !errors.As(err, &validationErr) {
+        t.Fatalf("got %v, want a *ValidationError", err)
+    }
+    if !errors.Is(err, ErrValidation) {
+        t.Fatalf("%v does not match ErrValidation", err)
+    }
+    return validationErr.Fields
+}
+
+func TestValidateTodoCollectsEveryField(t *testing.T) {
+    limits := Limits{Title: 5, Description: 5, Label: 3, SubtaskTitle: 4}
+    todo := models.Todo{
+        Title:       "too long",
+        Description: "fine",
+        Status:      "bogus",
+        Priority:    "urgent",
+        DueDate:     time.Now().Add(-time.Hour),
+        Labels:      []string{"ok", "long"},
+        Subtasks:    []models.Subtask{{Title: "fine"}, {Title: "too long"}},
+    }
+    got := fieldsOf(t, validateTodo(todo, nil, limits))
+    want := []FieldError{
+        {Field: "title", Code: CodeTooLong, Max: 5},
+        {Field: "status", Code: CodeInvalid},
+        {Field: "priority", Code: CodeInvalid},
+        {Field: "due_date", Code: CodeInPast},
+        {Field: "labels[1]", Code: CodeTooLong, Max: 3},
+        {Field: "subtasks[1].title", Code: CodeTooLong, Max: 4},
+    }
+    if !reflect.DeepEqual(got, want) {
+        t.Fatalf("got fields %v, want %v", got, want)
+    }
+}
+
+func TestValidateTodoCountsCharacters(t *testing.T) {
+    limits := DefaultLimits()
+    todo := newTestTodo(strings.Repeat("ü", limits.Title), time.Now().Add(time.Hour))
+    todo.Labels = []string{strings.Repeat("日", limits.Label)}
+    if err := validateTodo(todo, nil, limits); err != nil {
+        t.Fatalf("values at the limit in multi-byte characters got %v", err)
+    }
+
+    todo.Title += "ü"
+    got := fieldsOf(t, validateTodo(todo, nil, limits))
+    if len(got) != 1 || got[0].Field != "title" || got[0].Max != limits.Title {
+        t.Fatalf("got fields %v", got)
+    }
+}
+
+func TestValidateTodoKeepsExistingDueDate(t *testing.T) {
+    overdue := newTestTodo("overdue", time.Now().Add(-time.Hour))
+    if err := validateTodo(overdue, &overdue, DefaultLimits()); err != nil {
+        t.Fatalf("editing an overdue todo without moving its due date got %v", err)
+    }
+}
+
+func TestUpdateReportsInvalidStatusAsField(t *testing.T) {
+    svc := newTestService(t)
+    todo := addTestTodo(t, svc, "write report")
+    ctx := context.Background()
+    status := FieldError{Field: "status", Code: CodeInvalid}
+
+    update := todo
+    update.Status = "bogus"
+    update.Title = strings.Repeat("x", 101)
+    _, err := svc.UpdateTodo(ctx, todo.ID, update, WriteOptions{})
+    want := []FieldError{{Field: "title", Code: CodeTooLong, Max: 100}, status}
+    if got := fieldsOf(t, err); !reflect.DeepEqual(got, want) {
+        t.Fatalf("PUT: got fields %v, want %v", got, want)
+    }
+
+    patch := `{"status": "bogus", "labels": ["` + strings.Repeat("x", 21) + `"]}`
+    _, err = svc.PatchTodo(ctx, todo.ID, []byte(patch), WriteOptions{})
+    want = []FieldError{status, {Field: "labels[0]", Code: CodeTooLong, Max: 20}}
+    if got := fieldsOf(t, err); !reflect.DeepEqual(got, want) {
+        t.Fatalf("PATCH: got fields %v, want %v", got, want)
+    }
+
+    // A valid status the workflow does not allow is still a conflict.
+    update = todo
+    update.Status = models.StatusArchived
+    if _, err := svc.UpdateTodo(ctx, todo.ID, update, WriteOptions{}); !errors.Is(err, ErrInvalidTransition) || !errors.Is(err, ErrConflict) {
+        t.Fatalf("archiving a todo that is not completed got %v", err)
+    }
+}
@@ -0,0 +1,117 @@
+package service
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "time"
+
+    "github.com/read-my-name/restful_todo_app/pkg/models"
+)
+
+var (
+    // ErrInvalidTransition is returned when a status change is not allowed
+    // by the workflow.
+    ErrInvalidTransition error = &kindError{msg: "invalid status transition", kind: ErrConflict}
+    // ErrUnknownAction is returned for transition actions the workflow
+    // does not define.
+    ErrUnknownAction = errors.New("unknown transition action")
+)
+
+// Action is a named, explicit status change such as "archive" or "reopen".
+type Action struct {
+    From []models.Status
+    To   models.Status
+}
+
+// Workflow describes the status changes a todo may go through.
+type Workflow struct {
+    // Initial lists the statuses a new todo may be created in. An empty
+    // list allows any status.
+    Initial []models.Status
+    // Transitions lists, for each status, the statuses an ordinary update
+    // (PUT or PATCH) may move a todo to.
+    Transitions map[models.Status][]models.Status
+    // Actions are the explicit transitions offered by
+    // POST /todos/:id/transitions. They may allow moves that ordinary
+    // updates cannot make, such as reopening a completed todo.
+    Actions map[string]Action
+}
+
+// DefaultWorkflow lets active todos move freely between not started, in
+// progress, on hold and completed. New todos start out active. Completed
+// todos can only be archived through an update; bringing them back needs
+// the explicit reopen action.
+func DefaultWorkflow() Workflow {
+    active := []models.Status{
+        models.StatusNotStarted,
+        models.StatusInProgress,
+        models.StatusOnHold,
+    }
+    return Workflow{
+        Initial: active,
+        Transitions: map[models.Status][]models.Status{
+            models.StatusNotStarted: {models.StatusInProgress, models.StatusOnHold, models.StatusCompleted},
+            models.StatusInProgress: {models.StatusNotStarted, models.StatusOnHold, models.StatusCompleted},
+            models.StatusOnHold:     {models.StatusNotStarted, models.StatusInProgress, models.StatusCompleted},
+            models.StatusCompleted:  {models.StatusArchived},
+        },
+        Actions: map[string]Action{
+            "start":    {From: []models.Status{models.StatusNotStarted, models.StatusOnHold}, To: models.StatusInProgress},
+            "hold":     {From: []models.Status{models.StatusNotStarted, models.StatusInProgress}, To: models.StatusOnHold},
+            "complete": {From: active, To: models.StatusCompleted},
+            "archive":  {From: []models.Status{models.StatusCompleted}, To: models.StatusArchived},
+            "reopen":   {From: []models.Status{models.StatusCompleted, models.StatusArchived}, To: models.StatusInProgress},
+        },
+    }
+}
+
+// Allows reports whether an ordinary update may change a todo's status
+// from one value to another.
+func (w Workflow) Allows(from, to models.Status) bool {
+    if from == to {
+        return true
+    }
+    return containsStatus(w.Transitions[from], to)
+}
+
+// AllowsInitial reports whether a todo may be created with the status.
+func (w Workflow) AllowsInitial(status models.Status) bool {
+    return len(w.Initial) == 0 || containsStatus(w.Initial, status)
+}
+
+// stampStatus records when a todo was completed or archived. Reopening a
+// todo clears both timestamps; archiving keeps the completion time.
+func stampStatus(t *models.Todo, previous models.Status, now time.Time) {
+    if t.Status == previous {
+        return
+    }
+    switch t.Status {
+        case models.StatusCompleted:
+            t.CompletedAt = &now
+            t.ArchivedAt = nil
+        case models.StatusArchived:
+            if t.CompletedAt == nil {
+                t.CompletedAt = &now
+            }
+            t.ArchivedAt = &now
+        default:
+            t.CompletedAt = nil
+            t.ArchivedAt = nil
+    }
+}
+
+// TransitionTodo performs the named workflow action on a todo.
+func (s *TodoService) TransitionTodo(ctx context.Context, id, action string, opts WriteOptions) (models.Todo, error) {
+    a, ok := s.workflow.Actions[action]
+    if !ok {
+        return models.Todo{}, fmt.Errorf("%w: %q", ErrUnknownAction, action)
+    }
+    return s.commit(ctx, id, opts, func(existing models.Todo) (models.Todo, error) {
+        if !containsStatus(a.From, existing.Status) {
+            return models.Todo{}, fmt.Errorf("%w: cannot %s a todo that is %s", ErrInvalidTransition, action, existing.Status)
+        }
+        existing.Status = a.To
+        return existing, nil
+    }, false)
+}
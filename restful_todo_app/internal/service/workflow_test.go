@@ -0,0 +1,184 @@
+package service
+
+import (
+    "context"
+    "errors"
+    "testing"
+    "time"
+
+    "github.com/read-my-name/restful_todo_app/pkg/models"
+)
+
+var allStatuses = []models.Status{
+    models.StatusNotStarted,
+    models.StatusInProgress,
+    models.StatusOnHold,
+    models.StatusCompleted,
+    models.StatusArchived,
+}
+
+// todoIn adds a todo and moves it to status through transition actions.
+func todoIn(t *testing.T, svc *TodoService, status models.Status) models.Todo {
+    t.Helper()
+    todo := addTestTodo(t, svc, "todo "+string(status))
+    var actions []string
+    switch status {
+        case models.StatusInProgress:
+            actions = []string{"start"}
+        case models.StatusOnHold:
+            actions = []string{"hold"}
+        case models.StatusCompleted:
+            actions = []string{"complete"}
+        case models.StatusArchived:
+            actions = []string{"complete", "archive"}
+    }
+    for _, action := range actions {
+        var err error
+        if todo, err = svc.TransitionTodo(context.Background(), todo.ID, action, WriteOptions{}); err != nil {
+            t.Fatal(err)
+        }
+    }
+    if todo.Status != status {
+        t.Fatalf("todo is %s, want %s", todo.Status, status)
+    }
+    return todo
+}
+
+func TestWorkflowAllows(t *testing.T) {
+    allowed := map[models.Status][]models.Status{
+        models.StatusNotStarted: {models.StatusInProgress, models.StatusOnHold, models.StatusCompleted},
+        models.StatusInProgress: {models.StatusNotStarted, models.StatusOnHold, models.StatusCompleted},
+        models.StatusOnHold:     {models.StatusNotStarted, models.StatusInProgress, models.StatusCompleted},
+        models.StatusCompleted:  {models.StatusArchived},
+        models.StatusArchived:   nil,
+    }
+    w := DefaultWorkflow()
+    for _, from := range allStatuses {
+        for _, to := range allStatuses {
+            want := from == to || containsStatus(allowed[from], to)
+            if got := w.Allows(from, to); got != want {
+                t.Errorf("Allows(%s, %s) = %v, want %v", from, to, got, want)
+            }
+        }
+    }
+}
+
+func TestAddTodoInitialStatus(t *testing.T) {
+    svc := newTestService(t)
+    ctx := context.Background()
+    for _, status := range allStatuses {
+        todo := newTestTodo("new", time.Now().Add(time.Hour))
+        todo.Status = status
+        _, err := svc.AddTodo(ctx, todo, WriteOptions{})
+        switch status {
+            case models.StatusCompleted, models.StatusArchived:
+                if !errors.Is(err, ErrInvalidTransition) || !errors.Is(err, ErrConflict) {
+                    t.Errorf("creating a todo as %s got %v", status, err)
+                }
+            default:
+                if err != nil {
+                    t.Errorf("creating a todo as %s got %v", status, err)
+                }
+        }
+    }
+
+    // A workflow without initial statuses allows any.
+    svc = newTestService(t, WithWorkflow(Workflow{Transitions: DefaultWorkflow().Transitions}))
+    todo := newTestTodo("done already", time.Now().Add(time.Hour))
+    todo.Status = models.StatusCompleted
+    created, err := svc.AddTodo(ctx, todo, WriteOptions{})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if created.CompletedAt == nil {
+        t.Error("a todo created as completed has no completed_at")
+    }
+}
+
+func TestTransitionTodo(t *testing.T) {
+    ctx := context.Background()
+    for name, action := range DefaultWorkflow().Actions {
+        for _, from := range allStatuses {
+            svc := newTestService(t)
+            todo := todoIn(t, svc, from)
+            got, err := svc.TransitionTodo(ctx, todo.ID, name, WriteOptions{})
+            if containsStatus(action.From, from) {
+                if err != nil || got.Status != action.To {
+                    t.Errorf("%s from %s: got %s, %v", name, from, got.Status, err)
+                }
+                continue
+            }
+            if !errors.Is(err, ErrInvalidTransition) || !errors.Is(err, ErrConflict) {
+                t.Errorf("%s from %s: got %v, want an invalid transition", name, from, err)
+            }
+            if current, _ := svc.GetTodo(todo.ID); current.Status != from || current.Version != todo.Version {
+                t.Errorf("%s from %s: a refused action changed the todo to %+v", name, from, current)
+            }
+        }
+    }
+
+    svc := newTestService(t)
+    todo := addTestTodo(t, svc, "unknown action")
+    if _, err := svc.TransitionTodo(ctx, todo.ID, "destroy", WriteOptions{}); !errors.Is(err, ErrUnknownAction) {
+        t.Errorf("an unknown action got %v", err)
+    }
+    if _, err := svc.TransitionTodo(ctx, "missing", "start", WriteOptions{}); !errors.Is(err, ErrNotFound) {
+        t.Errorf("an action on a missing todo got %v", err)
+    }
+}
+
+func TestTransitionStamps(t *testing.T) {
+    svc := newTestService(t)
+    ctx := context.Background()
+    completed := todoIn(t, svc, models.StatusCompleted)
+    if completed.CompletedAt == nil || completed.ArchivedAt != nil {
+        t.Fatalf("completed todo has completed_at %v, archived_at %v", completed.CompletedAt, completed.ArchivedAt)
+    }
+
+    archived, err := svc.TransitionTodo(ctx, completed.ID, "archive", WriteOptions{})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if archived.CompletedAt == nil || !archived.CompletedAt.Equal(*completed.CompletedAt) || archived.ArchivedAt == nil {
+        t.Fatalf("archiving did not keep completed_at: %v, archived_at %v", archived.CompletedAt, archived.ArchivedAt)
+    }
+
+    reopened, err := svc.TransitionTodo(ctx, archived.ID, "reopen", WriteOptions{})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if reopened.CompletedAt != nil || reopened.ArchivedAt != nil {
+        t.Fatalf("reopening kept completed_at %v, archived_at %v", reopened.CompletedAt, reopened.ArchivedAt)
+    }
+}
+
+func TestStampStatus(t *testing.T) {
+    now := time.Now()
+    earlier := now.Add(-time.Hour)
+    tests := []struct {
+        name          string
+        from, to      models.Status
+        completedAt   *time.Time
+        wantCompleted *time.Time
+        wantArchived  bool
+    }{
+        {"complete", models.StatusInProgress, models.StatusCompleted, nil, &now, false},
+        {"archive keeps completed_at", models.StatusCompleted, models.StatusArchived, &earlier, &earlier, true},
+        {"archive without completed_at", models.StatusCompleted, models.StatusArchived, nil, &now, true},
+        {"reopen", models.StatusArchived, models.StatusInProgress, &earlier, nil, false},
+        {"unchanged", models.StatusCompleted, models.StatusCompleted, &earlier, &earlier, false},
+    }
+    for _, tt := range tests {
+        todo := models.Todo{Status: tt.to, CompletedAt: tt.completedAt}
+        if tt.from == models.StatusArchived {
+            todo.ArchivedAt = &earlier
+        }
+        stampStatus(&todo, tt.from, now)
+        if (todo.CompletedAt == nil) != (tt.wantCompleted == nil) || todo.CompletedAt != nil && !todo.CompletedAt.Equal(*tt.wantCompleted) {
+            t.Errorf("%s: completed_at is %v, want %v", tt.name, todo.CompletedAt, tt.wantCompleted)
+        }
+        if (todo.ArchivedAt != nil) != tt.wantArchived {
+            t.Errorf("%s: archived_at is %v", tt.name, todo.ArchivedAt)
+        }
+    }
+}
@@ -0,0 +1,255 @@
+package storage
+
+import (
+    "encoding/json"
+    "fmt"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/read-my-name/restful_todo_app/pkg/models"
+)
+
+// csvVersion is the version of the CSV layout written by CSVStorage.
+// Adding a field to models.Todo means adding a version: list its columns in
+// csvColumns, add a migration from the previous version to csvMigrations,
+// and read and write the new columns in decodeRow and encodeRecord.
+const csvVersion = 6
+
+// csvVersionPrefix starts the first cell of a versioned header row, as in
+// "#v=6,id,title,...". The remaining cells name the columns.
+const csvVersionPrefix = "#v="
+
+// csvColumns lists the columns of each version. Every version extends the
+// one before it.
+var csvColumns = func() map[int][]string {
+    v1 := []string{"id", "title", "description", "status", "priority", "due_date", "created_at", "updated_at", "labels"}
+    v2 := append(v1[:len(v1):len(v1)], "subtasks")
+    v3 := append(v2[:len(v2):len(v2)], "completed_at", "archived_at")
+    v4 := append(v3[:len(v3):len(v3)], "version")
+    v5 := append(v4[:len(v4):len(v4)], "recurrence")
+    v6 := append(v5[:len(v5):len(v5)], "reminders")
+    return map[int][]string{1: v1, 2: v2, 3: v3, 4: v4, 5: v5, 6: v6}
+}()
+
+// csvMigrations upgrade a row of version v, keyed by v, to version v+1.
+// Columns already present are left alone, so rows written by a newer
+// version pass through unharmed.
+var csvMigrations = map[int]func(row map[string]string){
+    // Version 2 added subtasks; older todos have none.
+    1: func(row map[string]string) {
+        setDefault(row, "subtasks", "")
+    },
+    // Version 3 added completion and archive times. For todos that were
+    // already done, the last update is the best estimate we have.
+    2: func(row map[string]string) {
+        status := models.Status(row["status"])
+        done := status == models.StatusCompleted || status == models.StatusArchived
+        if _, ok := row["completed_at"]; !ok {
+            row["completed_at"] = ""
+            if done {
+                row["completed_at"] = row["updated_at"]
+            }
+        }
+        if _, ok := row["archived_at"]; !ok {
+            row["archived_at"] = ""
+            if status == models.StatusArchived {
+                row["archived_at"] = row["updated_at"]
+            }
+        }
+    },
+    // Version 4 added the version counter; every todo starts at 1.
+    3: func(row map[string]string) {
+        setDefault(row, "version", "1")
+    },
+    // Version 5 added recurrence rules; older todos do not repeat.
+    4: func(row map[string]string) {
+        setDefault(row, "recurrence", "")
+    },
+    // Version 6 added reminder offsets.
+    5: func(row map[string]string) {
+        setDefault(row, "reminders", "")
+    },
+}
+
+func setDefault(row map[string]string, column, value string) {
+    if _, ok := row[column]; !ok {
+        row[column] = value
+    }
+}
+
+// migrateRow brings a row of the given version up to csvVersion. Rows of
+// newer versions are left as they are.
+func migrateRow(row map[string]string, version int) error {
+    for v := version; v < csvVersion; v++ {
+        migrate, ok := csvMigrations[v]
+        if !ok {
+            return fmt.Errorf("no migration from CSV version %d", v)
+        }
+        migrate(row)
+    }
+    return nil
+}
+
+// csvLayout describes how the rows of a file map to columns.
+type csvLayout struct {
+    version int
+    columns []string // nil for headerless files, where the row length decides
+    extra   []string // columns unknown to this version, in file order
+}
+
+// parseHeader reads the layout from the first row of a file. ok is false
+// when the row is a todo rather than a header.
+func parseHeader(record []string) (layout csvLayout, ok bool, err error) {
+    if len(record) == 0 {
+        return csvLayout{}, false, nil
+    }
+    switch {
+        case strings.HasPrefix(record[0], csvVersionPrefix):
+            version, err := strconv.Atoi(strings.TrimPrefix(record[0], csvVersionPrefix))
+            if err != nil || version < 1 {
+                return csvLayout{}, true, fmt.Errorf("invalid CSV version %q", record[0])
+            }
+            layout = csvLayout{version: version, columns: record[1:]}
+        case record[0] == "id":
+            // Unversioned header written before versions were recorded.
+            layout = csvLayout{version: 3, columns: record}
+        default:
+            return csvLayout{}, false, nil
+    }
+
+    seen := make(map[string]bool, len(layout.columns))
+    for _, column := range layout.columns {
+        if seen[column] {
+            return csvLayout{}, true, fmt.Errorf("duplicate CSV column %q", column)
+        }
+        seen[column] = true
+    }
+    known := csvColumns[min(layout.version, csvVersion)]
+    for _, column := range known {
+        if !seen[column] {
+            return csvLayout{}, true, fmt.Errorf("CSV version %d is missing column %q", layout.version, column)
+        }
+    }
+    for _, column := range layout.columns {
+        if !containsString(csvColumns[csvVersion], column) {
+            layout.extra = append(layout.extra, column)
+        }
+    }
+    return layout, true, nil
+}
+
+// rowOf maps a record to its columns. Headerless files mix the 9, 10 and
+// 12 column rows of versions 1 to 3, so their version is taken from the
+// length of each row. Journal records are read the same way.
+func (l csvLayout) rowOf(record []string) (map[string]string, int, error) {
+    columns, version := l.columns, l.version
+    if columns == nil {
+        switch {
+            case len(record) >= 15:
+                version = 6
+            case len(record) >= 14:
+                version = 5
+            case len(record) >= 13:
+                version = 4
+            case len(record) >= 12:
+                version = 3
+            case len(record) >= 10:
+                version = 2
+            case len(record) >= 9:
+                version = 1
+            default:
+                return nil, 0, fmt.Errorf("expected at least 9 columns, got %d", len(record))
+        }
+        columns = csvColumns[version]
+    } else if len(record) != len(columns) {
+        return nil, 0, fmt.Errorf("expected %d columns, got %d", len(columns), len(record))
+    }
+
+    row := make(map[string]string, len(columns))
+    for i, column := range columns {
+        row[column] = record[i]
+    }
+    return row, version, nil
+}
+
+// columnError reports a column whose value could not be parsed.
+type columnError struct {
+    column string
+    err    error
+}
+
+func (e *columnError) Error() string {
+    return fmt.Sprintf("invalid %s: %v", e.column, e.err)
+}
+
+func (e *columnError) Unwrap() error {
+    return e.err
+}
+
+// decodeRow converts a row of the current version into a todo. A value
+// that does not parse is reported as a *columnError rather than zeroed.
+func decodeRow(row map[string]string) (models.Todo, error) {
+    todo := models.Todo{
+        ID:          row["id"],
+        Title:       row["title"],
+        Description: row["description"],
+        Status:      models.Status(row["status"]),
+        Priority:    models.Priority(row["priority"]),
+        Labels:      cleanStrings(strings.Split(row["labels"], "|")),
+    }
+
+    times := []struct {
+        column string
+        dst    *time.Time
+    }{
+        {"due_date", &todo.DueDate},
+        {"created_at", &todo.CreatedAt},
+        {"updated_at", &todo.UpdatedAt},
+    }
+    for _, tc := range times {
+        t, err := time.Parse(time.RFC3339, row[tc.column])
+        if err != nil {
+            return models.Todo{}, &columnError{column: tc.column, err: err}
+        }
+        *tc.dst = t
+    }
+
+    if row["subtasks"] != "" {
+        if err := json.Unmarshal([]byte(row["subtasks"]), &todo.Subtasks); err != nil {
+            return models.Todo{}, &columnError{column: "subtasks", err: err}
+        }
+    }
+
+    var err error
+    if todo.CompletedAt, err = parseOptionalTime(row["completed_at"]); err != nil {
+        return models.Todo{}, &columnError{column: "completed_at", err: err}
+    }
+    if todo.ArchivedAt, err = parseOptionalTime(row["archived_at"]); err != nil {
+        return models.Todo{}, &columnError{column: "archived_at", err: err}
+    }
+    if todo.Version, err = strconv.Atoi(row["version"]); err != nil {
+        return models.Todo{}, &columnError{column: "version", err: err}
+    }
+    todo.Recurrence = row["recurrence"]
+    todo.Reminders = cleanStrings(strings.Split(row["reminders"], "|"))
+
+    return todo, nil
+}
+
+// headerRow returns the header for the current version, followed by the
+// extra columns carried over from a newer file.
+func headerRow(extra []string) []string {
+    header := []string{csvVersionPrefix + strconv.Itoa(csvVersion)}
+    header = append(header, csvColumns[csvVersion]...)
+    return append(header, extra...)
+}
+
+func containsString(values []string, s string) bool {
+    for _, v := range values {
+        if v == s {
+            return true
+        }
+    }
+    return false
+}
\ No newline at end of file
@@ -1,166 +1,864 @@
 package storage
 
 import (
+    "bytes"
     "encoding/csv"
     "encoding/json"
+    "errors"
     "fmt"
+    "io"
     "os"
     "path/filepath"
+    "runtime"
+    "strconv"
     "strings"
     "sync"
     "time"
-    
+
     "github.com/read-my-name/restful_todo_app/pkg/models"
 )
 
+// LoadMode decides what CSVStorage does with rows it cannot read.
+type LoadMode string
+
+const (
+    // StrictLoad fails the load, reporting every bad row.
+    StrictLoad LoadMode = "strict"
+    // LenientLoad skips bad rows and moves them to the rejects file.
+    LenientLoad LoadMode = "lenient"
+)
+
+// defaultCompactEvery is how many journal entries CSVStorage collects
+// before folding them into the snapshot.
+const defaultCompactEvery = 100
+
+// CSVStorage keeps todos in a CSV snapshot file whose header row records
+// the layout version and column names (see csv_schema.go). Writes are
+// appended to a journal next to it and synced before they return; every
+// so many writes, and on Close, the journal is compacted into a new
+// snapshot that atomically replaces the old one. Snapshots written by
+// older versions, with or without a header, are migrated as they are read
+// and rewritten in the current layout on the next compaction.
+//
+// Every operation holds an advisory lock on a lock file next to the
+// snapshot, so several processes can share the files. Reads share the
+// lock with each other; writes, and reads that must reload the files,
+// hold it alone.
 type CSVStorage struct {
-    filePath string
-    mu       sync.RWMutex
+    filePath     string
+    mode         LoadMode
+    compactEvery int
+    lockTimeout  time.Duration
+    mu           sync.RWMutex
+    lock         *fileLock
+    rejected     []models.RejectedRow // rows quarantined since the storage was opened
+
+    // state is the snapshot with the journal replayed on top, read on
+    // first use; pending counts the journal entries it includes. The
+    // stamps record the files as state saw them, to notice when another
+    // process has written to them since. generation counts the times
+    // state has been read from the files.
+    state         *csvFile
+    journal       *journal
+    pending       int
+    snapshotStamp fileStamp
+    journalStamp  fileStamp
+    generation    uint64
+}
+
+// CSVOption configures a CSVStorage.
+type CSVOption func(*CSVStorage)
+
+// WithLoadMode sets how rows that cannot be read are handled. The default
+// is LenientLoad.
+func WithLoadMode(mode LoadMode) CSVOption {
+    return func(s *CSVStorage) {
+        s.mode = mode
+    }
+}
+
+// WithCompactEvery sets how many writes are journaled before the journal
+// is compacted into the snapshot.
+func WithCompactEvery(n int) CSVOption {
+    return func(s *CSVStorage) {
+        if n > 0 {
+            s.compactEvery = n
+        }
+    }
+}
+
+// WithLockTimeout sets how long to wait for another process that holds
+// the lock file before failing with ErrLockTimeout.
+func WithLockTimeout(d time.Duration) CSVOption {
+    return func(s *CSVStorage) {
+        if d > 0 {
+            s.lockTimeout = d
+        }
+    }
 }
 
-func NewCSVStorage(filePath string) *CSVStorage {
-    return &CSVStorage{filePath: filePath}
+// csvFile is the content of a CSV file. Columns unknown to this version,
+// written by a newer one, are kept so they survive a rewrite.
+type csvFile struct {
+    layout csvLayout
+    todos  []models.Todo
+    extras []map[string]string // values of layout.extra, parallel to todos
+}
+
+func NewCSVStorage(filePath string, opts ...CSVOption) *CSVStorage {
+    s := &CSVStorage{filePath: filePath, mode: LenientLoad, compactEvery: defaultCompactEvery, lockTimeout: defaultLockTimeout}
+    for _, opt := range opts {
+        opt(s)
+    }
+    return s
+}
+
+// RejectsPath returns the file that rows skipped in lenient mode are moved
+// to: todos.csv keeps them in todos.rejects.csv.
+func (s *CSVStorage) RejectsPath() string {
+    return strings.TrimSuffix(s.filePath, filepath.Ext(s.filePath)) + ".rejects.csv"
+}
+
+// JournalPath returns the journal of writes made since the last
+// compaction: todos.csv keeps it in todos.journal.
+func (s *CSVStorage) JournalPath() string {
+    return strings.TrimSuffix(s.filePath, filepath.Ext(s.filePath)) + ".journal"
+}
+
+// LockPath returns the file whose lock guards the snapshot and journal:
+// todos.csv is guarded by todos.lock.
+func (s *CSVStorage) LockPath() string {
+    return strings.TrimSuffix(s.filePath, filepath.Ext(s.filePath)) + ".lock"
+}
+
+// Health reports the rows skipped since the storage was opened.
+func (s *CSVStorage) Health() models.StorageHealth {
+    s.mu.RLock()
+    defer s.mu.RUnlock()
+
+    health := models.StorageHealth{
+        Status:  "ok",
+        Backend: "csv",
+        Mode:    string(s.mode),
+        Skipped: len(s.rejected),
+        Rejects: append([]models.RejectedRow(nil), s.rejected...),
+    }
+    if len(s.rejected) > 0 {
+        health.Status = "degraded"
+        health.RejectsFile = s.RejectsPath()
+    }
+    return health
 }
 
 func (s *CSVStorage) Save(t models.Todo) error {
+    if err := s.acquire(); err != nil {
+        return err
+    }
+    defer s.release()
+
+    if err := s.ensureState(); err != nil {
+        return err
+    }
+    e, err := newJournalEntry(opAdd, t)
+    if err != nil {
+        return err
+    }
+    return s.write(e)
+}
+
+// SaveAll replaces every todo, writing a new snapshot and emptying the
+// journal. Values of unknown columns are kept for todos that are still
+// present.
+func (s *CSVStorage) SaveAll(todos []models.Todo) error {
+    if err := s.acquire(); err != nil {
+        return err
+    }
+    defer s.release()
+
+    f := csvFile{todos: todos, extras: make([]map[string]string, len(todos))}
+    // A file that cannot be read is replaced all the same.
+    if err := s.ensureState(); err == nil && len(s.state.layout.extra) > 0 {
+        byID := make(map[string]map[string]string, len(s.state.todos))
+        for i, t := range s.state.todos {
+            byID[t.ID] = s.state.extras[i]
+        }
+        f.layout.extra = s.state.layout.extra
+        for i, t := range todos {
+            f.extras[i] = byID[t.ID]
+        }
+    }
+    if err := s.openJournal(); err != nil {
+        return err
+    }
+    s.state = &f
+    return s.compact()
+}
+
+// Update replaces the todo with the given ID.
+func (s *CSVStorage) Update(id string, t models.Todo) error {
+    if err := s.acquire(); err != nil {
+        return err
+    }
+    defer s.release()
+
+    if err := s.ensureState(); err != nil {
+        return err
+    }
+    if !s.state.has(id) {
+        return fmt.Errorf("todo with ID %s not found", id)
+    }
+    t.ID = id
+    e, err := newJournalEntry(opUpdate, t)
+    if err != nil {
+        return err
+    }
+    return s.write(e)
+}
+
+// Delete removes the todo with the given ID.
+func (s *CSVStorage) Delete(id string) error {
+    if err := s.acquire(); err != nil {
+        return err
+    }
+    defer s.release()
+
+    if err := s.ensureState(); err != nil {
+        return err
+    }
+    if !s.state.has(id) {
+        return fmt.Errorf("todo with ID %s not found", id)
+    }
+    return s.write(journalEntry{Op: opDelete, ID: id})
+}
+
+func (s *CSVStorage) Load() ([]models.Todo, error) {
+    if err := s.acquireShared(); err != nil {
+        return nil, err
+    }
+    defer s.release()
+
+    if err := s.ensureState(); err != nil {
+        return nil, err
+    }
+    return append([]models.Todo{}, s.state.todos...), nil
+}
+
+// Generation returns a number that changes whenever the todos have been
+// read from the files again, which they are once another process has
+// written to them. A caller holding todos loaded at one generation should
+// load them again when it sees another.
+func (s *CSVStorage) Generation() (uint64, error) {
+    if err := s.acquireShared(); err != nil {
+        return 0, err
+    }
+    defer s.release()
+
+    if err := s.ensureState(); err != nil {
+        return 0, err
+    }
+    return s.generation, nil
+}
+
+// Recover rereads the snapshot, replays the journal on top of it and
+// compacts the result, returning how many journal entries were replayed.
+// A write torn by a crash is discarded.
+func (s *CSVStorage) Recover() (int, error) {
+    if err := s.acquire(); err != nil {
+        return 0, err
+    }
+    defer s.release()
+
+    s.reset()
+    if err := s.ensureState(); err != nil {
+        return 0, err
+    }
+    replayed := s.pending
+    if replayed == 0 {
+        return 0, nil
+    }
+    return replayed, s.compact()
+}
+
+// Migrate rewrites the snapshot in the current layout and returns the
+// version it had before, 0 for a file without a header. Files written by
+// a newer version are left alone.
+func (s *CSVStorage) Migrate() (int, error) {
+    if err := s.acquire(); err != nil {
+        return 0, err
+    }
+    defer s.release()
+
+    if err := s.ensureState(); err != nil {
+        return 0, err
+    }
+    version := s.state.layout.version
+    if version > csvVersion {
+        return version, fmt.Errorf("file has CSV version %d, newer than %d", version, csvVersion)
+    }
+    return version, s.compact()
+}
+
+// Close compacts the journal and closes it, along with the lock file.
+func (s *CSVStorage) Close() error {
     s.mu.Lock()
     defer s.mu.Unlock()
 
-    // Create directory if not exists
-    dir := filepath.Dir(s.filePath)
-    if err := os.MkdirAll(dir, 0755); err != nil {
-        return fmt.Errorf("failed to create directory: %w", err)
+    if s.lock == nil {
+        return nil
+    }
+    if err := s.lockFiles(true); err != nil {
+        return err
+    }
+    var err error
+    if s.state != nil && s.pending > 0 {
+        err = s.compact()
+    }
+    if s.journal != nil {
+        err = errors.Join(err, s.journal.close())
+        s.journal = nil
+    }
+    s.state = nil
+    err = errors.Join(err, s.lock.unlock(), s.lock.close())
+    s.lock = nil
+    return err
+}
+
+// acquire locks s against other goroutines and, through the lock file,
+// other processes.
+func (s *CSVStorage) acquire() error {
+    return s.acquireLock(true)
+}
+
+// acquireShared is acquire for reads, which other processes may make at
+// the same time.
+func (s *CSVStorage) acquireShared() error {
+    return s.acquireLock(false)
+}
+
+func (s *CSVStorage) acquireLock(exclusive bool) error {
+    s.mu.Lock()
+    if err := s.lockFiles(exclusive); err != nil {
+        s.mu.Unlock()
+        return err
+    }
+    return nil
+}
+
+// lockFiles takes the lock file and drops the state if another process
+// changed the snapshot or journal since this one last touched them. A
+// shared lock is only kept while the state is current: reading the files
+// again may repair them, so it takes the lock exclusively. Callers must
+// hold s.mu for writing.
+func (s *CSVStorage) lockFiles(exclusive bool) error {
+    if s.lock == nil {
+        if err := os.MkdirAll(filepath.Dir(s.filePath), 0755); err != nil {
+            return fmt.Errorf("failed to create directory: %w", err)
+        }
+        lock, err := openFileLock(s.LockPath())
+        if err != nil {
+            return err
+        }
+        s.lock = lock
+    }
+    if !exclusive {
+        if err := s.lock.lockShared(s.lockTimeout); err != nil {
+            return err
+        }
+        if s.state != nil && !s.stale() {
+            return nil
+        }
+        // Not every platform can turn a shared lock into an exclusive one.
+        if err := s.lock.unlock(); err != nil {
+            return err
+        }
+    }
+    if err := s.lock.lock(s.lockTimeout); err != nil {
+        return err
+    }
+    if s.state != nil && s.stale() {
+        s.reset()
+    }
+    return nil
+}
+
+// stale reports whether the snapshot or journal differs from the stamps,
+// keeping the stamps refreshed otherwise. A file that cannot be checked is
+// stale, so that reading it again reports the error.
+func (s *CSVStorage) stale() bool {
+    for _, f := range []struct {
+        path  string
+        stamp *fileStamp
+    }{
+        {s.filePath, &s.snapshotStamp},
+        {s.JournalPath(), &s.journalStamp},
+    } {
+        stamp, changed, err := f.stamp.check(f.path)
+        if changed || err != nil {
+            return true
+        }
+        *f.stamp = stamp
     }
+    return false
+}
+
+// release records the snapshot and journal as this process left them and
+// unlocks s.
+func (s *CSVStorage) release() {
+    if s.state != nil {
+        if err := s.restamp(); err != nil {
+            s.reset()
+        }
+    }
+    if err := s.lock.unlock(); err != nil {
+        // Closing the file releases the lock all the same.
+        s.lock.close()
+        s.lock = nil
+    }
+    s.mu.Unlock()
+}
 
-    file, err := os.OpenFile(s.filePath, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0600)
+func (s *CSVStorage) restamp() error {
+    snapshot, err := stampFile(s.filePath, s.snapshotStamp)
     if err != nil {
-        return fmt.Errorf("failed to open file: %w", err)
+        return err
     }
-    defer file.Close()
+    journal, err := stampFile(s.JournalPath(), s.journalStamp)
+    if err != nil {
+        return err
+    }
+    s.snapshotStamp, s.journalStamp = snapshot, journal
+    return nil
+}
 
-    writer := csv.NewWriter(file)
-    defer writer.Flush()
+// reset drops the state, to be read again on next use.
+func (s *CSVStorage) reset() {
+    s.state = nil
+    if s.journal != nil {
+        s.journal.close()
+        s.journal = nil
+    }
+}
 
-    // Serialize subtasks to JSON
-    subtasksJSON, err := json.Marshal(t.Subtasks)
+// ensureState reads the snapshot and replays the journal on top of it,
+// unless that has been done already. Callers must hold s.mu for writing.
+func (s *CSVStorage) ensureState() error {
+    if s.state != nil {
+        return nil
+    }
+    f, err := s.load()
     if err != nil {
-        return fmt.Errorf("failed to marshal subtasks: %w", err)
+        return err
     }
 
-    return writer.Write([]string{
-        t.ID,
-        t.Title,
-        t.Description,
-        string(t.Status),
-        string(t.Priority),
-        t.DueDate.Format(time.RFC3339),
-        t.CreatedAt.Format(time.RFC3339),
-        t.UpdatedAt.Format(time.RFC3339),
-        strings.Join(t.Labels, "|"),
-        string(subtasksJSON),
-    })
+    entries, valid, err := readJournal(s.JournalPath())
+    if err != nil {
+        return fmt.Errorf("failed to replay %s: %w", s.JournalPath(), err)
+    }
+    for i, e := range entries {
+        if err := f.apply(e); err != nil {
+            return fmt.Errorf("failed to replay journal entry %d: %w", i+1, err)
+        }
+    }
+    if err := s.openJournal(); err != nil {
+        return err
+    }
+    if s.journal.size > valid {
+        // Drop the torn tail so new entries follow intact ones.
+        if err := s.journal.cut(valid); err != nil {
+            return fmt.Errorf("failed to repair journal: %w", err)
+        }
+    }
+
+    s.state = &f
+    s.pending = len(entries)
+    s.generation++
+    return nil
 }
 
-func (s *CSVStorage) SaveAll(todos []models.Todo) error {
-    s.mu.Lock()
-    defer s.mu.Unlock()
+func (s *CSVStorage) openJournal() error {
+    if s.journal != nil {
+        return nil
+    }
+    if err := os.MkdirAll(filepath.Dir(s.filePath), 0755); err != nil {
+        return fmt.Errorf("failed to create directory: %w", err)
+    }
+    j, err := openJournal(s.JournalPath())
+    if err != nil {
+        return err
+    }
+    // The journal may have just been created, and entries synced to it
+    // are only durable once its directory entry is.
+    if err := syncDir(filepath.Dir(s.filePath)); err != nil {
+        j.close()
+        return fmt.Errorf("failed to sync directory: %w", err)
+    }
+    s.journal = j
+    return nil
+}
+
+// write journals e, applies it to the state and compacts when enough
+// entries have piled up. Callers must hold s.mu for writing.
+func (s *CSVStorage) write(e journalEntry) error {
+    if err := s.journal.append(e); err != nil {
+        return err
+    }
+    if err := s.stampJournal(); err != nil {
+        return err
+    }
+    if err := s.state.apply(e); err != nil {
+        return err
+    }
+    s.pending++
+    if s.pending >= s.compactEvery {
+        return s.compact()
+    }
+    return nil
+}
+
+// compact writes the state as the new snapshot and empties the journal.
+// The journal is only emptied once the new snapshot is durable; a crash in
+// between leaves entries that the snapshot already contains, which replay
+// harmlessly. Callers must hold s.mu for writing.
+func (s *CSVStorage) compact() error {
+    if err := s.writeAll(*s.state); err != nil {
+        return err
+    }
+    s.state.layout.version = csvVersion
+    if err := s.journal.truncate(); err != nil {
+        return err
+    }
+    s.pending = 0
+    return s.stampJournal()
+}
+
+// stampJournal records the journal as the write just made left it, which
+// spares release reading it back.
+func (s *CSVStorage) stampJournal() error {
+    stamp, err := s.journal.stamp()
+    if err != nil {
+        return err
+    }
+    s.journalStamp = stamp
+    return nil
+}
+
+func (f *csvFile) has(id string) bool {
+    for _, t := range f.todos {
+        if t.ID == id {
+            return true
+        }
+    }
+    return false
+}
 
-    dir := filepath.Dir(s.filePath)
+// writeAll atomically replaces the file with the given content by writing
+// to a temporary file in the same directory and renaming it into place.
+// Callers must hold s.mu for writing.
+func (s *CSVStorage) writeAll(f csvFile) error {
+    return writeFileAtomic(s.filePath, func(w io.Writer) error {
+        return writeCSV(w, f)
+    })
+}
+
+// writeFileAtomic replaces path with what write produces, through a
+// synced temporary file in the same directory. The replacement is durable
+// once it returns.
+func writeFileAtomic(path string, write func(w io.Writer) error) error {
+    dir := filepath.Dir(path)
     if err := os.MkdirAll(dir, 0755); err != nil {
         return fmt.Errorf("failed to create directory: %w", err)
     }
 
-    file, err := os.Create(s.filePath)
+    tmp, err := os.CreateTemp(dir, filepath.Base(path)+".*.tmp")
     if err != nil {
-        return fmt.Errorf("failed to create file: %w", err)
+        return fmt.Errorf("failed to create temp file: %w", err)
     }
-    defer file.Close()
+    tmpPath := tmp.Name()
+    // Remove the temp file on any failure; after a successful rename this is a no-op.
+    defer os.Remove(tmpPath)
 
-    writer := csv.NewWriter(file)
-    defer writer.Flush()
+    if err := write(tmp); err != nil {
+        tmp.Close()
+        return err
+    }
 
-    for _, t := range todos {
-        subtasksJSON, err := json.Marshal(t.Subtasks)
-        if err != nil {
-            return fmt.Errorf("failed to marshal subtasks: %w", err)
-        }
-
-        err = writer.Write([]string{
-            t.ID,
-            t.Title,
-            t.Description,
-            string(t.Status),
-            string(t.Priority),
-            t.DueDate.Format(time.RFC3339),
-            t.CreatedAt.Format(time.RFC3339),
-            t.UpdatedAt.Format(time.RFC3339),
-            strings.Join(t.Labels, "|"),
-            string(subtasksJSON),
-        })
-        if err != nil {
-            return fmt.Errorf("failed to write record: %w", err)
-        }
+    if err := tmp.Sync(); err != nil {
+        tmp.Close()
+        return fmt.Errorf("failed to sync temp file: %w", err)
+    }
+    if err := tmp.Close(); err != nil {
+        return fmt.Errorf("failed to close temp file: %w", err)
+    }
+
+    if err := os.Rename(tmpPath, path); err != nil {
+        return fmt.Errorf("failed to replace file: %w", err)
+    }
+    // Until the directory is synced, a power loss can undo the rename.
+    if err := syncDir(dir); err != nil {
+        return fmt.Errorf("failed to sync directory: %w", err)
     }
     return nil
 }
 
-func (s *CSVStorage) Load() ([]models.Todo, error) {
-    s.mu.RLock()
-    defer s.mu.RUnlock()
+// syncDir makes the entries of dir durable, so that files created in it
+// or renamed into it survive a power loss. Tests replace it to simulate
+// one.
+var syncDir = func(dir string) error {
+    if runtime.GOOS == "windows" {
+        // Directories cannot be opened for syncing; NTFS journals renames.
+        return nil
+    }
+    d, err := os.Open(dir)
+    if err != nil {
+        return err
+    }
+    err = d.Sync()
+    return errors.Join(err, d.Close())
+}
 
-    file, err := os.Open(s.filePath)
+// load reads every todo from the snapshot. Rows that cannot be read fail the
+// load in strict mode; in lenient mode they are moved to the rejects file
+// and the file is rewritten without them, so they are reported only once.
+// Callers must hold s.mu for writing.
+func (s *CSVStorage) load() (csvFile, error) {
+    data, err := os.ReadFile(s.filePath)
     if err != nil {
         if os.IsNotExist(err) {
-            return []models.Todo{}, nil
+            return csvFile{layout: csvLayout{version: csvVersion}, todos: []models.Todo{}}, nil
+        }
+        return csvFile{}, fmt.Errorf("failed to open file: %w", err)
+    }
+
+    f := csvFile{todos: []models.Todo{}}
+    var bad []csvRecord
+    f.layout, err = scanCSV(data, func(rec csvRecord) {
+        if rec.err != nil {
+            bad = append(bad, rec)
+            return
         }
-        return nil, fmt.Errorf("failed to open file: %w", err)
+        f.todos = append(f.todos, rec.todo)
+        f.extras = append(f.extras, rec.extra)
+    })
+    if err != nil {
+        return csvFile{}, fmt.Errorf("failed to load %s: %w", s.filePath, err)
+    }
+    if len(bad) == 0 {
+        return f, nil
+    }
+
+    if s.mode == StrictLoad {
+        errs := make(LineErrors, len(bad))
+        for i, rec := range bad {
+            errs[i] = rec.lineError()
+        }
+        return csvFile{}, fmt.Errorf("failed to load %s: %w", s.filePath, errs)
+    }
+
+    if err := s.quarantine(bad); err != nil {
+        return csvFile{}, err
+    }
+    if err := s.writeAll(f); err != nil {
+        return csvFile{}, fmt.Errorf("failed to remove rejected rows: %w", err)
+    }
+    return f, nil
+}
+
+// quarantine appends bad rows, as they appeared in the file, to the
+// rejects file together with the reason they were skipped.
+func (s *CSVStorage) quarantine(bad []csvRecord) error {
+    file, err := os.OpenFile(s.RejectsPath(), os.O_APPEND|os.O_RDWR|os.O_CREATE, 0600)
+    if err != nil {
+        return fmt.Errorf("failed to open rejects file: %w", err)
     }
     defer file.Close()
 
-    reader := csv.NewReader(file)
-    records, err := reader.ReadAll()
+    info, err := file.Stat()
     if err != nil {
-        return nil, fmt.Errorf("failed to read CSV: %w", err)
+        return fmt.Errorf("failed to stat rejects file: %w", err)
     }
 
-    var todos []models.Todo
-    for idx, record := range records {
-        // Handle both old (9 columns) and new (10 columns) formats
-        if len(record) < 9 {
-            return nil, fmt.Errorf("invalid record at line %d: expected at least 9 columns, got %d", idx+1, len(record))
+    now := time.Now().UTC()
+    writer := csv.NewWriter(file)
+    if info.Size() == 0 {
+        writer.Write([]string{"rejected_at", "line", "column", "reason", "row"})
+    }
+    for _, rec := range bad {
+        lineErr := rec.lineError()
+        writer.Write([]string{
+            now.Format(time.RFC3339),
+            strconv.Itoa(lineErr.Line),
+            lineErr.Column,
+            lineErr.Err.Error(),
+            rec.raw,
+        })
+        s.rejected = append(s.rejected, models.RejectedRow{
+            Line:       lineErr.Line,
+            Column:     lineErr.Column,
+            Reason:     lineErr.Err.Error(),
+            RejectedAt: now,
+        })
+    }
+    writer.Flush()
+    if err := writer.Error(); err != nil {
+        return fmt.Errorf("failed to write rejects file: %w", err)
+    }
+    return file.Sync()
+}
+
+// csvRecord is one row of a CSV file: the todo it holds, or the error
+// that kept it from being read.
+type csvRecord struct {
+    line  int
+    raw   string // the row as it appears in the file
+    todo  models.Todo
+    extra map[string]string
+    err   error
+}
+
+func (rec csvRecord) lineError() *LineError {
+    lineErr := &LineError{Line: rec.line, Err: rec.err}
+    var colErr *columnError
+    if errors.As(rec.err, &colErr) {
+        lineErr.Column = colErr.column
+        lineErr.Err = colErr.err
+    }
+    return lineErr
+}
+
+// scanCSV reads the header, if any, and calls fn with every other row,
+// migrated to the current version. It returns an error only if the header
+// is invalid.
+func scanCSV(data []byte, fn func(rec csvRecord)) (csvLayout, error) {
+    reader := csv.NewReader(bytes.NewReader(data))
+    // Headerless files mix rows from before a column was added with newer
+    // ones, and the versioned header has one more cell than its rows.
+    reader.FieldsPerRecord = -1
+
+    var layout csvLayout
+    for first := true; ; first = false {
+        start := reader.InputOffset()
+        record, err := reader.Read()
+        if err == io.EOF {
+            return layout, nil
         }
+        rec := csvRecord{raw: strings.TrimRight(string(data[start:reader.InputOffset()]), "\r\n")}
 
-        dueDate, _ := time.Parse(time.RFC3339, record[5])
-        createdAt, _ := time.Parse(time.RFC3339, record[6])
-        updatedAt, _ := time.Parse(time.RFC3339, record[7])
+        var parseErr *csv.ParseError
+        if errors.As(err, &parseErr) {
+            rec.line, rec.err = parseErr.StartLine, parseErr.Err
+            fn(rec)
+            continue
+        }
+        if err != nil {
+            return layout, fmt.Errorf("failed to read CSV: %w", err)
+        }
+        rec.line, _ = reader.FieldPos(0)
+
+        if first {
+            header, ok, err := parseHeader(record)
+            if err != nil {
+                return layout, &LineError{Line: rec.line, Err: err}
+            }
+            if ok {
+                layout = header
+                continue
+            }
+        }
 
-        todo := models.Todo{
-            ID:          record[0],
-            Title:       record[1],
-            Description: record[2],
-            Status:      models.Status(record[3]),
-            Priority:    models.Priority(record[4]),
-            DueDate:     dueDate,
-            CreatedAt:   createdAt,
-            UpdatedAt:   updatedAt,
-            Labels:      cleanStrings(strings.Split(record[8], "|")),
+        row, version, err := layout.rowOf(record)
+        if err == nil {
+            err = migrateRow(row, version)
+        }
+        if err != nil {
+            rec.err = err
+            fn(rec)
+            continue
+        }
+        rec.todo, rec.err = decodeRow(row)
+        if len(layout.extra) > 0 {
+            rec.extra = make(map[string]string, len(layout.extra))
+            for _, column := range layout.extra {
+                rec.extra[column] = row[column]
+            }
         }
+        fn(rec)
+    }
+}
 
-        // Handle subtasks (column 9) if present
-        if len(record) >= 10 {
-            var subtasks []models.Subtask
-            if record[9] != "" {
-                if err := json.Unmarshal([]byte(record[9]), &subtasks); err != nil {
-                    return nil, fmt.Errorf("failed to unmarshal subtasks at line %d: %w", idx+1, err)
-                }
+// writeCSV writes a header for the current version and every todo.
+func writeCSV(w io.Writer, f csvFile) error {
+    writer := csv.NewWriter(w)
+    if err := writer.Write(headerRow(f.layout.extra)); err != nil {
+        return fmt.Errorf("failed to write header: %w", err)
+    }
+    for i, t := range f.todos {
+        record, err := encodeRecord(t)
+        if err != nil {
+            return err
+        }
+        for _, column := range f.layout.extra {
+            var value string
+            if i < len(f.extras) {
+                value = f.extras[i][column]
             }
-            todo.Subtasks = subtasks
+            record = append(record, value)
+        }
+        if err := writer.Write(record); err != nil {
+            return fmt.Errorf("failed to write record: %w", err)
         }
+    }
+    writer.Flush()
+    if err := writer.Error(); err != nil {
+        return fmt.Errorf("failed to flush records: %w", err)
+    }
+    return nil
+}
 
-        todos = append(todos, todo)
+// encodeRecord converts a todo into its CSV columns.
+func encodeRecord(t models.Todo) ([]string, error) {
+    // Serialize subtasks to JSON
+    subtasksJSON, err := json.Marshal(t.Subtasks)
+    if err != nil {
+        return nil, fmt.Errorf("failed to marshal subtasks: %w", err)
     }
 
-    return todos, nil
+    return []string{
+        t.ID,
+        t.Title,
+        t.Description,
+        string(t.Status),
+        string(t.Priority),
+        t.DueDate.Format(time.RFC3339),
+        t.CreatedAt.Format(time.RFC3339),
+        t.UpdatedAt.Format(time.RFC3339),
+        strings.Join(t.Labels, "|"),
+        string(subtasksJSON),
+        formatOptionalTime(t.CompletedAt),
+        formatOptionalTime(t.ArchivedAt),
+        strconv.Itoa(t.Version),
+        t.Recurrence,
+        strings.Join(t.Reminders, "|"),
+    }, nil
+}
+
+// formatOptionalTime writes unset timestamps as an empty column.
+func formatOptionalTime(t *time.Time) string {
+    if t == nil {
+        return ""
+    }
+    return t.Format(time.RFC3339)
+}
+
+// parseOptionalTime reads a timestamp written by formatOptionalTime.
+func parseOptionalTime(value string) (*time.Time, error) {
+    if value == "" {
+        return nil, nil
+    }
+    t, err := time.Parse(time.RFC3339, value)
+    if err != nil {
+        return nil, err
+    }
+    return &t, nil
 }
 
 // Helper function to clean empty strings from slices
@@ -0,0 +1,420 @@
+package storage
+
+import (
+    "bytes"
+    "errors"
+    "os"
+    "path/filepath"
+    "reflect"
+    "testing"
+    "time"
+
+    "github.com/read-my-name/restful_todo_app/pkg/models"
+)
+
+func csvTestTodo(id string) models.Todo {
+    created := time.Date(2030, 1, 1, 9, 0, 0, 0, time.UTC)
+    return mongoTestTodo(id, "Todo "+id, created)
+}
+
+// crash abandons s the way a killed process would: the journal is not
+// compacted and the files are left as they are.
+func crash(t *testing.T, s *CSVStorage) {
+    t.Helper()
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    s.reset()
+    if s.lock != nil {
+        s.lock.close()
+        s.lock = nil
+    }
+}
+
+func loadIDs(t *testing.T, s *CSVStorage) []string {
+    t.Helper()
+    todos, err := s.Load()
+    if err != nil {
+        t.Fatal(err)
+    }
+    var ids []string
+    for _, todo := range todos {
+        ids = append(ids, todo.ID+":"+todo.Title)
+    }
+    return ids
+}
+
+// writeTestJournal makes five writes that end with b renamed and a
+// deleted, and crashes before they are compacted. It returns the journal.
+func writeTestJournal(t *testing.T, path string) []byte {
+    t.Helper()
+    s := NewCSVStorage(path)
+    for _, id := range []string{"a", "b", "c"} {
+        if err := s.Save(csvTestTodo(id)); err != nil {
+            t.Fatal(err)
+        }
+    }
+    b := csvTestTodo("b")
+    b.Title = "Renamed"
+    if err := s.Update("b", b); err != nil {
+        t.Fatal(err)
+    }
+    if err := s.Delete("a"); err != nil {
+        t.Fatal(err)
+    }
+    crash(t, s)
+
+    if _, err := os.Stat(path); !os.IsNotExist(err) {
+        t.Fatalf("snapshot written before compaction: %v", err)
+    }
+    data, err := os.ReadFile(s.JournalPath())
+    if err != nil {
+        t.Fatal(err)
+    }
+    if n := bytes.Count(data, []byte("\n")); n != 5 {
+        t.Fatalf("journal has %d entries, want 5", n)
+    }
+    return data
+}
+
+var journalTestIDs = []string{"b:Renamed", "c:Todo c"}
+
+func TestCSVJournalReplayAfterReopen(t *testing.T) {
+    path := filepath.Join(t.TempDir(), "todos.csv")
+    writeTestJournal(t, path)
+
+    s := NewCSVStorage(path)
+    if ids := loadIDs(t, s); !reflect.DeepEqual(ids, journalTestIDs) {
+        t.Fatalf("reopened storage holds %v, want %v", ids, journalTestIDs)
+    }
+    crash(t, s)
+
+    s = NewCSVStorage(path)
+    replayed, err := s.Recover()
+    if err != nil {
+        t.Fatal(err)
+    }
+    if replayed != 5 {
+        t.Errorf("Recover replayed %d entries, want 5", replayed)
+    }
+    if info, err := os.Stat(s.JournalPath()); err != nil || info.Size() != 0 {
+        t.Errorf("journal not emptied by Recover: %v", err)
+    }
+    if err := s.Close(); err != nil {
+        t.Fatal(err)
+    }
+
+    // The snapshot alone now holds the writes.
+    if ids := loadIDs(t, NewCSVStorage(path)); !reflect.DeepEqual(ids, journalTestIDs) {
+        t.Fatalf("compacted snapshot holds %v, want %v", ids, journalTestIDs)
+    }
+}
+
+func TestCSVJournalTruncatedLastLine(t *testing.T) {
+    path := filepath.Join(t.TempDir(), "todos.csv")
+    data := writeTestJournal(t, path)
+    journalPath := NewCSVStorage(path).JournalPath()
+
+    // A crash in the middle of appending a sixth entry.
+    torn := append(append([]byte(nil), data...), data[:20]...)
+    if err := os.WriteFile(journalPath, torn, 0600); err != nil {
+        t.Fatal(err)
+    }
+
+    s := NewCSVStorage(path)
+    if ids := loadIDs(t, s); !reflect.DeepEqual(ids, journalTestIDs) {
+        t.Fatalf("storage with a torn journal holds %v, want %v", ids, journalTestIDs)
+    }
+    repaired, err := os.ReadFile(journalPath)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if !bytes.Equal(repaired, data) {
+        t.Fatal("the torn entry was not cut off the journal")
+    }
+
+    // New entries follow the intact ones.
+    if err := s.Save(csvTestTodo("d")); err != nil {
+        t.Fatal(err)
+    }
+    crash(t, s)
+    want := append(append([]string(nil), journalTestIDs...), "d:Todo d")
+    if ids := loadIDs(t, NewCSVStorage(path)); !reflect.DeepEqual(ids, want) {
+        t.Fatalf("after appending past a torn entry the storage holds %v, want %v", ids, want)
+    }
+}
+
+func TestCSVJournalChecksumMismatch(t *testing.T) {
+    path := filepath.Join(t.TempDir(), "todos.csv")
+    data := writeTestJournal(t, path)
+    journalPath := NewCSVStorage(path).JournalPath()
+    lines := bytes.SplitAfter(data, []byte("\n"))
+
+    corrupt := func(i int) []byte {
+        var out []byte
+        for j, line := range lines {
+            if j == i {
+                line = append([]byte("00000000"), line[8:]...)
+            }
+            out = append(out, line...)
+        }
+        return out
+    }
+
+    // In the middle of the journal, the damage cannot come from a torn
+    // write, so nothing is loaded.
+    if err := os.WriteFile(journalPath, corrupt(2), 0600); err != nil {
+        t.Fatal(err)
+    }
+    if _, err := NewCSVStorage(path).Load(); err == nil {
+        t.Fatal("a corrupt entry in the middle of the journal was accepted")
+    }
+
+    // A bad last entry is a torn write and is dropped: a is not deleted.
+    if err := os.WriteFile(journalPath, corrupt(4), 0600); err != nil {
+        t.Fatal(err)
+    }
+    want := []string{"a:Todo a", "b:Renamed", "c:Todo c"}
+    if ids := loadIDs(t, NewCSVStorage(path)); !reflect.DeepEqual(ids, want) {
+        t.Fatalf("storage with a bad last entry holds %v, want %v", ids, want)
+    }
+}
+
+func TestCSVCompactionCrashBeforeTruncate(t *testing.T) {
+    path := filepath.Join(t.TempDir(), "todos.csv")
+    writeTestJournal(t, path)
+
+    // Compact only halfway: the new snapshot is renamed into place, but
+    // the journal is never truncated.
+    s := NewCSVStorage(path)
+    if err := s.acquire(); err != nil {
+        t.Fatal(err)
+    }
+    if err := s.ensureState(); err != nil {
+        s.release()
+        t.Fatal(err)
+    }
+    err := s.writeAll(*s.state)
+    s.release()
+    if err != nil {
+        t.Fatal(err)
+    }
+    crash(t, s)
+
+    // Replaying entries the snapshot already holds changes nothing.
+    s = NewCSVStorage(path)
+    if ids := loadIDs(t, s); !reflect.DeepEqual(ids, journalTestIDs) {
+        t.Fatalf("storage after a crash during compaction holds %v, want %v", ids, journalTestIDs)
+    }
+    if _, err := s.Recover(); err != nil {
+        t.Fatal(err)
+    }
+    if ids := loadIDs(t, s); !reflect.DeepEqual(ids, journalTestIDs) {
+        t.Fatalf("recovered storage holds %v, want %v", ids, journalTestIDs)
+    }
+    if err := s.Close(); err != nil {
+        t.Fatal(err)
+    }
+}
+
+func TestCSVCompactEvery(t *testing.T) {
+    path := filepath.Join(t.TempDir(), "todos.csv")
+    s := NewCSVStorage(path, WithCompactEvery(2))
+    t.Cleanup(func() { s.Close() })
+
+    if err := s.Save(csvTestTodo("a")); err != nil {
+        t.Fatal(err)
+    }
+    if _, err := os.Stat(path); !os.IsNotExist(err) {
+        t.Fatal("compacted before the threshold")
+    }
+    if err := s.Save(csvTestTodo("b")); err != nil {
+        t.Fatal(err)
+    }
+    if info, err := os.Stat(s.JournalPath()); err != nil || info.Size() != 0 {
+        t.Fatalf("journal not emptied at the threshold: %v", err)
+    }
+    if ids := loadIDs(t, NewCSVStorage(path)); len(ids) != 2 {
+        t.Fatalf("snapshot holds %v", ids)
+    }
+}
+
+func TestCSVCompactionRenameNotDurable(t *testing.T) {
+    path := filepath.Join(t.TempDir(), "todos.csv")
+    s := NewCSVStorage(path, WithCompactEvery(2))
+    for _, id := range []string{"a", "b"} {
+        if err := s.Save(csvTestTodo(id)); err != nil {
+            t.Fatal(err)
+        }
+    }
+    before, err := os.ReadFile(path)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    // The power fails before the directory holding the renamed snapshot
+    // reaches the disk.
+    defer func(orig func(string) error) { syncDir = orig }(syncDir)
+    syncDir = func(string) error { return errors.New("power lost") }
+    if err := s.Save(csvTestTodo("c")); err != nil {
+        t.Fatal(err)
+    }
+    if err := s.Save(csvTestTodo("d")); err == nil {
+        t.Fatal("compaction succeeded without syncing the directory")
+    }
+    crash(t, s)
+    if info, err := os.Stat(s.JournalPath()); err != nil || info.Size() == 0 {
+        t.Fatalf("journal emptied before the new snapshot was durable: %v", err)
+    }
+
+    // After the reboot the rename is undone.
+    if err := os.WriteFile(path, before, 0600); err != nil {
+        t.Fatal(err)
+    }
+    syncDir = func(string) error { return nil }
+    want := []string{"a:Todo a", "b:Todo b", "c:Todo c", "d:Todo d"}
+    if ids := loadIDs(t, NewCSVStorage(path)); !reflect.DeepEqual(ids, want) {
+        t.Fatalf("storage after losing the rename holds %v, want %v", ids, want)
+    }
+}
+
+func TestCSVCompactionSyncsBeforeTruncate(t *testing.T) {
+    path := filepath.Join(t.TempDir(), "todos.csv")
+    s := NewCSVStorage(path, WithCompactEvery(2))
+    t.Cleanup(func() { s.Close() })
+
+    // Record how much of the journal is left each time the directory is
+    // synced.
+    var journalSizes []int64
+    defer func(orig func(string) error) { syncDir = orig }(syncDir)
+    syncDir = func(dir string) error {
+        if dir != filepath.Dir(path) {
+            t.Errorf("synced %s, want %s", dir, filepath.Dir(path))
+        }
+        info, err := os.Stat(s.JournalPath())
+        if err != nil {
+            return err
+        }
+        journalSizes = append(journalSizes, info.Size())
+        return nil
+    }
+    for _, id := range []string{"a", "b"} {
+        if err := s.Save(csvTestTodo(id)); err != nil {
+            t.Fatal(err)
+        }
+    }
+    // Once when the journal is created, and once after the rename.
+    if len(journalSizes) != 2 || journalSizes[1] == 0 {
+        t.Fatalf("journal sizes when the directory was synced: %v", journalSizes)
+    }
+}
+
+// holdLock takes the lock file of s the way another process would; flock
+// and LockFileEx locks belong to an open file, not to the process.
+func holdLock(t *testing.T, s *CSVStorage, exclusive bool) *fileLock {
+    t.Helper()
+    other, err := openFileLock(s.LockPath())
+    if err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(func() { other.close() })
+    if exclusive {
+        err = other.lock(time.Second)
+    } else {
+        err = other.lockShared(time.Second)
+    }
+    if err != nil {
+        t.Fatal(err)
+    }
+    return other
+}
+
+func TestCSVLockTimeout(t *testing.T) {
+    path := filepath.Join(t.TempDir(), "todos.csv")
+    s := NewCSVStorage(path, WithLockTimeout(50*time.Millisecond))
+    t.Cleanup(func() { s.Close() })
+    if err := s.Save(csvTestTodo("a")); err != nil {
+        t.Fatal(err)
+    }
+
+    other := holdLock(t, s, true)
+    start := time.Now()
+    if _, err := s.Load(); !errors.Is(err, ErrLockTimeout) {
+        t.Fatalf("Load: got %v, want a lock timeout", err)
+    }
+    if waited := time.Since(start); waited < 50*time.Millisecond || waited > time.Second {
+        t.Errorf("gave up after %s, want 50ms", waited)
+    }
+    if err := s.Save(csvTestTodo("b")); !errors.Is(err, ErrLockTimeout) {
+        t.Fatalf("Save: got %v, want a lock timeout", err)
+    }
+
+    if err := other.unlock(); err != nil {
+        t.Fatal(err)
+    }
+    if ids := loadIDs(t, s); !reflect.DeepEqual(ids, []string{"a:Todo a"}) {
+        t.Fatalf("storage holds %v after the lock was released", ids)
+    }
+}
+
+func TestCSVReadsShareLock(t *testing.T) {
+    path := filepath.Join(t.TempDir(), "todos.csv")
+    s := NewCSVStorage(path, WithLockTimeout(50*time.Millisecond))
+    t.Cleanup(func() { s.Close() })
+    if err := s.Save(csvTestTodo("a")); err != nil {
+        t.Fatal(err)
+    }
+
+    // Another reader does not hold up reads of current state, but does
+    // writes.
+    holdLock(t, s, false)
+    if ids := loadIDs(t, s); !reflect.DeepEqual(ids, []string{"a:Todo a"}) {
+        t.Fatalf("storage holds %v", ids)
+    }
+    if _, err := s.Generation(); err != nil {
+        t.Fatal(err)
+    }
+    if err := s.Save(csvTestTodo("b")); !errors.Is(err, ErrLockTimeout) {
+        t.Fatalf("Save: got %v, want a lock timeout", err)
+    }
+
+    // Reading the files again needs the lock alone.
+    fresh := NewCSVStorage(path, WithLockTimeout(50*time.Millisecond))
+    defer crash(t, fresh)
+    if _, err := fresh.Load(); !errors.Is(err, ErrLockTimeout) {
+        t.Fatalf("first Load: got %v, want a lock timeout", err)
+    }
+}
+
+func TestCSVJournalStampAfterWrite(t *testing.T) {
+    path := filepath.Join(t.TempDir(), "todos.csv")
+    s := NewCSVStorage(path, WithCompactEvery(3))
+    t.Cleanup(func() { s.Close() })
+
+    for _, id := range []string{"a", "b", "c", "d"} {
+        if err := s.Save(csvTestTodo(id)); err != nil {
+            t.Fatal(err)
+        }
+        // The stamp kept from the write matches a fresh one.
+        want, err := stampFile(s.JournalPath(), fileStamp{})
+        if err != nil {
+            t.Fatal(err)
+        }
+        got := s.journalStamp
+        if got.exists != want.exists || got.size != want.size || !got.modTime.Equal(want.modTime) || got.sum != want.sum {
+            t.Fatalf("after saving %s the journal is stamped %+v, want %+v", id, got, want)
+        }
+    }
+
+    // So is the stamp of a journal reopened with entries in it.
+    crash(t, s)
+    if err := s.Save(csvTestTodo("e")); err != nil {
+        t.Fatal(err)
+    }
+    want, err := stampFile(s.JournalPath(), fileStamp{})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if got := s.journalStamp; got.size != want.size || got.sum != want.sum {
+        t.Fatalf("after reopening the journal is stamped %+v, want %+v", got, want)
+    }
+}
@@ -0,0 +1,108 @@
+package storage
+
+import (
+    "bufio"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "strings"
+
+    "github.com/read-my-name/restful_todo_app/pkg/models"
+)
+
+// LineError reports a record of a CSV or JSON Lines file that could not
+// be decoded.
+type LineError struct {
+    Line   int
+    Column string // set when a single column was at fault
+    Err    error
+}
+
+func (e *LineError) Error() string {
+    if e.Column != "" {
+        return fmt.Sprintf("line %d, column %s: %v", e.Line, e.Column, e.Err)
+    }
+    return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+}
+
+func (e *LineError) Unwrap() error {
+    return e.Err
+}
+
+// LineErrors collects every bad record of a file, so that all of them can
+// be reported at once.
+type LineErrors []*LineError
+
+func (e LineErrors) Error() string {
+    msgs := make([]string, len(e))
+    for i, lineErr := range e {
+        msgs[i] = lineErr.Error()
+    }
+    return strings.Join(msgs, "; ")
+}
+
+// ScanCSV decodes the todos of a CSV file written by any version of
+// CSVStorage, and calls fn with each todo, or with the *LineError that
+// kept it from being read, and the line the record starts on. It only
+// returns an error when the file cannot be read or its header is invalid.
+func ScanCSV(r io.Reader, fn func(line int, t models.Todo, err error)) error {
+    data, err := io.ReadAll(r)
+    if err != nil {
+        return fmt.Errorf("failed to read CSV: %w", err)
+    }
+    _, err = scanCSV(data, func(rec csvRecord) {
+        if rec.err != nil {
+            fn(rec.line, models.Todo{}, rec.lineError())
+            return
+        }
+        fn(rec.line, rec.todo, nil)
+    })
+    return err
+}
+
+// WriteCSV writes todos in the current layout, after a header row.
+func WriteCSV(w io.Writer, todos []models.Todo) error {
+    return writeCSV(w, csvFile{todos: todos})
+}
+
+// ScanJSONL decodes a JSON Lines file with one todo per line, calling fn
+// as ScanCSV does. Blank lines are ignored.
+func ScanJSONL(r io.Reader, fn func(line int, t models.Todo, err error)) error {
+    scanner := bufio.NewScanner(r)
+    // Todos with many subtasks can exceed the default 64 KiB line limit.
+    scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+
+    for line := 1; scanner.Scan(); line++ {
+        text := strings.TrimSpace(scanner.Text())
+        if text == "" {
+            continue
+        }
+        var todo models.Todo
+        if err := json.Unmarshal([]byte(text), &todo); err != nil {
+            lineErr := &LineError{Line: line, Err: err}
+            var typeErr *json.UnmarshalTypeError
+            if errors.As(err, &typeErr) {
+                lineErr.Column = typeErr.Field
+            }
+            fn(line, models.Todo{}, lineErr)
+            continue
+        }
+        fn(line, todo, nil)
+    }
+    if err := scanner.Err(); err != nil {
+        return fmt.Errorf("failed to read JSON Lines: %w", err)
+    }
+    return nil
+}
+
+// WriteJSONL writes one todo per line.
+func WriteJSONL(w io.Writer, todos []models.Todo) error {
+    encoder := json.NewEncoder(w)
+    for _, t := range todos {
+        if err := encoder.Encode(t); err != nil {
+            return fmt.Errorf("failed to write todo %s: %w", t.ID, err)
+        }
+    }
+    return nil
+}
\ No newline at end of file
@@ -0,0 +1,72 @@
+package storage
+
+import (
+    "bytes"
+    "errors"
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/read-my-name/restful_todo_app/pkg/models"
+)
+
+func TestScanJSONLReportsLineErrors(t *testing.T) {
+    input := strings.Join([]string{
+        `{"id":"a","title":"A"}`,
+        ``,
+        `{"id":"b",`,
+        `{"id":"c","title":7}`,
+        `{"id":"d","title":"D"}`,
+    }, "\n")
+
+    var ids []string
+    var lineErrs []*LineError
+    err := ScanJSONL(strings.NewReader(input), func(line int, todo models.Todo, err error) {
+        if err != nil {
+            var lineErr *LineError
+            if !errors.As(err, &lineErr) {
+                t.Fatalf("line %d: got %T, want *LineError", line, err)
+            }
+            lineErrs = append(lineErrs, lineErr)
+            return
+        }
+        ids = append(ids, todo.ID)
+    })
+    if err != nil {
+        t.Fatal(err)
+    }
+    if strings.Join(ids, ",") != "a,d" {
+        t.Errorf("decoded %v, want a and d", ids)
+    }
+    if len(lineErrs) != 2 {
+        t.Fatalf("got %d line errors, want 2", len(lineErrs))
+    }
+    if lineErrs[0].Line != 3 || lineErrs[0].Column != "" {
+        t.Errorf("syntax error reported as %+v", lineErrs[0])
+    }
+    if lineErrs[1].Line != 4 || lineErrs[1].Column != "title" {
+        t.Errorf("type error reported as %+v", lineErrs[1])
+    }
+}
+
+func TestJSONLRoundTrip(t *testing.T) {
+    created := time.Date(2030, 1, 1, 9, 0, 0, 0, time.UTC)
+    todos := []models.Todo{mongoTestTodo("a", "A", created), mongoTestTodo("b", "B", created)}
+    var buf bytes.Buffer
+    if err := WriteJSONL(&buf, todos); err != nil {
+        t.Fatal(err)
+    }
+    var got []models.Todo
+    err := ScanJSONL(&buf, func(line int, todo models.Todo, err error) {
+        if err != nil {
+            t.Fatalf("line %d: %v", line, err)
+        }
+        got = append(got, todo)
+    })
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(got) != 2 || !got[0].DueDate.Equal(todos[0].DueDate) || got[1].ID != "b" {
+        t.Fatalf("round trip returned %+v", got)
+    }
+}
@@ -0,0 +1,231 @@
+package storage
+
+import (
+    "bytes"
+    "encoding/json"
+    "fmt"
+    "hash/crc32"
+    "io"
+    "os"
+    "strconv"
+    "time"
+
+    "github.com/read-my-name/restful_todo_app/pkg/models"
+)
+
+type journalOp string
+
+const (
+    opAdd    journalOp = "add"
+    opUpdate journalOp = "update"
+    opDelete journalOp = "delete"
+)
+
+// journalEntry is one write recorded in the journal. Todos are kept as
+// the record CSVStorage would write for them, so that a replayed todo is
+// exactly the one a compacted snapshot would hold. Records written by an
+// older version are told apart by their length and migrated.
+type journalEntry struct {
+    Op     journalOp `json:"op"`
+    ID     string    `json:"id"`
+    Record []string  `json:"record,omitempty"`
+}
+
+func newJournalEntry(op journalOp, t models.Todo) (journalEntry, error) {
+    record, err := encodeRecord(t)
+    if err != nil {
+        return journalEntry{}, err
+    }
+    return journalEntry{Op: op, ID: t.ID, Record: record}, nil
+}
+
+// journal is an append-only log of writes not yet compacted into the
+// snapshot CSV. Each entry is one line, "<crc32> <json>\n", and is synced
+// to disk before the write it records is acknowledged. sum is the CRC of
+// the whole file, kept up as entries are appended so that the journal can
+// be stamped without reading it back; summed is false until it is known.
+type journal struct {
+    file   *os.File
+    size   int64
+    sum    uint32
+    summed bool
+}
+
+func openJournal(path string) (*journal, error) {
+    file, err := os.OpenFile(path, os.O_APPEND|os.O_RDWR|os.O_CREATE, 0600)
+    if err != nil {
+        return nil, fmt.Errorf("failed to open journal: %w", err)
+    }
+    info, err := file.Stat()
+    if err != nil {
+        file.Close()
+        return nil, fmt.Errorf("failed to stat journal: %w", err)
+    }
+    return &journal{file: file, size: info.Size(), summed: info.Size() == 0}, nil
+}
+
+func encodeJournalEntry(e journalEntry) ([]byte, error) {
+    data, err := json.Marshal(e)
+    if err != nil {
+        return nil, fmt.Errorf("failed to encode journal entry: %w", err)
+    }
+    line := fmt.Appendf(nil, "%08x ", crc32.ChecksumIEEE(data))
+    line = append(line, data...)
+    return append(line, '\n'), nil
+}
+
+// append durably records e. A failed write is cut off again, so that a
+// partial entry never sits in front of later ones.
+func (j *journal) append(e journalEntry) error {
+    line, err := encodeJournalEntry(e)
+    if err != nil {
+        return err
+    }
+    if _, err := j.file.Write(line); err != nil {
+        j.file.Truncate(j.size)
+        return fmt.Errorf("failed to write journal: %w", err)
+    }
+    if err := j.file.Sync(); err != nil {
+        j.file.Truncate(j.size)
+        return fmt.Errorf("failed to sync journal: %w", err)
+    }
+    j.size += int64(len(line))
+    j.sum = crc32.Update(j.sum, crc32.IEEETable, line)
+    return nil
+}
+
+// truncate empties the journal once its entries are in the snapshot.
+func (j *journal) truncate() error {
+    if err := j.file.Truncate(0); err != nil {
+        return fmt.Errorf("failed to truncate journal: %w", err)
+    }
+    j.size = 0
+    j.sum, j.summed = 0, true
+    return j.file.Sync()
+}
+
+// cut drops everything after the first size bytes.
+func (j *journal) cut(size int64) error {
+    if err := j.file.Truncate(size); err != nil {
+        return err
+    }
+    j.size = size
+    j.summed = false
+    return nil
+}
+
+// stamp records the journal as this process left it. Only the first stamp
+// after opening reads the file back.
+func (j *journal) stamp() (fileStamp, error) {
+    info, err := j.file.Stat()
+    if err != nil {
+        return fileStamp{}, fmt.Errorf("failed to stat journal: %w", err)
+    }
+    taken := time.Now()
+    if !j.summed {
+        h := crc32.NewIEEE()
+        if _, err := io.Copy(h, io.NewSectionReader(j.file, 0, j.size)); err != nil {
+            return fileStamp{}, fmt.Errorf("failed to read journal: %w", err)
+        }
+        j.sum, j.summed = h.Sum32(), true
+    }
+    return fileStamp{exists: true, size: info.Size(), modTime: info.ModTime(), sum: j.sum, taken: taken}, nil
+}
+
+func (j *journal) close() error {
+    return j.file.Close()
+}
+
+// readJournal decodes the entries of the journal at path. An incomplete or
+// garbled last entry is what a crash during append leaves behind; it is
+// dropped and valid reports the length of the intact prefix. Damage before
+// the last entry is an error.
+func readJournal(path string) (entries []journalEntry, valid int64, err error) {
+    data, err := os.ReadFile(path)
+    if err != nil {
+        if os.IsNotExist(err) {
+            return nil, 0, nil
+        }
+        return nil, 0, fmt.Errorf("failed to read journal: %w", err)
+    }
+
+    for offset := 0; offset < len(data); {
+        end := bytes.IndexByte(data[offset:], '\n')
+        if end < 0 {
+            // Torn final write.
+            return entries, int64(offset), nil
+        }
+        entry, err := decodeJournalLine(data[offset : offset+end])
+        if err != nil {
+            if offset+end+1 == len(data) {
+                return entries, int64(offset), nil
+            }
+            return nil, 0, fmt.Errorf("journal entry %d is corrupt: %w", len(entries)+1, err)
+        }
+        entries = append(entries, entry)
+        offset += end + 1
+    }
+    return entries, int64(len(data)), nil
+}
+
+func decodeJournalLine(line []byte) (journalEntry, error) {
+    sum, data, ok := bytes.Cut(line, []byte(" "))
+    if !ok {
+        return journalEntry{}, fmt.Errorf("missing checksum")
+    }
+    want, err := strconv.ParseUint(string(sum), 16, 32)
+    if err != nil {
+        return journalEntry{}, fmt.Errorf("invalid checksum %q", sum)
+    }
+    if crc32.ChecksumIEEE(data) != uint32(want) {
+        return journalEntry{}, fmt.Errorf("checksum mismatch")
+    }
+    var e journalEntry
+    if err := json.Unmarshal(data, &e); err != nil {
+        return journalEntry{}, err
+    }
+    return e, nil
+}
+
+// apply replays e on f. Adds and updates replace a todo with the same ID
+// and deletes of missing todos are ignored, so replaying entries that
+// already reached the snapshot, after a crash during compaction, is
+// harmless.
+func (f *csvFile) apply(e journalEntry) error {
+    index := -1
+    for i := range f.todos {
+        if f.todos[i].ID == e.ID {
+            index = i
+            break
+        }
+    }
+
+    switch e.Op {
+        case opAdd, opUpdate:
+            row, version, err := csvLayout{}.rowOf(e.Record)
+            if err != nil {
+                return err
+            }
+            if err := migrateRow(row, version); err != nil {
+                return err
+            }
+            todo, err := decodeRow(row)
+            if err != nil {
+                return err
+            }
+            if index >= 0 {
+                f.todos[index] = todo
+                return nil
+            }
+            f.todos = append(f.todos, todo)
+            f.extras = append(f.extras, nil)
+        case opDelete:
+            if index >= 0 {
+                f.todos = append(f.todos[:index], f.todos[index+1:]...)
+                f.extras = append(f.extras[:index], f.extras[index+1:]...)
+            }
+        default:
+            return fmt.Errorf("unknown journal operation %q", e.Op)
+    }
+    return nil
+}
@@ -0,0 +1,151 @@
+package storage
+
+import (
+    "errors"
+    "fmt"
+    "hash/crc32"
+    "os"
+    "time"
+)
+
+// ErrLockTimeout is returned when another process holds the lock on a
+// storage file for longer than the lock timeout.
+var ErrLockTimeout = errors.New("timed out waiting for storage lock")
+
+// defaultLockTimeout is how long CSVStorage waits for another process to
+// release its files.
+const defaultLockTimeout = 5 * time.Second
+
+// lockRetryInterval is how often a held lock is tried again.
+const lockRetryInterval = 10 * time.Millisecond
+
+// fileLock is an advisory lock shared with other processes through a
+// lock file. The data files cannot carry the lock themselves, since
+// compaction replaces them.
+type fileLock struct {
+    path string
+    file *os.File
+}
+
+func openFileLock(path string) (*fileLock, error) {
+    file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+    if err != nil {
+        return nil, fmt.Errorf("failed to open lock file: %w", err)
+    }
+    return &fileLock{path: path, file: file}, nil
+}
+
+// lock takes the lock exclusively, waiting up to timeout for other
+// processes.
+func (l *fileLock) lock(timeout time.Duration) error {
+    return l.wait(timeout, true)
+}
+
+// lockShared takes the lock alongside other readers, waiting up to timeout
+// for a process holding it exclusively.
+func (l *fileLock) lockShared(timeout time.Duration) error {
+    return l.wait(timeout, false)
+}
+
+func (l *fileLock) wait(timeout time.Duration, exclusive bool) error {
+    deadline := time.Now().Add(timeout)
+    for {
+        ok, err := tryLockFile(l.file, exclusive)
+        if err != nil {
+            return fmt.Errorf("failed to lock %s: %w", l.path, err)
+        }
+        if ok {
+            return nil
+        }
+        if time.Now().After(deadline) {
+            return fmt.Errorf("%w: %s is held by another process after %s", ErrLockTimeout, l.path, timeout)
+        }
+        time.Sleep(lockRetryInterval)
+    }
+}
+
+func (l *fileLock) unlock() error {
+    if err := unlockFile(l.file); err != nil {
+        return fmt.Errorf("failed to unlock %s: %w", l.path, err)
+    }
+    return nil
+}
+
+func (l *fileLock) close() error {
+    return l.file.Close()
+}
+
+// racyWindow is how close to a file's modification time a stamp must be
+// taken for the stamp to be unable to tell later changes apart. Some file
+// systems only keep modification times to the second or worse.
+const racyWindow = 2 * time.Second
+
+// fileStamp records the state of a file, so that changes made to it by
+// another process can be noticed.
+type fileStamp struct {
+    exists  bool
+    size    int64
+    modTime time.Time
+    sum     uint32
+    taken   time.Time
+}
+
+// stampFile records the state of the file at path. prev is kept when the
+// file looks unchanged, which spares rereading it.
+func stampFile(path string, prev fileStamp) (fileStamp, error) {
+    info, err := os.Stat(path)
+    if os.IsNotExist(err) {
+        return fileStamp{taken: time.Now()}, nil
+    }
+    if err != nil {
+        return fileStamp{}, err
+    }
+    if prev.exists && info.Size() == prev.size && info.ModTime().Equal(prev.modTime) {
+        return prev, nil
+    }
+
+    taken := time.Now()
+    data, err := os.ReadFile(path)
+    if err != nil {
+        return fileStamp{}, err
+    }
+    return fileStamp{
+        exists:  true,
+        size:    info.Size(),
+        modTime: info.ModTime(),
+        sum:     crc32.ChecksumIEEE(data),
+        taken:   taken,
+    }, nil
+}
+
+// check reports whether the file at path differs from the stamp. Size
+// and modification time usually tell; when the stamp was taken within
+// racyWindow of the modification, a later change can keep both, so the
+// contents are compared too. The returned stamp is the one to keep: once
+// unchanged contents have been compared after the window, they need not
+// be again.
+func (st fileStamp) check(path string) (fileStamp, bool, error) {
+    info, err := os.Stat(path)
+    if os.IsNotExist(err) {
+        return st, st.exists, nil
+    }
+    if err != nil {
+        return st, false, err
+    }
+    if !st.exists || info.Size() != st.size || !info.ModTime().Equal(st.modTime) {
+        return st, true, nil
+    }
+    if st.taken.Sub(st.modTime) >= racyWindow {
+        return st, false, nil
+    }
+    taken := time.Now()
+    data, err := os.ReadFile(path)
+    if err != nil {
+        return st, false, err
+    }
+    if crc32.ChecksumIEEE(data) != st.sum {
+        return st, true, nil
+    }
+    st.taken = taken
+    return st, false, nil
+}
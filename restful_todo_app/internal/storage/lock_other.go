@@ -0,0 +1,16 @@
+//go:build !(darwin || dragonfly || freebsd || linux || netbsd || openbsd || windows)
+
+package storage
+
+import (
+    "errors"
+    "os"
+)
+
+func tryLockFile(f *os.File, exclusive bool) (bool, error) {
+    return false, errors.ErrUnsupported
+}
+
+func unlockFile(f *os.File) error {
+    return errors.ErrUnsupported
+}
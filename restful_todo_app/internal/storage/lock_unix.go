@@ -0,0 +1,27 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+
+package storage
+
+import (
+    "errors"
+    "os"
+    "syscall"
+)
+
+// tryLockFile takes an exclusive or shared flock on f, reporting false if
+// another process holds a conflicting one.
+func tryLockFile(f *os.File, exclusive bool) (bool, error) {
+    how := syscall.LOCK_SH
+    if exclusive {
+        how = syscall.LOCK_EX
+    }
+    err := syscall.Flock(int(f.Fd()), how|syscall.LOCK_NB)
+    if errors.Is(err, syscall.EWOULDBLOCK) {
+        return false, nil
+    }
+    return err == nil, err
+}
+
+func unlockFile(f *os.File) error {
+    return syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+}
@@ -0,0 +1,30 @@
+//go:build windows
+
+package storage
+
+import (
+    "errors"
+    "os"
+
+    "golang.org/x/sys/windows"
+)
+
+// tryLockFile takes an exclusive or shared lock on the first byte of f,
+// reporting false if another process holds a conflicting one.
+func tryLockFile(f *os.File, exclusive bool) (bool, error) {
+    var overlapped windows.Overlapped
+    flags := uint32(windows.LOCKFILE_FAIL_IMMEDIATELY)
+    if exclusive {
+        flags |= windows.LOCKFILE_EXCLUSIVE_LOCK
+    }
+    err := windows.LockFileEx(windows.Handle(f.Fd()), flags, 0, 1, 0, &overlapped)
+    if errors.Is(err, windows.ERROR_LOCK_VIOLATION) {
+        return false, nil
+    }
+    return err == nil, err
+}
+
+func unlockFile(f *os.File) error {
+    var overlapped windows.Overlapped
+    return windows.UnlockFileEx(windows.Handle(f.Fd()), 0, 1, 0, &overlapped)
+}
@@ -0,0 +1,333 @@
+package storage
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "time"
+
+    "github.com/read-my-name/restful_todo_app/pkg/models"
+    "go.mongodb.org/mongo-driver/bson"
+    "go.mongodb.org/mongo-driver/mongo"
+    "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// mongoTimeout bounds each storage call, since the Storage interface has
+// no context of its own.
+const mongoTimeout = 10 * time.Second
+
+// labelCollation makes label matching case-insensitive, like the service's
+// in-memory filter. Only the labels index has it, and a query can only use
+// indexes built with its own collation, so it is set on label queries alone.
+var labelCollation = &options.Collation{Locale: "en", Strength: 2}
+
+// mongoTodo is the BSON form of a todo. Subtasks are embedded in the todo
+// document.
+type mongoTodo struct {
+    ID          string         `bson:"_id"`
+    Title       string         `bson:"title"`
+    Description string         `bson:"description"`
+    Status      string         `bson:"status"`
+    Priority    string         `bson:"priority"`
+    DueDate     time.Time      `bson:"due_date"`
+    CreatedAt   time.Time      `bson:"created_at"`
+    UpdatedAt   time.Time      `bson:"updated_at"`
+    CompletedAt *time.Time     `bson:"completed_at,omitempty"`
+    ArchivedAt  *time.Time     `bson:"archived_at,omitempty"`
+    Labels      []string       `bson:"labels"`
+    Subtasks    []mongoSubtask `bson:"subtasks"`
+    Version     int            `bson:"version"`
+    Recurrence  string         `bson:"recurrence,omitempty"`
+    Reminders   []string       `bson:"reminders,omitempty"`
+}
+
+type mongoSubtask struct {
+    ID        string    `bson:"id"`
+    Title     string    `bson:"title"`
+    Completed bool      `bson:"completed"`
+    CreatedAt time.Time `bson:"created_at"`
+    UpdatedAt time.Time `bson:"updated_at"`
+}
+
+func toMongoTodo(t models.Todo) mongoTodo {
+    doc := mongoTodo{
+        ID:          t.ID,
+        Title:       t.Title,
+        Description: t.Description,
+        Status:      string(t.Status),
+        Priority:    string(t.Priority),
+        DueDate:     t.DueDate,
+        CreatedAt:   t.CreatedAt,
+        UpdatedAt:   t.UpdatedAt,
+        CompletedAt: t.CompletedAt,
+        ArchivedAt:  t.ArchivedAt,
+        Labels:      t.Labels,
+        Version:     t.Version,
+        Recurrence:  t.Recurrence,
+        Reminders:   t.Reminders,
+    }
+    for _, st := range t.Subtasks {
+        doc.Subtasks = append(doc.Subtasks, mongoSubtask(st))
+    }
+    return doc
+}
+
+func (doc mongoTodo) toModel() models.Todo {
+    t := models.Todo{
+        ID:          doc.ID,
+        Title:       doc.Title,
+        Description: doc.Description,
+        Status:      models.Status(doc.Status),
+        Priority:    models.Priority(doc.Priority),
+        DueDate:     doc.DueDate,
+        CreatedAt:   doc.CreatedAt,
+        UpdatedAt:   doc.UpdatedAt,
+        CompletedAt: doc.CompletedAt,
+        ArchivedAt:  doc.ArchivedAt,
+        Labels:      doc.Labels,
+        Version:     doc.Version,
+        Recurrence:  doc.Recurrence,
+        Reminders:   doc.Reminders,
+    }
+    for _, st := range doc.Subtasks {
+        t.Subtasks = append(t.Subtasks, models.Subtask(st))
+    }
+    return t
+}
+
+// TodoCollection is the set of collection operations MongoStorage needs.
+// It is satisfied by a real collection through NewMongoStorage, and can be
+// implemented in memory to test without a running mongod.
+type TodoCollection interface {
+    EnsureIndexes(ctx context.Context) error
+    Insert(ctx context.Context, docs ...interface{}) error
+    Replace(ctx context.Context, id string, doc interface{}) (matched bool, err error)
+    Delete(ctx context.Context, id string) (deleted bool, err error)
+    DeleteAll(ctx context.Context) error
+    // Find decodes the documents matching filter into out, a pointer to a
+    // slice, ordered by creation time.
+    Find(ctx context.Context, filter bson.M, out interface{}) error
+}
+
+// MongoStorage keeps todos in a MongoDB collection.
+type MongoStorage struct {
+    coll   TodoCollection
+    client *mongo.Client
+}
+
+// NewMongoStorage connects to the server at uri and uses the given
+// database and collection, creating indexes on due_date, status and labels.
+func NewMongoStorage(uri, database, collection string) (*MongoStorage, error) {
+    ctx, cancel := context.WithTimeout(context.Background(), mongoTimeout)
+    defer cancel()
+
+    client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+    if err != nil {
+        return nil, fmt.Errorf("failed to connect to MongoDB: %w", err)
+    }
+    if err := client.Ping(ctx, nil); err != nil {
+        client.Disconnect(context.Background())
+        return nil, fmt.Errorf("failed to ping MongoDB: %w", err)
+    }
+
+    s, err := NewMongoStorageWithCollection(driverCollection{client.Database(database).Collection(collection)})
+    if err != nil {
+        client.Disconnect(context.Background())
+        return nil, err
+    }
+    s.client = client
+    return s, nil
+}
+
+// NewMongoStorageWithCollection builds a MongoStorage on top of coll.
+func NewMongoStorageWithCollection(coll TodoCollection) (*MongoStorage, error) {
+    ctx, cancel := context.WithTimeout(context.Background(), mongoTimeout)
+    defer cancel()
+
+    if err := coll.EnsureIndexes(ctx); err != nil {
+        return nil, fmt.Errorf("failed to create indexes: %w", err)
+    }
+    return &MongoStorage{coll: coll}, nil
+}
+
+// Close disconnects from the server when the storage owns the client.
+func (s *MongoStorage) Close() error {
+    if s.client == nil {
+        return nil
+    }
+    ctx, cancel := context.WithTimeout(context.Background(), mongoTimeout)
+    defer cancel()
+    return s.client.Disconnect(ctx)
+}
+
+func (s *MongoStorage) Save(t models.Todo) error {
+    ctx, cancel := context.WithTimeout(context.Background(), mongoTimeout)
+    defer cancel()
+
+    if err := s.coll.Insert(ctx, toMongoTodo(t)); err != nil {
+        return fmt.Errorf("failed to insert todo: %w", err)
+    }
+    return nil
+}
+
+// SaveAll replaces the whole collection. Without a replica set this is
+// not atomic: a failure part way leaves a partial set of todos.
+func (s *MongoStorage) SaveAll(todos []models.Todo) error {
+    ctx, cancel := context.WithTimeout(context.Background(), mongoTimeout)
+    defer cancel()
+
+    if err := s.coll.DeleteAll(ctx); err != nil {
+        return fmt.Errorf("failed to clear todos: %w", err)
+    }
+    if len(todos) == 0 {
+        return nil
+    }
+    docs := make([]interface{}, 0, len(todos))
+    for _, t := range todos {
+        docs = append(docs, toMongoTodo(t))
+    }
+    if err := s.coll.Insert(ctx, docs...); err != nil {
+        return fmt.Errorf("failed to insert todos: %w", err)
+    }
+    return nil
+}
+
+func (s *MongoStorage) Update(id string, t models.Todo) error {
+    ctx, cancel := context.WithTimeout(context.Background(), mongoTimeout)
+    defer cancel()
+
+    t.ID = id
+    matched, err := s.coll.Replace(ctx, id, toMongoTodo(t))
+    if err != nil {
+        return fmt.Errorf("failed to update todo: %w", err)
+    }
+    if !matched {
+        return fmt.Errorf("todo with ID %s not found", id)
+    }
+    return nil
+}
+
+func (s *MongoStorage) Delete(id string) error {
+    ctx, cancel := context.WithTimeout(context.Background(), mongoTimeout)
+    defer cancel()
+
+    deleted, err := s.coll.Delete(ctx, id)
+    if err != nil {
+        return fmt.Errorf("failed to delete todo: %w", err)
+    }
+    if !deleted {
+        return fmt.Errorf("todo with ID %s not found", id)
+    }
+    return nil
+}
+
+func (s *MongoStorage) Load() ([]models.Todo, error) {
+    return s.Query(models.TodoFilter{})
+}
+
+// Query returns the todos matching filter, evaluated by MongoDB. As with
+// SQLiteStorage, calendar periods must be resolved to a due date range first.
+func (s *MongoStorage) Query(filter models.TodoFilter) ([]models.Todo, error) {
+    if filter.Period != "" && filter.Period != "all" {
+        return nil, fmt.Errorf("period %q must be resolved to a due date range", filter.Period)
+    }
+
+    query := bson.M{}
+    if len(filter.Statuses) > 0 {
+        query["status"] = bson.M{"$in": filter.Statuses}
+    }
+    if len(filter.Priorities) > 0 {
+        query["priority"] = bson.M{"$in": filter.Priorities}
+    }
+    if len(filter.Labels) > 0 {
+        query["labels"] = bson.M{"$in": filter.Labels}
+    }
+    due := bson.M{}
+    if !filter.DueAfter.IsZero() {
+        due["$gte"] = filter.DueAfter
+    }
+    if !filter.DueBefore.IsZero() {
+        due["$lt"] = filter.DueBefore
+    }
+    if len(due) > 0 {
+        query["due_date"] = due
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), mongoTimeout)
+    defer cancel()
+
+    var docs []mongoTodo
+    if err := s.coll.Find(ctx, query, &docs); err != nil {
+        return nil, fmt.Errorf("failed to query todos: %w", err)
+    }
+    todos := make([]models.Todo, 0, len(docs))
+    for _, doc := range docs {
+        todos = append(todos, doc.toModel())
+    }
+    return todos, nil
+}
+
+// findOptions orders the results of filter by creation time, and matches
+// labels without regard to case.
+func findOptions(filter bson.M) *options.FindOptions {
+    opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}, {Key: "_id", Value: 1}})
+    if _, ok := filter["labels"]; ok {
+        opts.SetCollation(labelCollation)
+    }
+    return opts
+}
+
+// driverCollection adapts a *mongo.Collection to TodoCollection.
+type driverCollection struct {
+    coll *mongo.Collection
+}
+
+func (c driverCollection) EnsureIndexes(ctx context.Context) error {
+    _, err := c.coll.Indexes().CreateMany(ctx, []mongo.IndexModel{
+        {Keys: bson.D{{Key: "due_date", Value: 1}}},
+        {Keys: bson.D{{Key: "status", Value: 1}}},
+        {Keys: bson.D{{Key: "labels", Value: 1}}, Options: options.Index().SetCollation(labelCollation)},
+    })
+    return err
+}
+
+func (c driverCollection) Insert(ctx context.Context, docs ...interface{}) error {
+    if len(docs) == 1 {
+        _, err := c.coll.InsertOne(ctx, docs[0])
+        return err
+    }
+    _, err := c.coll.InsertMany(ctx, docs)
+    return err
+}
+
+func (c driverCollection) Replace(ctx context.Context, id string, doc interface{}) (bool, error) {
+    res, err := c.coll.ReplaceOne(ctx, bson.M{"_id": id}, doc)
+    if err != nil {
+        return false, err
+    }
+    return res.MatchedCount > 0, nil
+}
+
+func (c driverCollection) Delete(ctx context.Context, id string) (bool, error) {
+    res, err := c.coll.DeleteOne(ctx, bson.M{"_id": id})
+    if err != nil {
+        return false, err
+    }
+    return res.DeletedCount > 0, nil
+}
+
+func (c driverCollection) DeleteAll(ctx context.Context) error {
+    _, err := c.coll.DeleteMany(ctx, bson.M{})
+    return err
+}
+
+func (c driverCollection) Find(ctx context.Context, filter bson.M, out interface{}) error {
+    cursor, err := c.coll.Find(ctx, filter, findOptions(filter))
+    if err != nil {
+        return err
+    }
+    if err := cursor.All(ctx, out); err != nil {
+        return errors.Join(err, cursor.Close(ctx))
+    }
+    return nil
+}
\ No newline at end of file
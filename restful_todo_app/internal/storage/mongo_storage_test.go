@@ -0,0 +1,306 @@
+package storage
+
+import (
+    "context"
+    "fmt"
+    "reflect"
+    "sort"
+    "strings"
+    "sync"
+    "testing"
+    "time"
+
+    "github.com/read-my-name/restful_todo_app/pkg/models"
+    "go.mongodb.org/mongo-driver/bson"
+)
+
+// memCollection is an in-process TodoCollection that keeps documents as
+// they come back from BSON. It understands the
+// queries MongoStorage builds: $in on status, priority and labels, the
+// last compared without regard to case as labelCollation does, and a
+// $gte/$lt range on due_date.
+type memCollection struct {
+    mu      sync.Mutex
+    docs    map[string]mongoTodo
+    indexed bool
+}
+
+func newMemCollection() *memCollection {
+    return &memCollection{docs: make(map[string]mongoTodo)}
+}
+
+func (c *memCollection) EnsureIndexes(ctx context.Context) error {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    c.indexed = true
+    return nil
+}
+
+func (c *memCollection) Insert(ctx context.Context, docs ...interface{}) error {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    for _, d := range docs {
+        doc, err := throughBSON(d)
+        if err != nil {
+            return err
+        }
+        if _, ok := c.docs[doc.ID]; ok {
+            return fmt.Errorf("E11000 duplicate key: %s", doc.ID)
+        }
+        c.docs[doc.ID] = doc
+    }
+    return nil
+}
+
+func (c *memCollection) Replace(ctx context.Context, id string, doc interface{}) (bool, error) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    if _, ok := c.docs[id]; !ok {
+        return false, nil
+    }
+    stored, err := throughBSON(doc)
+    if err != nil {
+        return false, err
+    }
+    c.docs[id] = stored
+    return true, nil
+}
+
+// throughBSON encodes and decodes doc as the driver would on its way to
+// the server and back.
+func throughBSON(doc interface{}) (mongoTodo, error) {
+    data, err := bson.Marshal(doc)
+    if err != nil {
+        return mongoTodo{}, err
+    }
+    var stored mongoTodo
+    err = bson.Unmarshal(data, &stored)
+    return stored, err
+}
+
+func (c *memCollection) Delete(ctx context.Context, id string) (bool, error) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    _, ok := c.docs[id]
+    delete(c.docs, id)
+    return ok, nil
+}
+
+func (c *memCollection) DeleteAll(ctx context.Context) error {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    c.docs = make(map[string]mongoTodo)
+    return nil
+}
+
+func (c *memCollection) Find(ctx context.Context, filter bson.M, out interface{}) error {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+
+    var found []mongoTodo
+    for _, doc := range c.docs {
+        ok, err := matchesQuery(doc, filter)
+        if err != nil {
+            return err
+        }
+        if ok {
+            found = append(found, doc)
+        }
+    }
+    sort.Slice(found, func(i, j int) bool {
+        if c := found[i].CreatedAt.Compare(found[j].CreatedAt); c != 0 {
+            return c < 0
+        }
+        return found[i].ID < found[j].ID
+    })
+    *out.(*[]mongoTodo) = found
+    return nil
+}
+
+func matchesQuery(doc mongoTodo, filter bson.M) (bool, error) {
+    for field, cond := range filter {
+        ops, ok := cond.(bson.M)
+        if !ok {
+            return false, fmt.Errorf("unsupported condition on %s: %v", field, cond)
+        }
+        for op, arg := range ops {
+            var ok bool
+            switch field + " " + op {
+                case "status $in":
+                    ok = containsValue(inValues(arg), doc.Status, false)
+                case "priority $in":
+                    ok = containsValue(inValues(arg), doc.Priority, false)
+                case "labels $in":
+                    for _, label := range doc.Labels {
+                        ok = ok || containsValue(inValues(arg), label, true)
+                    }
+                case "due_date $gte":
+                    ok = !doc.DueDate.Before(arg.(time.Time))
+                case "due_date $lt":
+                    ok = doc.DueDate.Before(arg.(time.Time))
+                default:
+                    return false, fmt.Errorf("unsupported operator %s on %s", op, field)
+            }
+            if !ok {
+                return false, nil
+            }
+        }
+    }
+    return true, nil
+}
+
+// inValues returns the elements of an $in argument, a slice of a string
+// type, as strings.
+func inValues(arg interface{}) []string {
+    v := reflect.ValueOf(arg)
+    values := make([]string, v.Len())
+    for i := range values {
+        values[i] = v.Index(i).String()
+    }
+    return values
+}
+
+func containsValue(values []string, s string, fold bool) bool {
+    for _, v := range values {
+        if v == s || fold && strings.EqualFold(v, s) {
+            return true
+        }
+    }
+    return false
+}
+
+func mongoTestTodo(id, title string, created time.Time) models.Todo {
+    return models.Todo{
+        ID:        id,
+        Title:     title,
+        Status:    models.StatusNotStarted,
+        Priority:  models.PriorityLow,
+        DueDate:   created.Add(24 * time.Hour),
+        CreatedAt: created,
+        UpdatedAt: created,
+        Version:   1,
+    }
+}
+
+func TestMongoStorageRoundTrip(t *testing.T) {
+    coll := newMemCollection()
+    s, err := NewMongoStorageWithCollection(coll)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if !coll.indexed {
+        t.Fatal("indexes were not created")
+    }
+
+    created := time.Date(2030, 1, 1, 9, 0, 0, 0, time.UTC)
+    done := created.Add(time.Hour)
+    todo := mongoTestTodo("a", "Write report", created)
+    todo.Labels = []string{"Work"}
+    todo.Subtasks = []models.Subtask{{ID: "s1", Title: "Outline", Completed: true, CreatedAt: created, UpdatedAt: created}}
+    todo.CompletedAt = &done
+    todo.Recurrence = "FREQ=WEEKLY"
+    todo.Reminders = []string{"1d", "1h"}
+    if err := s.Save(todo); err != nil {
+        t.Fatal(err)
+    }
+    if err := s.Save(mongoTestTodo("b", "Second", created.Add(time.Minute))); err != nil {
+        t.Fatal(err)
+    }
+
+    todos, err := s.Load()
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(todos) != 2 || todos[0].ID != "a" || todos[1].ID != "b" {
+        t.Fatalf("Load returned %+v", todos)
+    }
+    if !reflect.DeepEqual(todos[0], todo) {
+        t.Fatalf("round trip changed the todo:\n got %+v\nwant %+v", todos[0], todo)
+    }
+
+    todo.Title = "Write the report"
+    if err := s.Update("a", todo); err != nil {
+        t.Fatal(err)
+    }
+    if err := s.Delete("b"); err != nil {
+        t.Fatal(err)
+    }
+    if err := s.Update("b", todo); err == nil {
+        t.Error("updating a deleted todo succeeded")
+    }
+    if err := s.Delete("b"); err == nil {
+        t.Error("deleting a deleted todo succeeded")
+    }
+    todos, _ = s.Load()
+    if len(todos) != 1 || todos[0].Title != "Write the report" {
+        t.Fatalf("Load after update and delete returned %+v", todos)
+    }
+
+    if err := s.SaveAll([]models.Todo{mongoTestTodo("c", "Only", created)}); err != nil {
+        t.Fatal(err)
+    }
+    todos, _ = s.Load()
+    if len(todos) != 1 || todos[0].ID != "c" {
+        t.Fatalf("Load after SaveAll returned %+v", todos)
+    }
+}
+
+func TestMongoStorageQuery(t *testing.T) {
+    s, err := NewMongoStorageWithCollection(newMemCollection())
+    if err != nil {
+        t.Fatal(err)
+    }
+    created := time.Date(2030, 1, 1, 9, 0, 0, 0, time.UTC)
+    a := mongoTestTodo("a", "A", created)
+    a.Labels = []string{"Work"}
+    b := mongoTestTodo("b", "B", created.Add(time.Minute))
+    b.Status = models.StatusInProgress
+    b.Priority = models.PriorityHigh
+    b.DueDate = created.Add(72 * time.Hour)
+    for _, todo := range []models.Todo{a, b} {
+        if err := s.Save(todo); err != nil {
+            t.Fatal(err)
+        }
+    }
+
+    tests := []struct {
+        name   string
+        filter models.TodoFilter
+        want   []string
+    }{
+        {"all", models.TodoFilter{}, []string{"a", "b"}},
+        {"status", models.TodoFilter{Statuses: []models.Status{models.StatusInProgress}}, []string{"b"}},
+        {"priority", models.TodoFilter{Priorities: []models.Priority{models.PriorityLow, models.PriorityMedium}}, []string{"a"}},
+        {"label ignores case", models.TodoFilter{Labels: []string{"work"}}, []string{"a"}},
+        {"due range", models.TodoFilter{DueAfter: created.Add(48 * time.Hour), DueBefore: created.Add(96 * time.Hour)}, []string{"b"}},
+        {"combined", models.TodoFilter{Labels: []string{"WORK"}, Statuses: []models.Status{models.StatusInProgress}}, nil},
+    }
+    for _, tt := range tests {
+        todos, err := s.Query(tt.filter)
+        if err != nil {
+            t.Fatalf("%s: %v", tt.name, err)
+        }
+        var ids []string
+        for _, todo := range todos {
+            ids = append(ids, todo.ID)
+        }
+        if !reflect.DeepEqual(ids, tt.want) {
+            t.Errorf("%s: got %v, want %v", tt.name, ids, tt.want)
+        }
+    }
+
+    if _, err := s.Query(models.TodoFilter{Period: "today"}); err == nil {
+        t.Error("Query accepted an unresolved period")
+    }
+}
+
+func TestMongoFindCollation(t *testing.T) {
+    // Only the labels index has labelCollation; other queries must run
+    // without one to use the status and due_date indexes.
+    if opts := findOptions(bson.M{"status": bson.M{"$in": []string{"completed"}}}); opts.Collation != nil {
+        t.Error("status query has a collation")
+    }
+    if opts := findOptions(bson.M{"labels": bson.M{"$in": []string{"work"}}}); opts.Collation != labelCollation {
+        t.Error("label query does not use the labels index collation")
+    }
+}
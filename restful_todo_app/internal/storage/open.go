@@ -0,0 +1,79 @@
+package storage
+
+import (
+    "fmt"
+    "path/filepath"
+    "time"
+
+    "github.com/go-redis/redis/v8"
+)
+
+// Config selects and configures a storage backend.
+type Config struct {
+    Backend   string // csv, sqlite or mongo
+    Path      string // file of the CSV or SQLite backend, or the MongoDB database name
+    MongoURI  string
+    RedisAddr string   // when set, the backend is fronted by a Redis cache
+    CSVMode   LoadMode // how the csv backend handles unreadable rows
+
+    // LockTimeout bounds how long the csv backend waits for another
+    // process using the same files. Zero means the default of 5s.
+    LockTimeout time.Duration
+}
+
+// DefaultPath returns the Path used for a backend when none is
+// configured: a file under ./data for csv and sqlite, and the database
+// todo_app for mongo.
+func DefaultPath(backend string) string {
+    switch backend {
+        case "csv":
+            return filepath.Join("data", "todos.csv")
+        case "sqlite":
+            return filepath.Join("data", "todos.db")
+        case "mongo":
+            return "todo_app"
+    }
+    return ""
+}
+
+// Open returns the backend described by cfg. Backends that hold a
+// connection implement io.Closer.
+func Open(cfg Config) (Backend, error) {
+    path := cfg.Path
+    if path == "" {
+        path = DefaultPath(cfg.Backend)
+    }
+
+    var backend Backend
+    switch cfg.Backend {
+        case "csv":
+            mode := cfg.CSVMode
+            if mode == "" {
+                mode = LenientLoad
+            }
+            if mode != StrictLoad && mode != LenientLoad {
+                return nil, fmt.Errorf("unknown CSV load mode %q", mode)
+            }
+            backend = NewCSVStorage(path, WithLoadMode(mode), WithLockTimeout(cfg.LockTimeout))
+        case "sqlite":
+            s, err := NewSQLiteStorage(path)
+            if err != nil {
+                return nil, err
+            }
+            backend = s
+        case "mongo":
+            s, err := NewMongoStorage(cfg.MongoURI, path, "todos")
+            if err != nil {
+                return nil, err
+            }
+            backend = s
+        default:
+            return nil, fmt.Errorf("unknown storage backend %q", cfg.Backend)
+    }
+
+    if cfg.RedisAddr != "" {
+        client := redis.NewClient(&redis.Options{Addr: cfg.RedisAddr})
+        backend = NewCachedStorage(backend, client, "todo:")
+    }
+    return backend, nil
+}
@@ -0,0 +1,528 @@
+package storage
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "log/slog"
+    "math"
+    "slices"
+    "sort"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+
+    "github.com/go-redis/redis/v8"
+    "github.com/read-my-name/restful_todo_app/pkg/models"
+)
+
+// redisTimeout bounds each cache call, so a slow Redis degrades to the
+// backend instead of stalling writes.
+const redisTimeout = 2 * time.Second
+
+// Backend is the storage a CachedStorage wraps. Every storage in this
+// package satisfies it.
+type Backend interface {
+    Save(todo models.Todo) error
+    SaveAll(todos []models.Todo) error
+    Load() ([]models.Todo, error)
+    Update(id string, todo models.Todo) error
+    Delete(id string) error
+}
+
+// querier is implemented by backends that can evaluate a filter themselves.
+type querier interface {
+    Query(filter models.TodoFilter) ([]models.Todo, error)
+}
+
+// CachedStorage keeps a copy of the todos of a backend in Redis. Writes go
+// to the backend first and then refresh the cache. Load always reads the
+// backend and refills the cache from it; queries are served from Redis and
+// fall back to the backend when the cache is cold or unavailable.
+//
+// Under the key prefix the cache holds:
+//
+//  item:<id>         the todo as JSON
+//  ids               set of all todo IDs
+//  due               sorted set of IDs scored by due date (Unix milliseconds)
+//  status:<status>   set of IDs per status
+//  priority:<p>      set of IDs per priority
+//  label:<label>     set of IDs per lower-cased label
+//  warm              present once the cache holds every todo
+type CachedStorage struct {
+    backend    Backend
+    client     *redis.Client
+    prefix     string
+    mu         sync.Mutex
+    generation uint64 // of the backend, as last seen
+}
+
+// NewCachedStorage wraps backend with a cache in client, keeping its keys
+// under prefix (for example "todo:").
+func NewCachedStorage(backend Backend, client *redis.Client, prefix string) *CachedStorage {
+    return &CachedStorage{backend: backend, client: client, prefix: prefix}
+}
+
+// Close closes the Redis client and, if it has one, the backend.
+func (s *CachedStorage) Close() error {
+    err := s.client.Close()
+    if closer, ok := s.backend.(interface{ Close() error }); ok {
+        err = errors.Join(err, closer.Close())
+    }
+    return err
+}
+
+// Health reports the health of the backend, if it tracks any.
+func (s *CachedStorage) Health() models.StorageHealth {
+    if reporter, ok := s.backend.(interface{ Health() models.StorageHealth }); ok {
+        return reporter.Health()
+    }
+    return models.StorageHealth{Status: "ok"}
+}
+
+// Recover lets the backend recover from a crash, if it can. Writes it
+// replays may never have reached the cache, so the cache is then refilled.
+func (s *CachedStorage) Recover() (int, error) {
+    recoverer, ok := s.backend.(interface{ Recover() (int, error) })
+    if !ok {
+        return 0, nil
+    }
+
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    replayed, err := recoverer.Recover()
+    if replayed > 0 {
+        s.invalidate(fmt.Errorf("backend replayed %d writes", replayed))
+    }
+    return replayed, err
+}
+
+// Generation returns the generation of the backend, if it has one. A new
+// one means another process wrote to the backend, perhaps without going
+// through the cache, so the cache is invalidated.
+func (s *CachedStorage) Generation() (uint64, error) {
+    watcher, ok := s.backend.(interface{ Generation() (uint64, error) })
+    if !ok {
+        return 0, nil
+    }
+
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    gen, err := watcher.Generation()
+    if err != nil {
+        return 0, err
+    }
+    // The first call has nothing to compare with; a cache left behind by
+    // an earlier run is replaced by Load.
+    if s.generation != 0 && gen != s.generation {
+        s.invalidate(fmt.Errorf("backend changed by another process"))
+    }
+    s.generation = gen
+    return gen, nil
+}
+
+func (s *CachedStorage) Save(t models.Todo) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    if err := s.backend.Save(t); err != nil {
+        return err
+    }
+    s.refresh(t.ID, &t)
+    return nil
+}
+
+func (s *CachedStorage) SaveAll(todos []models.Todo) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    if err := s.backend.SaveAll(todos); err != nil {
+        return err
+    }
+    if err := s.fill(todos); err != nil {
+        s.invalidate(err)
+    }
+    return nil
+}
+
+func (s *CachedStorage) Update(id string, t models.Todo) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    if err := s.backend.Update(id, t); err != nil {
+        return err
+    }
+    t.ID = id
+    s.refresh(id, &t)
+    return nil
+}
+
+func (s *CachedStorage) Delete(id string) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    if err := s.backend.Delete(id); err != nil {
+        return err
+    }
+    s.refresh(id, nil)
+    return nil
+}
+
+// Load returns every todo from the backend and refills the cache with
+// them. The backend is authoritative: a cache left warm by an earlier run
+// misses any writes made to the backend without it since.
+func (s *CachedStorage) Load() ([]models.Todo, error) {
+    return s.loadBackend()
+}
+
+// Query returns the todos matching filter using the cache's index sets.
+// As with the other backends, calendar periods must be resolved to a due
+// date range first. If Redis cannot answer, the backend is asked instead
+// when it supports queries.
+func (s *CachedStorage) Query(filter models.TodoFilter) ([]models.Todo, error) {
+    if filter.Period != "" && filter.Period != "all" {
+        return nil, fmt.Errorf("period %q must be resolved to a due date range", filter.Period)
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+    defer cancel()
+
+    todos, err := s.queryCached(ctx, filter)
+    if errors.Is(err, errCacheCold) {
+        if _, err = s.loadBackend(); err != nil {
+            return nil, err
+        }
+        todos, err = s.queryCached(ctx, filter)
+    }
+    if err == nil {
+        return todos, nil
+    }
+    slog.Warn("redis cache: querying backend", "err", err)
+    if q, ok := s.backend.(querier); ok {
+        return q.Query(filter)
+    }
+    all, err := s.backend.Load()
+    if err != nil {
+        return nil, err
+    }
+    todos = all[:0]
+    for _, t := range all {
+        if matchesFilter(t, filter) {
+            todos = append(todos, t)
+        }
+    }
+    return todos, nil
+}
+
+// matchesFilter evaluates filter as the index sets do, for backends that
+// cannot do it themselves.
+func matchesFilter(t models.Todo, filter models.TodoFilter) bool {
+    if len(filter.Statuses) > 0 && !slices.Contains(filter.Statuses, t.Status) {
+        return false
+    }
+    if len(filter.Priorities) > 0 && !slices.Contains(filter.Priorities, t.Priority) {
+        return false
+    }
+    if len(filter.Labels) > 0 && !slices.ContainsFunc(t.Labels, func(label string) bool {
+        return slices.ContainsFunc(filter.Labels, func(want string) bool { return strings.EqualFold(label, want) })
+    }) {
+        return false
+    }
+    if !filter.DueAfter.IsZero() && t.DueDate.Before(filter.DueAfter) {
+        return false
+    }
+    return filter.DueBefore.IsZero() || t.DueDate.Before(filter.DueBefore)
+}
+
+// errCacheCold means the cache does not (or no longer) hold every todo.
+var errCacheCold = errors.New("cache is cold")
+
+func (s *CachedStorage) key(parts ...string) string {
+    return s.prefix + strings.Join(parts, ":")
+}
+
+// loadBackend reads every todo from the backend and fills the cache with
+// them. A failure to fill the cache is logged, not returned.
+func (s *CachedStorage) loadBackend() ([]models.Todo, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    todos, err := s.backend.Load()
+    if err != nil {
+        return nil, err
+    }
+    if err := s.fill(todos); err != nil {
+        s.invalidate(err)
+    }
+    return todos, nil
+}
+
+// loadCached returns the cached todos with the given IDs, or every cached
+// todo when ids is nil. It fails with errCacheCold unless the cache is warm.
+func (s *CachedStorage) loadCached(ctx context.Context, ids []string) ([]models.Todo, error) {
+    warm, err := s.client.Exists(ctx, s.key("warm")).Result()
+    if err != nil {
+        return nil, err
+    }
+    if warm == 0 {
+        return nil, errCacheCold
+    }
+    if ids == nil {
+        if ids, err = s.client.SMembers(ctx, s.key("ids")).Result(); err != nil {
+            return nil, err
+        }
+    }
+    if len(ids) == 0 {
+        return []models.Todo{}, nil
+    }
+
+    keys := make([]string, len(ids))
+    for i, id := range ids {
+        keys[i] = s.key("item", id)
+    }
+    values, err := s.client.MGet(ctx, keys...).Result()
+    if err != nil {
+        return nil, err
+    }
+
+    todos := make([]models.Todo, 0, len(values))
+    for i, v := range values {
+        data, ok := v.(string)
+        if !ok {
+            // An indexed todo without an item means the cache is torn.
+            return nil, fmt.Errorf("%w: missing item %s", errCacheCold, ids[i])
+        }
+        var t models.Todo
+        if err := json.Unmarshal([]byte(data), &t); err != nil {
+            return nil, fmt.Errorf("failed to decode cached todo %s: %w", ids[i], err)
+        }
+        todos = append(todos, t)
+    }
+    sort.Slice(todos, func(i, j int) bool {
+        if c := todos[i].CreatedAt.Compare(todos[j].CreatedAt); c != 0 {
+            return c < 0
+        }
+        return todos[i].ID < todos[j].ID
+    })
+    return todos, nil
+}
+
+func (s *CachedStorage) queryCached(ctx context.Context, filter models.TodoFilter) ([]models.Todo, error) {
+    var sets [][]string
+    if len(filter.Statuses) > 0 {
+        keys := make([]string, len(filter.Statuses))
+        for i, status := range filter.Statuses {
+            keys[i] = s.key("status", string(status))
+        }
+        sets = append(sets, keys)
+    }
+    if len(filter.Priorities) > 0 {
+        keys := make([]string, len(filter.Priorities))
+        for i, priority := range filter.Priorities {
+            keys[i] = s.key("priority", string(priority))
+        }
+        sets = append(sets, keys)
+    }
+    if len(filter.Labels) > 0 {
+        keys := make([]string, len(filter.Labels))
+        for i, label := range filter.Labels {
+            keys[i] = s.key("label", strings.ToLower(label))
+        }
+        sets = append(sets, keys)
+    }
+
+    // Values within a field are OR'ed by a union; fields are AND'ed by
+    // intersecting the unions.
+    var ids map[string]bool
+    for _, keys := range sets {
+        members, err := s.client.SUnion(ctx, keys...).Result()
+        if err != nil {
+            return nil, err
+        }
+        ids = intersect(ids, members)
+    }
+    if !filter.DueAfter.IsZero() || !filter.DueBefore.IsZero() {
+        // Scores are whole milliseconds, so the range is widened here and
+        // the exact bounds are applied to the decoded todos below.
+        lo, hi := "-inf", "+inf"
+        if !filter.DueAfter.IsZero() {
+            lo = strconv.FormatInt(filter.DueAfter.UnixMilli(), 10)
+        }
+        if !filter.DueBefore.IsZero() {
+            hi = strconv.FormatInt(filter.DueBefore.Add(time.Millisecond-1).UnixMilli(), 10)
+        }
+        members, err := s.client.ZRangeByScore(ctx, s.key("due"), &redis.ZRangeBy{Min: lo, Max: hi}).Result()
+        if err != nil {
+            return nil, err
+        }
+        ids = intersect(ids, members)
+    }
+
+    var wanted []string
+    if ids != nil {
+        wanted = make([]string, 0, len(ids))
+        for id := range ids {
+            wanted = append(wanted, id)
+        }
+    }
+    todos, err := s.loadCached(ctx, wanted)
+    if err != nil {
+        return nil, err
+    }
+
+    matched := todos[:0]
+    for _, t := range todos {
+        if !filter.DueAfter.IsZero() && t.DueDate.Before(filter.DueAfter) {
+            continue
+        }
+        if !filter.DueBefore.IsZero() && !t.DueDate.Before(filter.DueBefore) {
+            continue
+        }
+        matched = append(matched, t)
+    }
+    return matched, nil
+}
+
+// intersect narrows set to members. A nil set stands for "everything".
+func intersect(set map[string]bool, members []string) map[string]bool {
+    next := make(map[string]bool, len(members))
+    for _, m := range members {
+        if set == nil || set[m] {
+            next[m] = true
+        }
+    }
+    return next
+}
+
+// fill replaces the cache contents with todos and marks it warm.
+// Callers must hold s.mu.
+func (s *CachedStorage) fill(todos []models.Todo) error {
+    ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+    defer cancel()
+
+    if err := s.clear(ctx); err != nil {
+        return err
+    }
+    _, err := s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+        for _, t := range todos {
+            if err := s.index(ctx, pipe, t); err != nil {
+                return err
+            }
+        }
+        pipe.Set(ctx, s.key("warm"), "1", 0)
+        return nil
+    })
+    return err
+}
+
+// clear removes every key under the prefix.
+func (s *CachedStorage) clear(ctx context.Context) error {
+    iter := s.client.Scan(ctx, 0, s.prefix+"*", 100).Iterator()
+    var keys []string
+    for iter.Next(ctx) {
+        keys = append(keys, iter.Val())
+    }
+    if err := iter.Err(); err != nil {
+        return err
+    }
+    if len(keys) == 0 {
+        return nil
+    }
+    return s.client.Del(ctx, keys...).Err()
+}
+
+// refresh brings the cached copy of the todo with the given ID in line
+// with the backend after a write; t is nil when the todo was deleted. A
+// cold cache is left alone, and a failed refresh invalidates the whole
+// cache so that stale entries are never served. Callers must hold s.mu.
+func (s *CachedStorage) refresh(id string, t *models.Todo) {
+    ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+    defer cancel()
+
+    warm, err := s.client.Exists(ctx, s.key("warm")).Result()
+    if err != nil || warm == 0 {
+        if err != nil {
+            s.invalidate(err)
+        }
+        return
+    }
+
+    var old *models.Todo
+    data, err := s.client.Get(ctx, s.key("item", id)).Bytes()
+    switch {
+        case err == nil:
+            old = &models.Todo{}
+            if err := json.Unmarshal(data, old); err != nil {
+                s.invalidate(err)
+                return
+            }
+        case !errors.Is(err, redis.Nil):
+            s.invalidate(err)
+            return
+    }
+
+    _, err = s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+        if old != nil {
+            s.unindex(ctx, pipe, *old)
+        }
+        if t != nil {
+            return s.index(ctx, pipe, *t)
+        }
+        return nil
+    })
+    if err != nil {
+        s.invalidate(err)
+    }
+}
+
+// invalidate marks the cache cold after it could not be kept in step with
+// the backend; the next read refills it.
+func (s *CachedStorage) invalidate(cause error) {
+    slog.Warn("redis cache: falling back to backend", "err", cause)
+
+    ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+    defer cancel()
+    if err := s.client.Del(ctx, s.key("warm")).Err(); err != nil {
+        slog.Error("redis cache: failed to invalidate", "err", err)
+    }
+}
+
+func (s *CachedStorage) index(ctx context.Context, pipe redis.Pipeliner, t models.Todo) error {
+    data, err := json.Marshal(t)
+    if err != nil {
+        return fmt.Errorf("failed to encode todo %s: %w", t.ID, err)
+    }
+    pipe.Set(ctx, s.key("item", t.ID), data, 0)
+    pipe.SAdd(ctx, s.key("ids"), t.ID)
+    pipe.ZAdd(ctx, s.key("due"), &redis.Z{Score: dueScore(t.DueDate), Member: t.ID})
+    pipe.SAdd(ctx, s.key("status", string(t.Status)), t.ID)
+    pipe.SAdd(ctx, s.key("priority", string(t.Priority)), t.ID)
+    for _, label := range t.Labels {
+        pipe.SAdd(ctx, s.key("label", strings.ToLower(label)), t.ID)
+    }
+    return nil
+}
+
+func (s *CachedStorage) unindex(ctx context.Context, pipe redis.Pipeliner, t models.Todo) {
+    pipe.Del(ctx, s.key("item", t.ID))
+    pipe.SRem(ctx, s.key("ids"), t.ID)
+    pipe.ZRem(ctx, s.key("due"), t.ID)
+    pipe.SRem(ctx, s.key("status", string(t.Status)), t.ID)
+    pipe.SRem(ctx, s.key("priority", string(t.Priority)), t.ID)
+    for _, label := range t.Labels {
+        pipe.SRem(ctx, s.key("label", strings.ToLower(label)), t.ID)
+    }
+}
+
+// dueScore places a due date in the due sorted set. Zero due dates sort
+// first.
+func dueScore(due time.Time) float64 {
+    if due.IsZero() {
+        return math.Inf(-1)
+    }
+    return float64(due.UnixMilli())
+}
\ No newline at end of file
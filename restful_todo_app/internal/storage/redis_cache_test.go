@@ -0,0 +1,194 @@
+package storage
+
+import (
+    "path/filepath"
+    "reflect"
+    "testing"
+    "time"
+
+    "github.com/alicebob/miniredis/v2"
+    "github.com/go-redis/redis/v8"
+    "github.com/read-my-name/restful_todo_app/pkg/models"
+)
+
+// newTestCache fronts a CSV file, which cannot query by itself, with a
+// cache in mr.
+func newTestCache(t *testing.T, mr *miniredis.Miniredis, backend Backend) *CachedStorage {
+    t.Helper()
+    c := NewCachedStorage(backend, redis.NewClient(&redis.Options{Addr: mr.Addr()}), "todo:")
+    t.Cleanup(func() { c.client.Close() })
+    return c
+}
+
+func cacheTestTodos(now time.Time) []models.Todo {
+    a := mongoTestTodo("a", "A", now)
+    a.Labels = []string{"Work"}
+    a.Priority = models.PriorityHigh
+    b := mongoTestTodo("b", "B", now.Add(time.Second))
+    b.Status = models.StatusCompleted
+    b.DueDate = now.Add(72 * time.Hour)
+    b.Labels = []string{"home"}
+    return []models.Todo{a, b}
+}
+
+func queryIDs(t *testing.T, c *CachedStorage, filter models.TodoFilter) []string {
+    t.Helper()
+    todos, err := c.Query(filter)
+    if err != nil {
+        t.Fatal(err)
+    }
+    var ids []string
+    for _, todo := range todos {
+        ids = append(ids, todo.ID)
+    }
+    return ids
+}
+
+func TestCachedStorageWriteThrough(t *testing.T) {
+    mr := miniredis.RunT(t)
+    c := newTestCache(t, mr, NewCSVStorage(filepath.Join(t.TempDir(), "todos.csv")))
+    now := time.Date(2030, 1, 1, 9, 0, 0, 0, time.UTC)
+    todos := cacheTestTodos(now)
+
+    if _, err := c.Load(); err != nil {
+        t.Fatal(err)
+    }
+    if !mr.Exists("todo:warm") {
+        t.Fatal("Load did not warm the cache")
+    }
+    for _, todo := range todos {
+        if err := c.Save(todo); err != nil {
+            t.Fatal(err)
+        }
+    }
+    if ok, _ := mr.SIsMember("todo:label:work", "a"); !ok {
+        t.Fatal("saved todo is missing from its label set")
+    }
+
+    a := todos[0]
+    a.Labels = []string{"other"}
+    a.Status = models.StatusInProgress
+    if err := c.Update("a", a); err != nil {
+        t.Fatal(err)
+    }
+    if ok, _ := mr.SIsMember("todo:label:work", "a"); ok {
+        t.Error("updated todo is still in its old label set")
+    }
+    if ok, _ := mr.SIsMember("todo:status:not_started", "a"); ok {
+        t.Error("updated todo is still in its old status set")
+    }
+    if ids := queryIDs(t, c, models.TodoFilter{Labels: []string{"OTHER"}}); !reflect.DeepEqual(ids, []string{"a"}) {
+        t.Errorf("query after update returned %v", ids)
+    }
+
+    if err := c.Delete("b"); err != nil {
+        t.Fatal(err)
+    }
+    if mr.Exists("todo:item:b") {
+        t.Error("deleted todo is still cached")
+    }
+    if ids := queryIDs(t, c, models.TodoFilter{}); !reflect.DeepEqual(ids, []string{"a"}) {
+        t.Errorf("query after delete returned %v", ids)
+    }
+}
+
+func TestCachedStorageQuery(t *testing.T) {
+    mr := miniredis.RunT(t)
+    c := newTestCache(t, mr, NewCSVStorage(filepath.Join(t.TempDir(), "todos.csv")))
+    now := time.Date(2030, 1, 1, 9, 0, 0, 0, time.UTC)
+    if err := c.SaveAll(cacheTestTodos(now)); err != nil {
+        t.Fatal(err)
+    }
+
+    tests := []struct {
+        name   string
+        filter models.TodoFilter
+        want   []string
+    }{
+        {"all", models.TodoFilter{}, []string{"a", "b"}},
+        {"labels ignore case", models.TodoFilter{Labels: []string{"WORK", "home"}}, []string{"a", "b"}},
+        {"status", models.TodoFilter{Statuses: []models.Status{models.StatusCompleted}}, []string{"b"}},
+        {"priority", models.TodoFilter{Priorities: []models.Priority{models.PriorityHigh}}, []string{"a"}},
+        {"fields are combined", models.TodoFilter{Labels: []string{"home"}, Statuses: []models.Status{models.StatusNotStarted}}, nil},
+        {"due after", models.TodoFilter{DueAfter: now.Add(48 * time.Hour)}, []string{"b"}},
+        {"due before is exclusive", models.TodoFilter{DueBefore: now.Add(72 * time.Hour)}, []string{"a"}},
+    }
+    run := func(when string) {
+        for _, tt := range tests {
+            if ids := queryIDs(t, c, tt.filter); !reflect.DeepEqual(ids, tt.want) {
+                t.Errorf("%s, %s: got %v, want %v", when, tt.name, ids, tt.want)
+            }
+        }
+    }
+    run("warm")
+
+    mr.FlushAll()
+    run("cold")
+    if !mr.Exists("todo:warm") {
+        t.Error("a query on a cold cache did not refill it")
+    }
+
+    // The CSV backend cannot query, so the filter is applied to a full load.
+    mr.Close()
+    run("redis down")
+
+    if _, err := c.Query(models.TodoFilter{Period: "today"}); err == nil {
+        t.Error("Query accepted an unresolved period")
+    }
+}
+
+func TestCachedStorageLoadReadsBackend(t *testing.T) {
+    mr := miniredis.RunT(t)
+    csv := NewCSVStorage(filepath.Join(t.TempDir(), "todos.csv"))
+    now := time.Date(2030, 1, 1, 9, 0, 0, 0, time.UTC)
+    todos := cacheTestTodos(now)
+    c := newTestCache(t, mr, csv)
+    if err := c.Save(todos[0]); err != nil {
+        t.Fatal(err)
+    }
+    if _, err := c.Load(); err != nil {
+        t.Fatal(err)
+    }
+
+    // Written while no server ran, leaving the cache warm but stale.
+    if err := csv.Save(todos[1]); err != nil {
+        t.Fatal(err)
+    }
+    if err := csv.Delete("a"); err != nil {
+        t.Fatal(err)
+    }
+
+    restarted := newTestCache(t, mr, csv)
+    loaded, err := restarted.Load()
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(loaded) != 1 || loaded[0].ID != "b" {
+        t.Fatalf("Load after restart returned %+v", loaded)
+    }
+    if ids := queryIDs(t, restarted, models.TodoFilter{Labels: []string{"work", "home"}}); !reflect.DeepEqual(ids, []string{"b"}) {
+        t.Errorf("query after restart returned %v", ids)
+    }
+}
+
+func TestCachedStorageRedisDown(t *testing.T) {
+    mr := miniredis.RunT(t)
+    c := newTestCache(t, mr, NewCSVStorage(filepath.Join(t.TempDir(), "todos.csv")))
+    now := time.Date(2030, 1, 1, 9, 0, 0, 0, time.UTC)
+    todos := cacheTestTodos(now)
+    if err := c.Save(todos[0]); err != nil {
+        t.Fatal(err)
+    }
+    mr.Close()
+
+    if err := c.Save(todos[1]); err != nil {
+        t.Fatalf("a write failed with Redis down: %v", err)
+    }
+    loaded, err := c.Load()
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(loaded) != 2 {
+        t.Fatalf("Load with Redis down returned %+v", loaded)
+    }
+}
@@ -0,0 +1,68 @@
+package storage
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "io/fs"
+    "os"
+    "sync"
+    "time"
+)
+
+// sentRetention is how long a sent reminder is remembered. Reminders are
+// keyed by due date, so older entries can no longer come up again.
+const sentRetention = 90 * 24 * time.Hour
+
+// ReminderLog records which reminders have been sent in a JSON file, so
+// that a restarted server does not send them again. It implements
+// service.ReminderLog.
+type ReminderLog struct {
+    path string
+    mu   sync.Mutex
+    sent map[string]time.Time // key, then when it was sent
+}
+
+// OpenReminderLog reads the log at path, which need not exist yet, and
+// forgets entries older than sentRetention.
+func OpenReminderLog(path string) (*ReminderLog, error) {
+    l := &ReminderLog{path: path, sent: make(map[string]time.Time)}
+    data, err := os.ReadFile(path)
+    if errors.Is(err, fs.ErrNotExist) {
+        return l, nil
+    }
+    if err != nil {
+        return nil, fmt.Errorf("failed to read reminder log: %w", err)
+    }
+    if err := json.Unmarshal(data, &l.sent); err != nil {
+        return nil, fmt.Errorf("invalid reminder log %s: %w", path, err)
+    }
+    cutoff := time.Now().Add(-sentRetention)
+    for key, at := range l.sent {
+        if at.Before(cutoff) {
+            delete(l.sent, key)
+        }
+    }
+    return l, nil
+}
+
+func (l *ReminderLog) Sent(key string) bool {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+    _, ok := l.sent[key]
+    return ok
+}
+
+// MarkSent records key and rewrites the file. The entry is kept in memory
+// even when the write fails.
+func (l *ReminderLog) MarkSent(key string) error {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+    l.sent[key] = time.Now().UTC()
+    return writeFileAtomic(l.path, func(w io.Writer) error {
+        encoder := json.NewEncoder(w)
+        encoder.SetIndent("", "  ")
+        return encoder.Encode(l.sent)
+    })
+}
@@ -0,0 +1,423 @@
+package storage
+
+import (
+    "database/sql"
+    "fmt"
+    "os"
+    "path/filepath"
+    "strings"
+    "time"
+
+    "github.com/read-my-name/restful_todo_app/pkg/models"
+    // Pure-Go SQLite driver, so the server builds without cgo.
+    _ "modernc.org/sqlite"
+)
+
+// sqliteTime is a fixed-width UTC layout, so timestamps stored as text
+// compare and sort correctly in SQL.
+const sqliteTime = "2006-01-02T15:04:05.000000000Z"
+
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS todos (
+    id           TEXT PRIMARY KEY,
+    title        TEXT NOT NULL,
+    description  TEXT NOT NULL DEFAULT '',
+    status       TEXT NOT NULL,
+    priority     TEXT NOT NULL,
+    due_date     TEXT NOT NULL,
+    created_at   TEXT NOT NULL,
+    updated_at   TEXT NOT NULL,
+    completed_at TEXT,
+    archived_at  TEXT,
+    version      INTEGER NOT NULL DEFAULT 1,
+    recurrence   TEXT NOT NULL DEFAULT '',
+    reminders    TEXT NOT NULL DEFAULT ''
+);
+CREATE INDEX IF NOT EXISTS idx_todos_status ON todos(status);
+CREATE INDEX IF NOT EXISTS idx_todos_priority ON todos(priority);
+CREATE INDEX IF NOT EXISTS idx_todos_due_date ON todos(due_date);
+
+CREATE TABLE IF NOT EXISTS todo_labels (
+    todo_id  TEXT NOT NULL REFERENCES todos(id) ON DELETE CASCADE,
+    position INTEGER NOT NULL,
+    label    TEXT NOT NULL,
+    PRIMARY KEY (todo_id, position)
+);
+CREATE INDEX IF NOT EXISTS idx_todo_labels_label ON todo_labels(label COLLATE NOCASE);
+
+CREATE TABLE IF NOT EXISTS subtasks (
+    todo_id    TEXT NOT NULL REFERENCES todos(id) ON DELETE CASCADE,
+    position   INTEGER NOT NULL,
+    id         TEXT NOT NULL,
+    title      TEXT NOT NULL,
+    completed  INTEGER NOT NULL DEFAULT 0,
+    created_at TEXT NOT NULL,
+    updated_at TEXT NOT NULL,
+    PRIMARY KEY (todo_id, position)
+);
+`
+
+// SQLiteStorage keeps todos in a SQLite database, with labels and subtasks
+// in their own tables.
+type SQLiteStorage struct {
+    db *sql.DB
+}
+
+// NewSQLiteStorage opens (creating if needed) the database at path and
+// makes sure the schema exists.
+func NewSQLiteStorage(path string) (*SQLiteStorage, error) {
+    if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+        return nil, fmt.Errorf("failed to create directory: %w", err)
+    }
+
+    // foreign_keys is per connection, so it is set through the DSN.
+    db, err := sql.Open("sqlite", path+"?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)")
+    if err != nil {
+        return nil, fmt.Errorf("failed to open database: %w", err)
+    }
+    if _, err := db.Exec(sqliteSchema); err != nil {
+        db.Close()
+        return nil, fmt.Errorf("failed to create schema: %w", err)
+    }
+    if err := addSQLiteColumns(db); err != nil {
+        db.Close()
+        return nil, err
+    }
+    return &SQLiteStorage{db: db}, nil
+}
+
+// sqliteAddedColumns are the columns of todos added after the table was
+// first created, which CREATE TABLE IF NOT EXISTS does not add to existing
+// databases.
+var sqliteAddedColumns = []struct {
+    name, definition string
+}{
+    {"version", "INTEGER NOT NULL DEFAULT 1"},
+    {"recurrence", "TEXT NOT NULL DEFAULT ''"},
+    {"reminders", "TEXT NOT NULL DEFAULT ''"},
+}
+
+// addSQLiteColumns adds the columns missing from an older database.
+func addSQLiteColumns(db *sql.DB) error {
+    rows, err := db.Query(`SELECT name FROM pragma_table_info('todos')`)
+    if err != nil {
+        return fmt.Errorf("failed to read schema: %w", err)
+    }
+    existing := make(map[string]bool)
+    for rows.Next() {
+        var name string
+        if err := rows.Scan(&name); err != nil {
+            rows.Close()
+            return fmt.Errorf("failed to read schema: %w", err)
+        }
+        existing[name] = true
+    }
+    rows.Close()
+    if err := rows.Err(); err != nil {
+        return fmt.Errorf("failed to read schema: %w", err)
+    }
+
+    for _, column := range sqliteAddedColumns {
+        if existing[column.name] {
+            continue
+        }
+        if _, err := db.Exec(`ALTER TABLE todos ADD COLUMN ` + column.name + ` ` + column.definition); err != nil {
+            return fmt.Errorf("failed to add column %s: %w", column.name, err)
+        }
+    }
+    return nil
+}
+
+// Close releases the database handle.
+func (s *SQLiteStorage) Close() error {
+    return s.db.Close()
+}
+
+func (s *SQLiteStorage) Save(t models.Todo) error {
+    return s.inTx(func(tx *sql.Tx) error {
+        return insertTodo(tx, t)
+    })
+}
+
+func (s *SQLiteStorage) SaveAll(todos []models.Todo) error {
+    return s.inTx(func(tx *sql.Tx) error {
+        if _, err := tx.Exec(`DELETE FROM todos`); err != nil {
+            return fmt.Errorf("failed to clear todos: %w", err)
+        }
+        for _, t := range todos {
+            if err := insertTodo(tx, t); err != nil {
+                return err
+            }
+        }
+        return nil
+    })
+}
+
+func (s *SQLiteStorage) Update(id string, t models.Todo) error {
+    t.ID = id
+    return s.inTx(func(tx *sql.Tx) error {
+        res, err := tx.Exec(`UPDATE todos SET title = ?, description = ?, status = ?, priority = ?,
+            due_date = ?, created_at = ?, updated_at = ?, completed_at = ?, archived_at = ?, version = ?,
+            recurrence = ?, reminders = ? WHERE id = ?`,
+            t.Title, t.Description, string(t.Status), string(t.Priority),
+            formatSQLTime(t.DueDate), formatSQLTime(t.CreatedAt), formatSQLTime(t.UpdatedAt),
+            formatNullSQLTime(t.CompletedAt), formatNullSQLTime(t.ArchivedAt), t.Version, t.Recurrence, strings.Join(t.Reminders, ","), id)
+        if err != nil {
+            return fmt.Errorf("failed to update todo: %w", err)
+        }
+        if n, _ := res.RowsAffected(); n == 0 {
+            return fmt.Errorf("todo with ID %s not found", id)
+        }
+        if _, err := tx.Exec(`DELETE FROM todo_labels WHERE todo_id = ?`, id); err != nil {
+            return fmt.Errorf("failed to clear labels: %w", err)
+        }
+        if _, err := tx.Exec(`DELETE FROM subtasks WHERE todo_id = ?`, id); err != nil {
+            return fmt.Errorf("failed to clear subtasks: %w", err)
+        }
+        return insertChildren(tx, t)
+    })
+}
+
+func (s *SQLiteStorage) Delete(id string) error {
+    res, err := s.db.Exec(`DELETE FROM todos WHERE id = ?`, id)
+    if err != nil {
+        return fmt.Errorf("failed to delete todo: %w", err)
+    }
+    if n, _ := res.RowsAffected(); n == 0 {
+        return fmt.Errorf("todo with ID %s not found", id)
+    }
+    return nil
+}
+
+func (s *SQLiteStorage) Load() ([]models.Todo, error) {
+    return s.Query(models.TodoFilter{})
+}
+
+// Query returns the todos matching filter, evaluating the status, priority,
+// label and due date criteria in SQL. Calendar periods depend on the
+// caller's clock and time zone and must be resolved to DueAfter/DueBefore
+// first.
+func (s *SQLiteStorage) Query(filter models.TodoFilter) ([]models.Todo, error) {
+    if filter.Period != "" && filter.Period != "all" {
+        return nil, fmt.Errorf("period %q must be resolved to a due date range", filter.Period)
+    }
+
+    var where []string
+    var args []interface{}
+    if len(filter.Statuses) > 0 {
+        where = append(where, "t.status IN ("+placeholders(len(filter.Statuses))+")")
+        for _, st := range filter.Statuses {
+            args = append(args, string(st))
+        }
+    }
+    if len(filter.Priorities) > 0 {
+        where = append(where, "t.priority IN ("+placeholders(len(filter.Priorities))+")")
+        for _, p := range filter.Priorities {
+            args = append(args, string(p))
+        }
+    }
+    if len(filter.Labels) > 0 {
+        where = append(where, "EXISTS (SELECT 1 FROM todo_labels l WHERE l.todo_id = t.id AND l.label COLLATE NOCASE IN ("+placeholders(len(filter.Labels))+"))")
+        for _, l := range filter.Labels {
+            args = append(args, l)
+        }
+    }
+    if !filter.DueAfter.IsZero() {
+        where = append(where, "t.due_date >= ?")
+        args = append(args, formatSQLTime(filter.DueAfter))
+    }
+    if !filter.DueBefore.IsZero() {
+        where = append(where, "t.due_date < ?")
+        args = append(args, formatSQLTime(filter.DueBefore))
+    }
+
+    matched := "FROM todos t"
+    if len(where) > 0 {
+        matched += " WHERE " + strings.Join(where, " AND ")
+    }
+    query := `SELECT t.id, t.title, t.description, t.status, t.priority, t.due_date,
+        t.created_at, t.updated_at, t.completed_at, t.archived_at, t.version, t.recurrence, t.reminders ` + matched + ` ORDER BY t.rowid`
+
+    rows, err := s.db.Query(query, args...)
+    if err != nil {
+        return nil, fmt.Errorf("failed to query todos: %w", err)
+    }
+    defer rows.Close()
+
+    todos := []models.Todo{}
+    index := make(map[string]int)
+    for rows.Next() {
+        var t models.Todo
+        var status, priority, due, created, updated string
+        var completed, archived sql.NullString
+        var reminders string
+        if err := rows.Scan(&t.ID, &t.Title, &t.Description, &status, &priority, &due,
+            &created, &updated, &completed, &archived, &t.Version, &t.Recurrence, &reminders); err != nil {
+            return nil, fmt.Errorf("failed to scan todo: %w", err)
+        }
+        t.Status = models.Status(status)
+        t.Priority = models.Priority(priority)
+        var err error
+        if t.DueDate, err = parseSQLTime(due); err != nil {
+            return nil, fmt.Errorf("todo %s has an invalid due_date: %w", t.ID, err)
+        }
+        if t.CreatedAt, err = parseSQLTime(created); err != nil {
+            return nil, fmt.Errorf("todo %s has an invalid created_at: %w", t.ID, err)
+        }
+        if t.UpdatedAt, err = parseSQLTime(updated); err != nil {
+            return nil, fmt.Errorf("todo %s has an invalid updated_at: %w", t.ID, err)
+        }
+        if t.CompletedAt, err = parseNullSQLTime(completed); err != nil {
+            return nil, fmt.Errorf("todo %s has an invalid completed_at: %w", t.ID, err)
+        }
+        if t.ArchivedAt, err = parseNullSQLTime(archived); err != nil {
+            return nil, fmt.Errorf("todo %s has an invalid archived_at: %w", t.ID, err)
+        }
+        if reminders != "" {
+            t.Reminders = strings.Split(reminders, ",")
+        }
+        index[t.ID] = len(todos)
+        todos = append(todos, t)
+    }
+    if err := rows.Err(); err != nil {
+        return nil, fmt.Errorf("failed to read todos: %w", err)
+    }
+
+    // Only the children of matching todos are read.
+    children := ""
+    if len(where) > 0 {
+        children = "WHERE todo_id IN (SELECT t.id " + matched + ")"
+    }
+    if err := s.loadChildren(todos, index, children, args); err != nil {
+        return nil, err
+    }
+    return todos, nil
+}
+
+// loadChildren fills in labels and subtasks for the todos in index,
+// reading the rows that match the WHERE clause where with args.
+func (s *SQLiteStorage) loadChildren(todos []models.Todo, index map[string]int, where string, args []interface{}) error {
+    if len(todos) == 0 {
+        return nil
+    }
+
+    rows, err := s.db.Query(`SELECT todo_id, label FROM todo_labels `+where+` ORDER BY todo_id, position`, args...)
+    if err != nil {
+        return fmt.Errorf("failed to query labels: %w", err)
+    }
+    defer rows.Close()
+    for rows.Next() {
+        var todoID, label string
+        if err := rows.Scan(&todoID, &label); err != nil {
+            return fmt.Errorf("failed to scan label: %w", err)
+        }
+        if i, ok := index[todoID]; ok {
+            todos[i].Labels = append(todos[i].Labels, label)
+        }
+    }
+    if err := rows.Err(); err != nil {
+        return fmt.Errorf("failed to read labels: %w", err)
+    }
+
+    subRows, err := s.db.Query(`SELECT todo_id, id, title, completed, created_at, updated_at
+        FROM subtasks `+where+` ORDER BY todo_id, position`, args...)
+    if err != nil {
+        return fmt.Errorf("failed to query subtasks: %w", err)
+    }
+    defer subRows.Close()
+    for subRows.Next() {
+        var todoID, created, updated string
+        var st models.Subtask
+        if err := subRows.Scan(&todoID, &st.ID, &st.Title, &st.Completed, &created, &updated); err != nil {
+            return fmt.Errorf("failed to scan subtask: %w", err)
+        }
+        var err error
+        if st.CreatedAt, err = parseSQLTime(created); err != nil {
+            return fmt.Errorf("subtask %s has an invalid created_at: %w", st.ID, err)
+        }
+        if st.UpdatedAt, err = parseSQLTime(updated); err != nil {
+            return fmt.Errorf("subtask %s has an invalid updated_at: %w", st.ID, err)
+        }
+        if i, ok := index[todoID]; ok {
+            todos[i].Subtasks = append(todos[i].Subtasks, st)
+        }
+    }
+    if err := subRows.Err(); err != nil {
+        return fmt.Errorf("failed to read subtasks: %w", err)
+    }
+    return nil
+}
+
+func (s *SQLiteStorage) inTx(fn func(tx *sql.Tx) error) error {
+    tx, err := s.db.Begin()
+    if err != nil {
+        return fmt.Errorf("failed to begin transaction: %w", err)
+    }
+    if err := fn(tx); err != nil {
+        tx.Rollback()
+        return err
+    }
+    if err := tx.Commit(); err != nil {
+        return fmt.Errorf("failed to commit transaction: %w", err)
+    }
+    return nil
+}
+
+func insertTodo(tx *sql.Tx, t models.Todo) error {
+    _, err := tx.Exec(`INSERT INTO todos (id, title, description, status, priority, due_date,
+        created_at, updated_at, completed_at, archived_at, version, recurrence, reminders) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+        t.ID, t.Title, t.Description, string(t.Status), string(t.Priority),
+        formatSQLTime(t.DueDate), formatSQLTime(t.CreatedAt), formatSQLTime(t.UpdatedAt),
+        formatNullSQLTime(t.CompletedAt), formatNullSQLTime(t.ArchivedAt), t.Version, t.Recurrence, strings.Join(t.Reminders, ","))
+    if err != nil {
+        return fmt.Errorf("failed to insert todo: %w", err)
+    }
+    return insertChildren(tx, t)
+}
+
+func insertChildren(tx *sql.Tx, t models.Todo) error {
+    for i, label := range t.Labels {
+        if _, err := tx.Exec(`INSERT INTO todo_labels (todo_id, position, label) VALUES (?, ?, ?)`,
+            t.ID, i, label); err != nil {
+            return fmt.Errorf("failed to insert label: %w", err)
+        }
+    }
+    for i, st := range t.Subtasks {
+        if _, err := tx.Exec(`INSERT INTO subtasks (todo_id, position, id, title, completed, created_at, updated_at)
+            VALUES (?, ?, ?, ?, ?, ?, ?)`,
+            t.ID, i, st.ID, st.Title, st.Completed, formatSQLTime(st.CreatedAt), formatSQLTime(st.UpdatedAt)); err != nil {
+            return fmt.Errorf("failed to insert subtask: %w", err)
+        }
+    }
+    return nil
+}
+
+func placeholders(n int) string {
+    return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
+
+func formatSQLTime(t time.Time) string {
+    return t.UTC().Format(sqliteTime)
+}
+
+func formatNullSQLTime(t *time.Time) sql.NullString {
+    if t == nil {
+        return sql.NullString{}
+    }
+    return sql.NullString{String: formatSQLTime(*t), Valid: true}
+}
+
+func parseSQLTime(value string) (time.Time, error) {
+    return time.Parse(sqliteTime, value)
+}
+
+func parseNullSQLTime(value sql.NullString) (*time.Time, error) {
+    if !value.Valid {
+        return nil, nil
+    }
+    t, err := parseSQLTime(value.String)
+    if err != nil {
+        return nil, err
+    }
+    return &t, nil
+}
\ No newline at end of file
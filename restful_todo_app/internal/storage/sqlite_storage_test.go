@@ -0,0 +1,245 @@
+package storage
+
+import (
+    "database/sql"
+    "path/filepath"
+    "reflect"
+    "testing"
+    "time"
+
+    "github.com/read-my-name/restful_todo_app/pkg/models"
+)
+
+func newTestSQLite(t *testing.T) (*SQLiteStorage, string) {
+    t.Helper()
+    path := filepath.Join(t.TempDir(), "todos.db")
+    s, err := NewSQLiteStorage(path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(func() { s.Close() })
+    return s, path
+}
+
+func countRows(t *testing.T, s *SQLiteStorage, table string) int {
+    t.Helper()
+    var n int
+    if err := s.db.QueryRow(`SELECT COUNT(*) FROM ` + table).Scan(&n); err != nil {
+        t.Fatal(err)
+    }
+    return n
+}
+
+// sqliteTestTodos are todos to query: every status, priority, label and a
+// spread of due dates, with subtasks on some.
+func sqliteTestTodos() []models.Todo {
+    created := time.Date(2030, 1, 1, 9, 0, 0, 0, time.UTC)
+    statuses := []models.Status{models.StatusNotStarted, models.StatusInProgress, models.StatusOnHold, models.StatusCompleted}
+    priorities := []models.Priority{models.PriorityLow, models.PriorityMedium, models.PriorityHigh}
+    labels := [][]string{nil, {"Work"}, {"home", "urgent"}, {"work", "Home"}}
+
+    var todos []models.Todo
+    for i := 0; i < 12; i++ {
+        todo := mongoTestTodo(string(rune('a'+i)), "Todo", created)
+        todo.Status = statuses[i%len(statuses)]
+        todo.Priority = priorities[i%len(priorities)]
+        todo.Labels = labels[i%len(labels)]
+        todo.DueDate = created.AddDate(0, 0, i)
+        if i%3 == 0 {
+            todo.Subtasks = []models.Subtask{
+                {ID: todo.ID + "1", Title: "First", CreatedAt: created, UpdatedAt: created},
+                {ID: todo.ID + "2", Title: "Second", Completed: true, CreatedAt: created, UpdatedAt: created},
+            }
+        }
+        todos = append(todos, todo)
+    }
+    return todos
+}
+
+func TestSQLiteSchema(t *testing.T) {
+    s, _ := newTestSQLite(t)
+    want := map[string]string{
+        "todos":                 "table",
+        "todo_labels":           "table",
+        "subtasks":              "table",
+        "idx_todos_status":      "index",
+        "idx_todos_priority":    "index",
+        "idx_todos_due_date":    "index",
+        "idx_todo_labels_label": "index",
+    }
+    for name, kind := range want {
+        var got string
+        if err := s.db.QueryRow(`SELECT type FROM sqlite_master WHERE name = ?`, name).Scan(&got); err != nil || got != kind {
+            t.Errorf("%s: got %q, %v, want a %s", name, got, err, kind)
+        }
+    }
+
+    var foreignKeys int
+    if err := s.db.QueryRow(`PRAGMA foreign_keys`).Scan(&foreignKeys); err != nil || foreignKeys != 1 {
+        t.Errorf("foreign keys are not enforced: %d, %v", foreignKeys, err)
+    }
+}
+
+func TestSQLiteAddsColumnsToOldDatabase(t *testing.T) {
+    path := filepath.Join(t.TempDir(), "todos.db")
+    db, err := sql.Open("sqlite", path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    created := formatSQLTime(time.Date(2030, 1, 1, 9, 0, 0, 0, time.UTC))
+    // The todos table before versions, recurrence and reminders.
+    _, err = db.Exec(`CREATE TABLE todos (
+        id           TEXT PRIMARY KEY,
+        title        TEXT NOT NULL,
+        description  TEXT NOT NULL DEFAULT '',
+        status       TEXT NOT NULL,
+        priority     TEXT NOT NULL,
+        due_date     TEXT NOT NULL,
+        created_at   TEXT NOT NULL,
+        updated_at   TEXT NOT NULL,
+        completed_at TEXT,
+        archived_at  TEXT
+    );
+    INSERT INTO todos (id, title, status, priority, due_date, created_at, updated_at)
+        VALUES ('old', 'Old todo', 'not_started', 'low', ?, ?, ?);`, created, created, created)
+    db.Close()
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    s, err := NewSQLiteStorage(path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer s.Close()
+    todos, err := s.Load()
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(todos) != 1 || todos[0].Version != 1 || todos[0].Recurrence != "" || todos[0].Reminders != nil {
+        t.Fatalf("old todo loaded as %+v", todos)
+    }
+
+    todo := todos[0]
+    todo.Version = 2
+    todo.Recurrence = "FREQ=DAILY"
+    todo.Reminders = []string{"1d", "1h"}
+    if err := s.Update(todo.ID, todo); err != nil {
+        t.Fatal(err)
+    }
+    if got, err := s.Load(); err != nil || !reflect.DeepEqual(got, []models.Todo{todo}) {
+        t.Fatalf("got %+v, %v, want %+v", got, err, todo)
+    }
+
+    // Opening it again finds nothing to add.
+    s.Close()
+    if s, err = NewSQLiteStorage(path); err != nil {
+        t.Fatalf("reopening the migrated database: %v", err)
+    }
+}
+
+func TestSQLiteUpdateAndDeleteChildren(t *testing.T) {
+    s, _ := newTestSQLite(t)
+    todo := sqliteTestTodos()[3]
+    todo.Subtasks = sqliteTestTodos()[0].Subtasks
+    done := todo.CreatedAt.Add(time.Hour)
+    todo.CompletedAt = &done
+    if err := s.Save(todo); err != nil {
+        t.Fatal(err)
+    }
+    if got, err := s.Load(); err != nil || !reflect.DeepEqual(got, []models.Todo{todo}) {
+        t.Fatalf("got %+v, %v, want %+v", got, err, todo)
+    }
+
+    todo.Labels = []string{"errands"}
+    todo.Subtasks = todo.Subtasks[1:]
+    if err := s.Update(todo.ID, todo); err != nil {
+        t.Fatal(err)
+    }
+    if got, err := s.Load(); err != nil || !reflect.DeepEqual(got, []models.Todo{todo}) {
+        t.Fatalf("after update got %+v, %v, want %+v", got, err, todo)
+    }
+    if labels, subtasks := countRows(t, s, "todo_labels"), countRows(t, s, "subtasks"); labels != 1 || subtasks != 1 {
+        t.Fatalf("after update %d labels and %d subtasks are stored", labels, subtasks)
+    }
+
+    if err := s.Delete(todo.ID); err != nil {
+        t.Fatal(err)
+    }
+    if labels, subtasks := countRows(t, s, "todo_labels"), countRows(t, s, "subtasks"); labels != 0 || subtasks != 0 {
+        t.Fatalf("after delete %d labels and %d subtasks are left", labels, subtasks)
+    }
+    if err := s.Delete(todo.ID); err == nil {
+        t.Fatal("deleting a missing todo succeeded")
+    }
+    if err := s.Update(todo.ID, todo); err == nil {
+        t.Fatal("updating a missing todo succeeded")
+    }
+}
+
+func TestSQLiteQueryMatchesFilter(t *testing.T) {
+    s, _ := newTestSQLite(t)
+    todos := sqliteTestTodos()
+    if err := s.SaveAll(todos); err != nil {
+        t.Fatal(err)
+    }
+    created := todos[0].CreatedAt
+    filters := []models.TodoFilter{
+        {},
+        {Period: "all"},
+        {Statuses: []models.Status{models.StatusInProgress, models.StatusCompleted}},
+        {Priorities: []models.Priority{models.PriorityHigh}},
+        {Labels: []string{"WORK"}},
+        {Labels: []string{"home", "urgent"}, Priorities: []models.Priority{models.PriorityLow, models.PriorityMedium}},
+        {DueAfter: created.AddDate(0, 0, 2), DueBefore: created.AddDate(0, 0, 5)},
+        {DueAfter: created.AddDate(0, 0, 2).Add(24 * time.Hour), Statuses: []models.Status{models.StatusNotStarted}},
+        {Labels: []string{"nothing"}},
+    }
+    for _, filter := range filters {
+        got, err := s.Query(filter)
+        if err != nil {
+            t.Fatalf("%+v: %v", filter, err)
+        }
+        want := []models.Todo{}
+        for _, todo := range todos {
+            if matchesFilter(todo, filter) {
+                want = append(want, todo)
+            }
+        }
+        if !reflect.DeepEqual(got, want) {
+            t.Errorf("%+v: got %d todos %v, want %d", filter, len(got), got, len(want))
+        }
+    }
+
+    if _, err := s.Query(models.TodoFilter{Period: "today"}); err == nil {
+        t.Error("an unresolved period was accepted")
+    }
+}
+
+func TestSQLiteQueryReadsOnlyMatchingChildren(t *testing.T) {
+    s, _ := newTestSQLite(t)
+    todos := sqliteTestTodos()
+    if err := s.SaveAll(todos); err != nil {
+        t.Fatal(err)
+    }
+    // A subtask of a not started todo that cannot be read.
+    if _, err := s.db.Exec(`UPDATE subtasks SET created_at = 'garbage' WHERE todo_id = ?`, todos[0].ID); err != nil {
+        t.Fatal(err)
+    }
+    if _, err := s.Load(); err == nil {
+        t.Fatal("the bad subtask was not read by Load")
+    }
+
+    got, err := s.Query(models.TodoFilter{Statuses: []models.Status{models.StatusCompleted}})
+    if err != nil {
+        t.Fatalf("a query for other todos read the bad subtask: %v", err)
+    }
+    for _, todo := range got {
+        if want := todos[todo.ID[0]-'a']; !reflect.DeepEqual(todo, want) {
+            t.Errorf("got %+v, want %+v", todo, want)
+        }
+    }
+    if len(got) != 3 {
+        t.Fatalf("got %d completed todos, want 3", len(got))
+    }
+}
@@ -20,6 +20,7 @@
 package models
 
 import (
+    "encoding/json"
     "time"
 )
 
@@ -42,6 +43,22 @@ const (
     PriorityCritical Priority = "critical"
 )
 
+// Rank orders priorities by severity, from low to critical. Unknown
+// priorities rank below low.
+func (p Priority) Rank() int {
+    switch p {
+    case PriorityLow:
+        return 1
+    case PriorityMedium:
+        return 2
+    case PriorityHigh:
+        return 3
+    case PriorityCritical:
+        return 4
+    }
+    return 0
+}
+
 type Todo struct {
     ID          string     `json:"id"`          // Changed to UUID
     Title       string     `json:"title"`
@@ -53,6 +70,43 @@ type Todo struct {
     UpdatedAt   time.Time  `json:"updated_at"`  // New field
     Labels      []string   `json:"labels"`      // New labels/tags
     Subtasks    []Subtask  `json:"subtasks"`    // New subtasks
+    CompletedAt *time.Time `json:"completed_at,omitempty"` // Set by the service on completion
+    ArchivedAt  *time.Time `json:"archived_at,omitempty"`  // Set by the service on archival
+    Version     int        `json:"version"`                // Incremented by the service on every change
+    Recurrence  string     `json:"recurrence,omitempty"`   // RRULE subset, e.g. FREQ=WEEKLY;BYDAY=MO
+    Reminders   []string   `json:"reminders,omitempty"`    // How long before the due date to remind, e.g. "1d", "1h"
+    Virtual     bool       `json:"virtual,omitempty"`      // A future occurrence of a recurring todo, listed but not stored
+}
+
+// Progress summarizes how many of a todo's subtasks are completed.
+type Progress struct {
+    Completed   int  `json:"completed"`
+    Total       int  `json:"total"`
+    Percent     int  `json:"percent"`
+}
+
+// Progress computes the subtask completion of the todo.
+func (t Todo) Progress() Progress {
+    p := Progress{Total: len(t.Subtasks)}
+    for _, st := range t.Subtasks {
+        if st.Completed {
+            p.Completed++
+        }
+    }
+    if p.Total > 0 {
+        p.Percent = p.Completed * 100 / p.Total
+    }
+    return p
+}
+
+// MarshalJSON adds the computed progress to the todo's JSON form. It is
+// output only; "progress" is ignored when a todo is decoded.
+func (t Todo) MarshalJSON() ([]byte, error) {
+    type todoJSON Todo
+    return json.Marshal(struct {
+        todoJSON
+        Progress Progress `json:"progress"`
+    }{todoJSON(t), t.Progress()})
 }
 
 type Subtask struct {
@@ -64,8 +118,69 @@ type Subtask struct {
 }
 
 type TodoFilter struct {
-    Period      string      `json:"period"`   // today, week, month
-    Statuses    []Status    `json:"statuses"` // Filter by multiple statuses
-    Priorities  []Priority  `json:"priorities"`
-    Labels      []string    `json:"labels"`
-}
\ No newline at end of file
+    Period      string          `json:"period"`   // today, tomorrow, week, month, overdue, upcoming
+    Statuses    []Status        `json:"statuses"` // Filter by multiple statuses
+    Priorities  []Priority      `json:"priorities"`
+    Labels      []string        `json:"labels"`
+    DueAfter    time.Time       `json:"due_after"`  // inclusive, ignored when zero
+    DueBefore   time.Time       `json:"due_before"` // exclusive, ignored when zero
+    Location    *time.Location  `json:"-"`          // time zone for calendar periods
+}
+
+// ListOptions controls the order and window of a todo listing.
+type ListOptions struct {
+    Sort    string  // comma separated fields, "-" prefix for descending
+    Limit   int
+    Offset  int
+    Cursor  string  // opaque position returned as TodoPage.NextCursor
+}
+
+// TodoPage is one page of a todo listing.
+type TodoPage struct {
+    Items       []Todo             `json:"items"`
+    Total       int                `json:"total"`   // matches before paging
+    Limit       int                `json:"limit"`
+    Offset      int                `json:"offset"`
+    NextCursor  string             `json:"next_cursor,omitempty"`
+    Links       map[string]string  `json:"links,omitempty"`
+}
+
+// SearchHit is a todo found by a search, with its relevance.
+type SearchHit struct {
+    Todo        Todo               `json:"todo"`
+    Score       float64            `json:"score"`
+}
+
+// SearchResults is one page of search hits.
+type SearchResults struct {
+    Items       []SearchHit        `json:"items"`
+    Total       int                `json:"total"`   // matches before paging
+    Limit       int                `json:"limit"`
+    Offset      int                `json:"offset"`
+    Links       map[string]string  `json:"links,omitempty"`
+}
+// StorageHealth reports problems the storage found in its data.
+type StorageHealth struct {
+    Status      string         `json:"status"`                 // ok, or degraded when rows were skipped
+    Backend     string         `json:"backend,omitempty"`
+    Mode        string         `json:"mode,omitempty"`         // strict or lenient loading
+    Skipped     int            `json:"skipped"`
+    Rejects     []RejectedRow  `json:"rejects,omitempty"`
+    RejectsFile string         `json:"rejects_file,omitempty"` // where skipped rows were quarantined
+}
+
+// RejectedRow describes a stored row that could not be read.
+type RejectedRow struct {
+    Line       int        `json:"line"`
+    Column     string     `json:"column,omitempty"`
+    Reason     string     `json:"reason"`
+    RejectedAt time.Time  `json:"rejected_at"`
+}
+
+// Reminder is a notification that a todo is coming due.
+type Reminder struct {
+    Todo       Todo       `json:"todo"`
+    Offset     string     `json:"offset"`   // the entry of Todo.Reminders that fired
+    FireAt     time.Time  `json:"fire_at"`  // when it was due to be sent
+    Attempt    int        `json:"attempt"`  // 1 for the first delivery attempt
+}